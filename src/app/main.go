@@ -4,21 +4,225 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"Go_emu/src/cpu"
+	"Go_emu/src/machine"
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Appmodel struct {
 	emulator *cpu.Cpu
+	core     cpu.Core //timing model driving emulator
+	control  *runControl
+	input    *cpu.InputLog //host input reaches guest through it
+	record   *bufio.Writer //input log file
+	board    board
+	console  *consoleView //uart output of virt machine,nil on bare
 }
 
+// machine profile app runs,clocked instead of bare core
+type board interface {
+	cpu.Snapper
+	ClockCycle()
+	AttachInput(l *cpu.InputLog)
+	Halted() bool
+}
+
+// bare board has no devices,core is clocked directly
+type bareBoard struct {
+	*machine.Bare
+	cpu.Core
+}
+
+func (b bareBoard) Halted() bool {
+	return false
+}
+
+// last lines uart wrote,guarded as hart writes it from clock goroutine
+type consoleView struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+const CONSOLE_LINES = 24
+
+func (c *consoleView) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buf = append(c.buf, p...)
+	if len(c.buf) > 1<<16 {
+		c.buf = append([]byte{}, c.buf[len(c.buf)-1<<15:]...)
+	}
+	return len(p), nil
+}
+
+func (c *consoleView) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lines := strings.Split(strings.ReplaceAll(string(c.buf), "\r", ""), "\n")
+	if len(lines) > CONSOLE_LINES {
+		lines = lines[len(lines)-CONSOLE_LINES:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// step/run state shared by clock goroutine and key handler
+type runControl struct {
+	mu     sync.Mutex
+	paused bool
+	travel *cpu.TimeTravel
+	status string //result of last command
+}
+
+var dumpDtb = flag.String("dump-dtb", "", "write generated device tree blob to file")
+var caches = flag.Bool("caches", false, "add default l1 instruction, data and unified l2 caches")
+var ramWait = flag.Uint("ram-wait", 0, "wait states of ram read and write")
+var singlePort = flag.Bool("single-port", false, "instruction fetch and data access share one memory port")
+var headless = flag.Bool("headless", false, "run without display and print statistics report")
+var cycles = flag.Uint64("cycles", 1000000, "cycles of headless run")
+var stats = flag.String("stats", "text", "statistics report format of headless run: text or json")
+var konata = flag.String("konata", "", "write kanata pipeline log of headless in-order run to file")
+var commitLog = flag.String("commit-log", "", "write spike style log of retired instructions of headless run to file")
+var lockstep = flag.Bool("lockstep", false, "check headless run against golden model,stop at first mismatch")
+var loadSnapshot = flag.String("load-snapshot", "", "restore core state from snapshot file before run")
+var saveSnapshot = flag.String("save-snapshot", "", "write core state to snapshot file at end of headless run")
+var travel = flag.Bool("travel", false, "record run for reverse-step and reverse-continue")
+var watch = flag.Uint64("watch", 0, "physical address reverse-continue stops at last write to")
+var breakpoint = flag.Uint64("break", 0, "pc run stops at and reverse-continue goes back to,when watch is not set")
+var rom = flag.String("rom", "../cpu/test_roms/PrintDigits_rom", "bare metal rom loaded at address 0")
+var recordInput = flag.String("record-input", "", "write keys and other input guest observed with their cycles to file")
+var replayInput = flag.String("replay-input", "", "feed input recorded by -record-input back at same cycles,keys are ignored")
+var machineName = flag.String("machine", "bare", "machine profile: bare or virt")
+var image = flag.String("image", "", "firmware,kernel or elf image of virt machine,raw image is loaded at 0x80000000")
+var ramSize = flag.Uint("ram-size", 64<<20, "ram size of virt machine in bytes")
+var core = flag.String("core", "inorder", "core model: inorder or ooo")
+var pipeline = flag.String("pipeline", cpu.CLASSIC_5.Name, "pipeline layout: single-cycle, multi-cycle, 5-stage, 7-stage, 8-stage or dual-issue")
+
 func initialModel() Appmodel {
 
-	model := Appmodel{emulator: &cpu.Cpu{}}
-	model.emulator.LoadRom("../cpu/test_roms/PrintDigits_rom")
+	model := Appmodel{emulator: &cpu.Cpu{}, control: &runControl{}}
+	config, ok := cpu.Pipelines[*pipeline]
+	if !ok {
+		log.Fatalf("unknown pipeline %s", *pipeline)
+	}
+	if err := model.emulator.SetPipeline(config); err != nil {
+		log.Fatal(err)
+	}
+	if *caches {
+		l2 := cpu.DEFAULT_L2
+		if err := model.emulator.SetCaches(cpu.DEFAULT_L1I, cpu.DEFAULT_L1D, &l2); err != nil {
+			log.Fatal(err)
+		}
+	}
+	model.emulator.RamWait = cpu.WaitStates{Read: uint32(*ramWait), Write: uint32(*ramWait)}
+	model.emulator.SinglePort = *singlePort
+	switch *core {
+	case "inorder":
+		model.core = model.emulator
+	case "ooo":
+		model.core = cpu.NewOutOfOrder(model.emulator, cpu.DEFAULT_OOO)
+	default:
+		log.Fatalf("unknown core %s", *core)
+	}
+	var dtb []byte
+	switch *machineName {
+	case "bare":
+		model.emulator.LoadRom(*rom)
+		bare := machine.NewBare(model.emulator)
+		model.board, dtb = bareBoard{bare, model.core}, bare.Dtb
+	case "virt":
+		//headless report keeps stdout
+		var console io.Writer = os.Stderr
+		if !*headless {
+			model.console = &consoleView{}
+			console = model.console
+		}
+		virt, err := machine.NewVirt(model.emulator, uint32(*ramSize), console)
+		if err != nil {
+			log.Fatal(err)
+		}
+		virt.Core = model.core
+		if *image != "" {
+			if err := loadImage(virt, *image); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *travel {
+			log.Fatal("-travel supports bare machine only")
+		}
+		model.board, dtb = virt, virt.Dtb
+	default:
+		log.Fatalf("unknown machine %s", *machineName)
+	}
+	if *dumpDtb != "" {
+		if err := os.WriteFile(*dumpDtb, dtb, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+	//without file input log only moves keys to cycle boundary
+	model.input = cpu.NewInputRecorder(nil)
+	switch {
+	case *replayInput != "":
+		f, err := os.Open(*replayInput)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		if model.input, err = cpu.NewInputReplay(f); err != nil {
+			log.Fatal(err)
+		}
+	case *recordInput != "":
+		f, err := os.Create(*recordInput)
+		if err != nil {
+			log.Fatal(err)
+		}
+		model.record = bufio.NewWriter(f)
+		model.input = cpu.NewInputRecorder(model.record)
+	}
+	model.board.AttachInput(model.input)
+	//configuration flags are replaced by saved one
+	if *loadSnapshot != "" {
+		f, err := os.Open(*loadSnapshot)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		if err := cpu.LoadSnapshot(bufio.NewReader(f), model.board); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *travel {
+		t, err := cpu.NewTimeTravel(model.core)
+		if err != nil {
+			log.Fatal(err)
+		}
+		model.control.travel = t
+	}
 	return model
 }
 
+// elf goes to its load addresses and sets pc,
+// anything else is raw image at start of dram
+func loadImage(virt *machine.Virt, path string) error {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(data, []byte(elf.ELFMAG)) {
+		return virt.LoadElf(bytes.NewReader(data))
+	}
+	return virt.LoadImage(data, machine.VIRT_DRAM_BASE)
+}
+
 type stepMsg time.Time
 
 func stepAnimation() tea.Cmd {
@@ -30,13 +234,111 @@ func (m Appmodel) Init() tea.Cmd {
 
 	go func() {
 		for {
-			m.emulator.ClockCycle()
+			c := m.control
+			c.mu.Lock()
+			if !c.paused && !m.board.Halted() {
+				m.board.ClockCycle()
+				if *breakpoint != 0 && c.travel != nil && c.travel.Err() == nil && cpu.RetiredAt(*breakpoint)(c.travel.Record(c.travel.Cycle())) {
+					c.paused = true
+					c.status = fmt.Sprintf("breakpoint %#x", *breakpoint)
+				}
+			}
+			c.mu.Unlock()
 			time.Sleep(time.Microsecond * 1)
 		}
 
 	}()
 	return stepAnimation()
 }
+
+// space toggles run,n steps one cycle,b steps back one
+// and r goes back to last write of watch address or breakpoint
+func (m Appmodel) command(key string) {
+
+	c := m.control
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status = ""
+	switch key {
+	case " ":
+		c.paused = !c.paused
+		return
+	case "n":
+		c.paused = true
+		m.board.ClockCycle()
+		return
+	}
+	c.paused = true
+	if c.travel == nil {
+		c.status = "reverse execution needs -travel"
+		return
+	}
+	var err error
+	switch key {
+	case "b":
+		err = c.travel.ReverseStep()
+	case "r":
+		match, target := cpu.RetiredAt(*breakpoint), fmt.Sprintf("breakpoint %#x", *breakpoint)
+		if *watch != 0 {
+			match, target = cpu.WroteTo(*watch, 1), fmt.Sprintf("write to %#x", *watch)
+		}
+		var found bool
+		if found, err = c.travel.ReverseContinue(match); err == nil && !found {
+			err = fmt.Errorf("no recorded %s", target)
+		}
+	}
+	if err != nil {
+		c.status = err.Error()
+	}
+}
+
+// bytes guest receives for key,nil for keys without one
+func keyBytes(k tea.KeyMsg) []byte {
+	switch k.Type {
+	case tea.KeyRunes:
+		return []byte(string(k.Runes))
+	case tea.KeyEnter:
+		return []byte{'\r'}
+	case tea.KeyTab:
+		return []byte{'\t'}
+	case tea.KeyBackspace:
+		return []byte{0x7f}
+	case tea.KeyEsc:
+		return []byte{0x1b}
+	}
+	return nil
+}
+
+// cycle,run state and last write of watch address
+func (m Appmodel) statusLine() string {
+
+	c := m.control
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := "running"
+	if c.paused {
+		state = "paused"
+	}
+	if m.board.Halted() {
+		state = "powered off"
+	}
+	line := fmt.Sprintf("cycle %d %s", m.emulator.Stats.Cycles, state)
+	if c.travel != nil && *watch != 0 {
+		if w, cycle, ok := c.travel.LastWrite(*watch, 1); ok {
+			line += fmt.Sprintf(" | last write %#x pc %#x %#x->%#x at cycle %d", w.Address, w.Pc, w.Old, w.New, cycle)
+		}
+	}
+	if c.status != "" {
+		line += " | " + c.status
+	}
+	if err := m.input.Err(); err != nil {
+		line += " | " + err.Error()
+	}
+	if c.travel != nil && c.travel.Err() != nil {
+		line += " | " + c.travel.Err().Error()
+	}
+	return line
+}
 func (m Appmodel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
@@ -49,6 +351,17 @@ func (m Appmodel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "ctrl+c", "q":
 			return m, tea.Quit
 
+		case " ", "n", "b", "r":
+			m.command(msg.String())
+
+		default:
+			if data := keyBytes(msg); data != nil {
+				source := "key"
+				if m.console != nil {
+					source = "uart"
+				}
+				m.input.Push(source, data)
+			}
 		}
 	case stepMsg:
 
@@ -62,10 +375,16 @@ func (m Appmodel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 func (m Appmodel) View() string {
 
 	view := strings.Builder{}
+	if m.console != nil {
+		view.WriteString(m.console.String())
+		view.WriteRune('\n')
+		view.WriteString(m.statusLine())
+		return view.String()
+	}
 	counter := 0
-	for y := 0; y < 32; y++ {
-		for x := 0; x < 64; x++ {
-			if m.emulator.Ram.GetLine(uint32(30032+counter)) == 1 {
+	for y := 0; y < machine.FRAMEBUFFER_HEIGHT; y++ {
+		for x := 0; x < machine.FRAMEBUFFER_WIDTH; x++ {
+			if m.emulator.Ram.GetLine(uint32(machine.FRAMEBUFFER_BASE+counter)) == 1 {
 				s := lipgloss.NewStyle().SetString("***").Background(lipgloss.Color("#FAFAFA"))
 				view.WriteString(s.String())
 			} else {
@@ -78,12 +397,97 @@ func (m Appmodel) View() string {
 		view.WriteRune('\n')
 
 	}
+	view.WriteString(m.statusLine())
 	return view.String()
 }
 
 func main() {
 
-	p := tea.NewProgram(initialModel())
+	flag.Parse()
+	if *headless {
+		runHeadless(initialModel())
+		return
+	}
+	m := initialModel()
+	p := tea.NewProgram(m)
 	p.Run()
+	if m.record != nil {
+		if err := m.record.Flush(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+}
+
+// clocks core without display,report goes to stdout
+func runHeadless(m Appmodel) {
+
+	var logs []*bufio.Writer
+	if m.record != nil {
+		logs = append(logs, m.record)
+	}
+	create := func(path string) io.Writer {
+		f, err := os.Create(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		w := bufio.NewWriter(f)
+		logs = append(logs, w)
+		return w
+	}
+	if *konata != "" {
+		m.emulator.Trace = cpu.NewKonataTrace(create(*konata))
+	}
+	if *commitLog != "" {
+		m.emulator.CommitLog = cpu.NewCommitLog(create(*commitLog))
+	}
+	var golden *cpu.Lockstep
+	if *lockstep {
+		var err error
+		if golden, err = cpu.NewLockstep(m.emulator); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for i := uint64(0); i < *cycles; i++ {
+		m.board.ClockCycle()
+		if m.board.Halted() || golden != nil && golden.Err() != nil || m.input.Err() != nil {
+			break
+		}
+	}
+	if t := m.emulator.Trace; t != nil && t.Err() != nil {
+		log.Fatal(t.Err())
+	}
+	if c := m.emulator.CommitLog; c != nil && c.Err() != nil {
+		log.Fatal(c.Err())
+	}
+	if *saveSnapshot != "" {
+		if err := cpu.SaveSnapshot(create(*saveSnapshot), m.board); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, w := range logs {
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+	}
 
+	report := m.core.Report()
+	var err error
+	switch *stats {
+	case "text":
+		err = report.WriteText(os.Stdout)
+	case "json":
+		err = report.WriteJSON(os.Stdout)
+	default:
+		log.Fatalf("unknown statistics format %s", *stats)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if golden != nil && golden.Err() != nil {
+		log.Fatal(golden.Err())
+	}
+	if m.input.Err() != nil {
+		log.Fatal(m.input.Err())
+	}
 }
@@ -0,0 +1,125 @@
+package cpu
+
+const AMO = 0b0101111
+
+// A extension funct5 (funct7[6:2]),aq/rl bits are ignored
+// since pipeline performs memory operations in order
+const (
+	AMOADD  = 0b00000
+	AMOSWAP = 0b00001
+	LR      = 0b00010
+	SC      = 0b00011
+	AMOXOR  = 0b00100
+	AMOOR   = 0b01000
+	AMOAND  = 0b01100
+	AMOMIN  = 0b10000
+	AMOMAX  = 0b10100
+	AMOMINU = 0b11000
+	AMOMAXU = 0b11100
+)
+
+const (
+	CAUSE_LOAD_MISALIGNED  = 4
+	CAUSE_STORE_MISALIGNED = 6
+)
+
+// load reserved/store conditional reservation set
+type reservation struct {
+	valid   bool
+	address uint64
+}
+
+// LR,SC and AMO* are prepared in execute,
+// memory stage does read-modify-write
+func (cpu *Cpu) atomic(inst *Instruction) {
+
+	funct5 := inst.funct7 >> 2
+	size := uint32(4)
+	if inst.funct3 == 0x3 && cpu.xlen() == 64 {
+		size = 8
+	} else if inst.funct3 != 0x2 {
+		inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline))
+		return
+	}
+
+	switch funct5 {
+	case LR, SC, AMOSWAP, AMOADD, AMOXOR, AMOAND, AMOOR, AMOMIN, AMOMAX, AMOMINU, AMOMAXU:
+	default:
+		inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline))
+		return
+	}
+
+	address := inst.rs1
+	if address%uint64(size) != 0 {
+		if funct5 == LR {
+			inst.raise(CAUSE_LOAD_MISALIGNED, address)
+		} else {
+			inst.raise(CAUSE_STORE_MISALIGNED, address)
+		}
+		return
+	}
+
+	optype := AMO_RMW
+	if funct5 == LR {
+		optype = LOAD
+	}
+	inst.memop = &Memops{
+		optype:  optype,
+		address: address,
+		data:    inst.rs2,
+		amo:     funct5,
+		signed:  true,
+	}
+}
+
+// memory stage part of atomic,wbop gets old memory value
+// or SC result
+func (cpu *Cpu) atomicMemOps(inst *Instruction, size uint32) {
+
+	m := inst.memop
+	switch m.amo {
+	case LR:
+		cpu.reserved = reservation{valid: true, address: m.address}
+		inst.wbop.data = cpu.trunc(SignExtend64(cpu.readPhys(m.address, size), uint8(size*8)))
+		return
+	case SC:
+		if cpu.reserved.valid && cpu.reserved.address == m.address {
+			cpu.writePhys(inst, m.address, size, m.data)
+			inst.wbop.data = 0
+		} else {
+			inst.wbop.data = 1
+		}
+		cpu.reserved.valid = false
+		return
+	}
+
+	old := SignExtend64(cpu.readPhys(m.address, size), uint8(size*8))
+	src := SignExtend64(m.data, uint8(size*8))
+	//unsigned compare on size bytes
+	mask := ^uint64(0) >> (64 - size*8)
+	var res uint64
+	switch m.amo {
+	case AMOSWAP:
+		res = src
+	case AMOADD:
+		res = old + src
+	case AMOXOR:
+		res = old ^ src
+	case AMOAND:
+		res = old & src
+	case AMOOR:
+		res = old | src
+	case AMOMIN:
+		res = uint64(min(int64(old), int64(src)))
+	case AMOMAX:
+		res = uint64(max(int64(old), int64(src)))
+	case AMOMINU:
+		res = min(old&mask, src&mask)
+	case AMOMAXU:
+		res = max(old&mask, src&mask)
+	}
+	cpu.writePhys(inst, m.address, size, res)
+	//commit log reports value written
+	m.data = res
+	inst.wbop.data = cpu.trunc(old)
+}
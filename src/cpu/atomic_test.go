@@ -0,0 +1,55 @@
+package cpu
+
+import (
+	"testing"
+)
+
+func TestAtomics(t *testing.T) {
+
+	var program = []uint32{
+		0x10000513, //addi a0, x0, 0x100
+		0x00500293, //addi t0, x0, 5
+		0x00300313, //addi t1, x0, 3
+		0xffe00393, //addi t2, x0, -2
+		0x00552023, //sw t0, 0(a0)
+		0x006525af, //amoadd.w a1, t1, (a0)
+		0x00b589b3, //add s3, a1, a1
+		0x0875262f, //amoswap.w a2, t2, (a0)
+		0x806526af, //amomin.w a3, t1, (a0)
+		0xe065272f, //amomaxu.w a4, t1, (a0)
+		0x100527af, //lr.w a5, (a0)
+		0x1865282f, //sc.w a6, t1, (a0)
+		0x185528af, //sc.w a7, t0, (a0)
+		0x00052903, //lw s2, 0(a0)
+	}
+
+	cpu := Cpu{}
+	for i, v := range program {
+		cpu.Ram.SetLine(uint32(i*4), v)
+	}
+	l, _ := NewLockstep(&cpu)
+	for i := 0; i < 100; i++ {
+		cpu.ClockCycle()
+	}
+	if l.Err() != nil || l.Checked != uint64(len(program)) {
+		t.Errorf("\"TestAtomics()\" FAILED, golden model checked %d %v", l.Checked, l.Err())
+	}
+
+	expected := map[uint32]uint64{
+		11: 5,           //amoadd old value
+		19: 10,          //result of amo used by next instruction
+		12: 8,           //amoswap old value
+		13: 0xFFFF_FFFE, //amomin keeps -2
+		14: 0xFFFF_FFFE, //amomaxu keeps 0xfffffffe
+		15: 0xFFFF_FFFE, //lr
+		16: 0,           //sc with reservation
+		17: 1,           //sc without reservation
+		18: 3,
+	}
+	for reg, val := range expected {
+		if cpu.regFile.GetRegVal(reg) != val {
+			t.Errorf("\"TestAtomics()\" FAILED, x%d expected -> %x got -> %x", reg, val, cpu.regFile.GetRegVal(reg))
+		}
+	}
+
+}
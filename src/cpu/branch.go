@@ -0,0 +1,377 @@
+package cpu
+
+const (
+	JAL  = 0b1101111
+	JALR = 0b1100111
+)
+
+const (
+	DEFAULT_BTB_ENTRIES     = 64
+	DEFAULT_RAS_DEPTH       = 8
+	DEFAULT_BIMODAL_ENTRIES = 512
+)
+
+// control transfer kinds kept in btb,
+// calls push and returns pop return address stack
+type BranchKind uint8
+
+const (
+	BRANCH_NONE BranchKind = iota
+	BRANCH_COND
+	BRANCH_JUMP
+	BRANCH_CALL
+	BRANCH_RETURN
+	BRANCH_INDIRECT
+)
+
+// x1 and x5 are link registers
+func isLink(reg uint32) bool {
+	return reg == 1 || reg == 5
+}
+
+// kind of decoded instruction,hint rules of jal/jalr rd and rs1
+func branchKind(inst *Instruction) BranchKind {
+	switch {
+	case inst.instype == B:
+		return BRANCH_COND
+	case inst.opcode == JAL && isLink(inst.rd):
+		return BRANCH_CALL
+	case inst.opcode == JAL:
+		return BRANCH_JUMP
+	case inst.opcode == JALR && isLink(inst.rd):
+		return BRANCH_CALL
+	case inst.opcode == JALR && isLink(inst.rs1_index):
+		return BRANCH_RETURN
+	case inst.opcode == JALR:
+		return BRANCH_INDIRECT
+	}
+	return BRANCH_NONE
+}
+
+// direction predictor of conditional branches,
+// target comes from btb
+type DirectionPredictor interface {
+	Name() string
+	Predict(pc uint64, target uint64) bool
+	Update(pc uint64, taken bool)
+}
+
+type StaticNotTaken struct{}
+
+func (StaticNotTaken) Name() string                { return "not-taken" }
+func (StaticNotTaken) Predict(uint64, uint64) bool { return false }
+func (StaticNotTaken) Update(uint64, bool)         {}
+
+// backward taken,forward not taken,loops are taken
+type Btfn struct{}
+
+func (Btfn) Name() string                          { return "btfn" }
+func (Btfn) Predict(pc uint64, target uint64) bool { return target < pc }
+func (Btfn) Update(uint64, bool)                   {}
+
+// 2 bit saturating counter,taken from 2 up
+func counterUpdate(c uint8, taken bool) uint8 {
+	if taken && c < 3 {
+		return c + 1
+	}
+	if !taken && c > 0 {
+		return c - 1
+	}
+	return c
+}
+
+// direction predictor indexed with global history,branch trains
+// counter it was predicted with,history moves on while it is in flight
+type historyPredictor interface {
+	History() uint64
+	UpdateWith(pc uint64, history uint64, taken bool)
+}
+
+// table of 2 bit counters indexed by pc
+type Bimodal struct {
+	counters []uint8
+}
+
+func NewBimodal(entries int) *Bimodal {
+	b := &Bimodal{counters: make([]uint8, entries)}
+	//weakly not taken
+	for i := range b.counters {
+		b.counters[i] = 1
+	}
+	return b
+}
+
+func (b *Bimodal) index(pc uint64) uint64 {
+	return pc >> 2 % uint64(len(b.counters))
+}
+
+func (b *Bimodal) Name() string { return "bimodal" }
+
+func (b *Bimodal) Predict(pc uint64, target uint64) bool {
+	return b.counters[b.index(pc)] >= 2
+}
+
+func (b *Bimodal) Update(pc uint64, taken bool) {
+	i := b.index(pc)
+	b.counters[i] = counterUpdate(b.counters[i], taken)
+}
+
+// 2 bit counters indexed by pc xor global history,
+// history is updated when branch resolves
+// and branch trains counter of fetch time history
+type Gshare struct {
+	Bimodal
+	history     uint64
+	historyBits uint32
+}
+
+func NewGshare(entries int, historyBits uint32) *Gshare {
+	return &Gshare{Bimodal: *NewBimodal(entries), historyBits: historyBits}
+}
+
+func (g *Gshare) index(pc uint64, history uint64) uint64 {
+	return (pc>>2 ^ history) % uint64(len(g.counters))
+}
+
+func (g *Gshare) Name() string { return "gshare" }
+
+func (g *Gshare) Predict(pc uint64, target uint64) bool {
+	return g.counters[g.index(pc, g.history)] >= 2
+}
+
+func (g *Gshare) History() uint64 { return g.history }
+
+func (g *Gshare) Update(pc uint64, taken bool) {
+	g.UpdateWith(pc, g.history, taken)
+}
+
+// trains counter selected by history branch was predicted with
+func (g *Gshare) UpdateWith(pc uint64, history uint64, taken bool) {
+	i := g.index(pc, history)
+	g.counters[i] = counterUpdate(g.counters[i], taken)
+	g.history <<= 1
+	if taken {
+		g.history |= 1
+	}
+	g.history &= 1<<g.historyBits - 1
+}
+
+type btbEntry struct {
+	valid  bool
+	pc     uint64
+	target uint64
+	kind   BranchKind
+}
+
+// direct mapped branch target buffer
+type Btb struct {
+	entries []btbEntry
+}
+
+func NewBtb(entries int) *Btb {
+	return &Btb{entries: make([]btbEntry, entries)}
+}
+
+func (btb *Btb) slot(pc uint64) *btbEntry {
+	if len(btb.entries) == 0 {
+		return &btbEntry{}
+	}
+	return &btb.entries[pc>>2%uint64(len(btb.entries))]
+}
+
+func (btb *Btb) lookup(pc uint64) *btbEntry {
+	if e := btb.slot(pc); e.valid && e.pc == pc {
+		return e
+	}
+	return nil
+}
+
+func (btb *Btb) update(pc uint64, target uint64, kind BranchKind) {
+	*btb.slot(pc) = btbEntry{valid: true, pc: pc, target: target, kind: kind}
+}
+
+func (btb *Btb) invalidate(pc uint64) {
+	if e := btb.lookup(pc); e != nil {
+		e.valid = false
+	}
+}
+
+// return address stack,oldest entry is lost on overflow
+type Ras struct {
+	Depth int
+	stack []uint64
+}
+
+func NewRas(depth int) *Ras {
+	return &Ras{Depth: depth}
+}
+
+func push(stack []uint64, depth int, addr uint64) []uint64 {
+	if depth == 0 {
+		return stack
+	}
+	if len(stack) == depth {
+		stack = stack[1:]
+	}
+	return append(stack, addr)
+}
+
+func pop(stack []uint64) []uint64 {
+	if len(stack) == 0 {
+		return stack
+	}
+	return stack[:len(stack)-1]
+}
+
+type BranchStats struct {
+	Branches        uint64 //conditional branches executed
+	Mispredicts     uint64 //conditional branches with wrong direction or target
+	Jumps           uint64 //jal,jalr
+	JumpMispredicts uint64
+	Squashed        uint64 //wrong path instructions removed from fetch and decode
+}
+
+// fraction of correctly predicted control transfers
+func (s BranchStats) Accuracy() float64 {
+	total := s.Branches + s.Jumps
+	if total == 0 {
+		return 1
+	}
+	return 1 - float64(s.Mispredicts+s.JumpMispredicts)/float64(total)
+}
+
+// fetch stage predictor,btb gives target and kind of instruction
+// at fetch pc,direction predictor decides conditional branches.
+// ras holds return addresses of executed calls
+type BranchPredictor struct {
+	Direction DirectionPredictor
+	Btb       *Btb
+	Ras       *Ras
+	Stats     BranchStats
+}
+
+func NewBranchPredictor(direction DirectionPredictor, btbEntries int, rasDepth int) *BranchPredictor {
+	return &BranchPredictor{
+		Direction: direction,
+		Btb:       NewBtb(btbEntries),
+		Ras:       NewRas(rasDepth),
+	}
+}
+
+func (cpu *Cpu) predictor() *BranchPredictor {
+	if cpu.Predictor == nil {
+		cpu.Predictor = NewBranchPredictor(NewBimodal(DEFAULT_BIMODAL_ENTRIES), DEFAULT_BTB_ENTRIES, DEFAULT_RAS_DEPTH)
+	}
+	return cpu.Predictor
+}
+
+// sets next fetch pc of fetched instruction,
+// inflight are older instructions not executed yet
+func (bp *BranchPredictor) predict(inst *Instruction, inflight ...*Instruction) {
+
+	inst.predicted = inst.pc + 4
+	if h, ok := bp.Direction.(historyPredictor); ok {
+		inst.history = h.History()
+	}
+	e := bp.Btb.lookup(inst.pc)
+	if e == nil {
+		//conditional branch not in btb,target predecoded from word
+		if inst.romline&0x7F == BRANCH_OP {
+			inst.kind = BRANCH_COND
+			if target := inst.pc + branchOffset(inst.romline); bp.Direction.Predict(inst.pc, target) {
+				inst.predicted = target
+			}
+		}
+		return
+	}
+	inst.kind = e.kind
+	switch e.kind {
+	case BRANCH_COND:
+		if bp.Direction.Predict(inst.pc, e.target) {
+			inst.predicted = e.target
+		}
+	case BRANCH_RETURN:
+		inst.predicted = e.target
+		//calls and returns in flight are replayed on copy of stack
+		stack := append([]uint64{}, bp.Ras.stack...)
+		for _, older := range inflight {
+			if older == nil {
+				continue
+			}
+			switch older.kind {
+			case BRANCH_CALL:
+				stack = push(stack, bp.Ras.Depth, older.pc+4)
+			case BRANCH_RETURN:
+				stack = pop(stack)
+			}
+		}
+		if len(stack) != 0 {
+			inst.predicted = stack[len(stack)-1]
+		}
+	default:
+		inst.predicted = e.target
+	}
+}
+
+// trains predictor with instruction leaving execute,
+// returns true if instructions after it are on wrong path
+func (bp *BranchPredictor) resolve(inst *Instruction) bool {
+
+	kind := branchKind(inst)
+	next := inst.nextPc()
+	mispredict := inst.predicted != next
+	switch kind {
+	case BRANCH_NONE:
+		//stale btb entry of overwritten code
+		if inst.kind != BRANCH_NONE {
+			bp.Btb.invalidate(inst.pc)
+		}
+		return mispredict
+	case BRANCH_COND:
+		bp.Stats.Branches++
+		if mispredict {
+			bp.Stats.Mispredicts++
+		}
+		if h, ok := bp.Direction.(historyPredictor); ok {
+			h.UpdateWith(inst.pc, inst.history, inst.taken)
+		} else {
+			bp.Direction.Update(inst.pc, inst.taken)
+		}
+	default:
+		bp.Stats.Jumps++
+		if mispredict {
+			bp.Stats.JumpMispredicts++
+		}
+	}
+
+	switch kind {
+	case BRANCH_CALL:
+		bp.Ras.stack = push(bp.Ras.stack, bp.Ras.Depth, inst.pc+4)
+	case BRANCH_RETURN:
+		bp.Ras.stack = pop(bp.Ras.stack)
+	}
+	if inst.taken {
+		bp.Btb.update(inst.pc, inst.target, kind)
+	}
+	return mispredict
+}
+
+// sign extended b-type immediate
+func branchOffset(romline uint32) uint64 {
+	imm := SubBits(romline, 31, 31)<<12 | SubBits(romline, 7, 7)<<11 |
+		SubBits(romline, 25, 30)<<5 | SubBits(romline, 8, 11)<<1
+	return SignExtend64(uint64(imm), 13)
+}
+
+// true if executed inst leaves path fetched after it
+func (inst *Instruction) divert() bool {
+	return inst != nil && (inst.trap != nil || inst.redirect != nil || inst.predicted != inst.nextPc())
+}
+
+// pc of instruction executed after inst
+func (inst *Instruction) nextPc() uint64 {
+	if inst.taken {
+		return inst.target
+	}
+	return inst.pc + 4
+}
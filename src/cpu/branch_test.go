@@ -0,0 +1,130 @@
+package cpu
+
+import (
+	"testing"
+)
+
+func TestDirectionPredictors(t *testing.T) {
+
+	bimodal := NewBimodal(16)
+	if bimodal.Predict(0x40, 0) {
+		t.Errorf("\"TestDirectionPredictors()\" FAILED, bimodal reset state predicts taken")
+	}
+	bimodal.Update(0x40, true)
+	if !bimodal.Predict(0x40, 0) || bimodal.Predict(0x44, 0) {
+		t.Errorf("\"TestDirectionPredictors()\" FAILED, bimodal did not learn taken branch")
+	}
+	//saturated counter tolerates one not taken
+	bimodal.Update(0x40, true)
+	bimodal.Update(0x40, false)
+	if !bimodal.Predict(0x40, 0) {
+		t.Errorf("\"TestDirectionPredictors()\" FAILED, bimodal hysteresis")
+	}
+
+	if !(Btfn{}).Predict(0x40, 0x20) || (Btfn{}).Predict(0x40, 0x60) {
+		t.Errorf("\"TestDirectionPredictors()\" FAILED, btfn")
+	}
+
+	//alternating branch is learned through history
+	gshare := NewGshare(64, 4)
+	correct := 0
+	for i := 0; i < 100; i++ {
+		taken := i%2 == 0
+		if gshare.Predict(0x40, 0) == taken && i >= 50 {
+			correct++
+		}
+		gshare.Update(0x40, taken)
+	}
+	if correct != 50 {
+		t.Errorf("\"TestDirectionPredictors()\" FAILED, gshare correct -> %d of 50", correct)
+	}
+
+	//younger branch is fetched before older one resolves,
+	//it trains counter of history it was predicted with
+	bp := NewBranchPredictor(NewGshare(64, 4), 16, 0)
+	for i := 0; i < 20; i++ {
+		older := &Instruction{instype: B, pc: 0x40}
+		younger := &Instruction{instype: B, pc: 0x44, taken: true, target: 0x40}
+		bp.predict(older)
+		bp.predict(younger, older)
+		bp.resolve(older)
+		bp.resolve(younger)
+	}
+	if bp.Stats.Branches != 40 || bp.Stats.Mispredicts > 3 {
+		t.Errorf("\"TestDirectionPredictors()\" FAILED, gshare in flight mispredicts -> %d of %d",
+			bp.Stats.Mispredicts, bp.Stats.Branches)
+	}
+
+	//branch missing in btb is predicted with target predecoded from word
+	bp = NewBranchPredictor(Btfn{}, 16, 0)
+	backward := &Instruction{pc: 0x40, romline: 0xfe0516e3} //bne a0, x0, -0x14
+	forward := &Instruction{pc: 0x40, romline: 0x00028463}  //beq t0, x0, 8
+	bp.predict(backward)
+	bp.predict(forward)
+	if backward.predicted != 0x2c || forward.predicted != 0x44 {
+		t.Errorf("\"TestDirectionPredictors()\" FAILED, first seen btfn -> %#x %#x", backward.predicted, forward.predicted)
+	}
+
+}
+
+func TestBranchPrediction(t *testing.T) {
+
+	//loop calling function,inner branch alternates
+	var program = []uint32{
+		0x02800513, //addi a0, x0, 40
+		0x00000593, //addi a1, x0, 0
+		0x00000693, //addi a3, x0, 0
+		0x024000ef, //jal ra, 0x30
+		0x00157293, //andi t0, a0, 1
+		0x00028463, //beq t0, x0, 0x1c
+		0x00168693, //addi a3, a3, 1
+		0xfff50513, //addi a0, a0, -1
+		0xfe0516e3, //bne a0, x0, 0x0c
+		0x00100613, //addi a2, x0, 1
+		0x00000000,
+		0x00000000,
+		0x00358593, //addi a1, a1, 3
+		0x00008067, //jalr x0, 0(ra)
+	}
+
+	run := func(direction DirectionPredictor) *Cpu {
+		cpu := Cpu{Predictor: NewBranchPredictor(direction, DEFAULT_BTB_ENTRIES, DEFAULT_RAS_DEPTH)}
+		for i, v := range program {
+			cpu.Ram.SetLine(uint32(i*4), v)
+		}
+		for i := 0; i < 5000 && cpu.regFile.GetRegVal(12) == 0; i++ {
+			cpu.ClockCycle()
+		}
+		//drain pipeline
+		for i := 0; i < 5; i++ {
+			cpu.ClockCycle()
+		}
+		if cpu.regFile.GetRegVal(11) != 120 || cpu.regFile.GetRegVal(13) != 20 {
+			t.Errorf("\"TestBranchPrediction()\" %s FAILED, a1 -> %d a3 -> %d", direction.Name(),
+				cpu.regFile.GetRegVal(11), cpu.regFile.GetRegVal(13))
+		}
+		return &cpu
+	}
+
+	notTaken := run(StaticNotTaken{})
+	btfn := run(Btfn{})
+	bimodal := run(NewBimodal(DEFAULT_BIMODAL_ENTRIES))
+	gshare := run(NewGshare(DEFAULT_BIMODAL_ENTRIES, 8))
+
+	for _, cpu := range []*Cpu{notTaken, btfn, bimodal, gshare} {
+		s := cpu.Predictor.Stats
+		t.Logf("%-9s accuracy %.3f cpi %.3f mispredicts %d/%d jumps %d/%d squashed %d",
+			cpu.Predictor.Direction.Name(), s.Accuracy(), cpu.Stats.CPI(), s.Mispredicts, s.Branches,
+			s.JumpMispredicts, s.Jumps, s.Squashed)
+		//calls and returns are predicted by btb and ras once seen
+		if s.Branches != 80 || s.Jumps != 80 || s.JumpMispredicts > 2 || s.Squashed > 2*(s.Mispredicts+s.JumpMispredicts) {
+			t.Errorf("\"TestBranchPrediction()\" %s FAILED, %+v", cpu.Predictor.Direction.Name(), s)
+		}
+	}
+	if !(btfn.Predictor.Stats.Mispredicts < notTaken.Predictor.Stats.Mispredicts &&
+		gshare.Predictor.Stats.Mispredicts < bimodal.Predictor.Stats.Mispredicts &&
+		gshare.Stats.CPI() < notTaken.Stats.CPI()) {
+		t.Errorf("\"TestBranchPrediction()\" FAILED, predictors not ordered by accuracy")
+	}
+
+}
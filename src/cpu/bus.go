@@ -0,0 +1,102 @@
+package cpu
+
+// physical address space of hart,implemented by machine profiles
+type Bus interface {
+	//whole access falls in one region that supports it
+	Mapped(address uint64, size uint32, access AccessType) bool
+	Read(address uint64, size uint32) uint64
+	Write(address uint64, size uint32, val uint64)
+	//wait states of region holding address
+	WaitStates(address uint64) WaitStates
+}
+
+// cycles added to memory access of region
+type WaitStates struct {
+	Read  uint32
+	Write uint32
+}
+
+// accesses that reach memory without cache
+type BusStats struct {
+	Reads       uint64
+	Writes      uint64
+	WaitCycles  uint64 //wait states of accesses
+	FetchStalls uint64 //fetch cycles lost to data access on single port
+	DataStalls  uint64 //memory stage cycles lost to fetch on single port
+}
+
+func (cpu *Cpu) mapped(pa uint64, size uint32, access AccessType) bool {
+	if cpu.Bus != nil {
+		return cpu.Bus.Mapped(pa, size, access)
+	}
+	return pa+uint64(size) <= cpu.Ram.Size()
+}
+
+// regions instructions may be fetched from are memory,
+// others are device registers with access side effects
+func (cpu *Cpu) cacheable(pa uint64, size uint32) bool {
+	return cpu.mapped(pa, size, ACCESS_FETCH)
+}
+
+func (cpu *Cpu) readPhys(pa uint64, size uint32) uint64 {
+	if cpu.Bus != nil {
+		return cpu.Bus.Read(pa, size)
+	}
+	return cpu.Ram.Read(uint32(pa), size)
+}
+
+// inst is storing instruction,nil for page table walker
+func (cpu *Cpu) writePhys(inst *Instruction, pa uint64, size uint32, val uint64) {
+	if cpu.Travel != nil {
+		cpu.Travel.write(cpu, inst, pa, size, val)
+	}
+	if cpu.Bus != nil {
+		cpu.Bus.Write(pa, size, val)
+		return
+	}
+	cpu.Ram.Write(uint32(pa), size, val)
+}
+
+func (cpu *Cpu) waitStates(pa uint64) WaitStates {
+	if cpu.Bus != nil {
+		return cpu.Bus.WaitStates(pa)
+	}
+	return cpu.RamWait
+}
+
+// single ported memory is still serving access started earlier
+// or data access of this cycle
+func (cpu *Cpu) portBusy() bool {
+	return cpu.SinglePort && cpu.portFree > cpu.Stats.Cycles
+}
+
+// uncached access occupies memory for its wait states,
+// returns stall cycles including wait for single port
+func (cpu *Cpu) claimPort(pa uint64, write bool) uint32 {
+
+	s := &cpu.Stats.Bus
+	wait := cpu.waitStates(pa).Read
+	if write {
+		wait = cpu.waitStates(pa).Write
+		s.Writes++
+	} else {
+		s.Reads++
+	}
+	s.WaitCycles += uint64(wait)
+	if !cpu.SinglePort {
+		return wait
+	}
+	start := max(cpu.Stats.Cycles, cpu.portFree)
+	s.DataStalls += start - cpu.Stats.Cycles
+	cpu.portFree = start + uint64(wait) + 1
+	return uint32(start-cpu.Stats.Cycles) + wait
+}
+
+// stall cycles of data access in memory stage,
+// device registers bypass cache
+func (cpu *Cpu) dataAccess(pa uint64, size uint32, write bool) uint32 {
+	if cpu.DCache == nil || !cpu.cacheable(pa, size) {
+		return cpu.claimPort(pa, write)
+	}
+	return cpu.DCache.Access(pa, write)
+}
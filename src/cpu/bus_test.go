@@ -0,0 +1,69 @@
+package cpu
+
+import (
+	"testing"
+)
+
+func TestWaitStates(t *testing.T) {
+
+	//sum of array,one load and one store reach memory per iteration
+	var program = []uint32{
+		0x10000513, //addi a0, x0, 0x100
+		0x00800313, //addi t1, x0, 8
+		0x00000593, //addi a1, x0, 0
+		0x00052283, //lw t0, 0(a0)
+		0x005585b3, //add a1, a1, t0
+		0x00450513, //addi a0, a0, 4
+		0xfff30313, //addi t1, t1, -1
+		0xfe0318e3, //bne t1, x0, 0x0c
+		0x00b52023, //sw a1, 0(a0)
+		0x00100613, //addi a2, x0, 1
+	}
+
+	run := func(core Core) *Cpu {
+		cpu := core.Arch()
+		for i, v := range program {
+			cpu.Ram.SetLine(uint32(i*4), v)
+		}
+		for i := uint32(0); i < 8; i++ {
+			cpu.Ram.SetLine(0x100+i*4, i+1)
+		}
+		for i := 0; i < 2000 && cpu.regFile.GetRegVal(12) == 0; i++ {
+			core.ClockCycle()
+		}
+		if cpu.regFile.GetRegVal(11) != 36 || cpu.Ram.GetLine(0x120) != 36 {
+			t.Errorf("\"TestWaitStates()\" FAILED, a1 -> %d", cpu.regFile.GetRegVal(11))
+		}
+		return cpu
+	}
+
+	plain := run(&Cpu{})
+	slow := run(&Cpu{RamWait: WaitStates{Read: 2, Write: 3}})
+	shared := run(&Cpu{SinglePort: true})
+	both := run(&Cpu{RamWait: WaitStates{Read: 2, Write: 3}, SinglePort: true})
+	//every fetch and data access pays its region wait states
+	s := slow.Stats.Bus
+	if s.Writes != 1 || s.WaitCycles != 2*s.Reads+3*s.Writes || slow.Stats.Cycles <= plain.Stats.Cycles {
+		t.Errorf("\"TestWaitStates()\" FAILED, cycles %d vs %d %+v", slow.Stats.Cycles, plain.Stats.Cycles, s)
+	}
+	//load use bubble hides most conflicts of single cycle memory,
+	//wait states make fetch and memory stage block each other
+	if shared.Stats.Bus.FetchStalls == 0 || both.Stats.Bus.DataStalls == 0 || both.Stats.Cycles <= slow.Stats.Cycles {
+		t.Errorf("\"TestWaitStates()\" single port FAILED, cycles %d vs %d %+v",
+			both.Stats.Cycles, slow.Stats.Cycles, both.Stats.Bus)
+	}
+
+	ooo := run(NewOutOfOrder(&Cpu{RamWait: WaitStates{Read: 2, Write: 3}, SinglePort: true}, DEFAULT_OOO))
+	if ooo.Stats.Bus.FetchStalls == 0 || ooo.Stats.Bus.WaitCycles == 0 {
+		t.Errorf("\"TestWaitStates()\" out-of-order FAILED, %+v", ooo.Stats.Bus)
+	}
+
+	//cached accesses don't reach memory
+	cached := &Cpu{RamWait: WaitStates{Read: 2, Write: 3}, SinglePort: true}
+	cached.SetCaches(DEFAULT_L1I, DEFAULT_L1D, nil)
+	run(cached)
+	if cached.Stats.Bus.Reads != 0 || cached.Stats.Bus.Writes != 0 {
+		t.Errorf("\"TestWaitStates()\" cached FAILED, %+v", cached.Stats.Bus)
+	}
+
+}
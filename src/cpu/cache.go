@@ -0,0 +1,197 @@
+package cpu
+
+import (
+	"fmt"
+)
+
+type ReplacementPolicy uint8
+
+const (
+	REPLACE_LRU ReplacementPolicy = iota
+	REPLACE_FIFO
+	REPLACE_RANDOM
+)
+
+// cache geometry and timing,latencies are stall cycles
+// added to access
+type CacheConfig struct {
+	Size        uint32 //bytes
+	Ways        uint32
+	LineSize    uint32 //bytes,power of two
+	Policy      ReplacementPolicy
+	WriteBack   bool   //false means write-through without write allocate
+	HitLatency  uint32 //zero keeps single cycle access
+	MissLatency uint32 //memory access of fill or write,used when there is no next level
+}
+
+var (
+	DEFAULT_L1I = CacheConfig{Size: 4096, Ways: 2, LineSize: 32, Policy: REPLACE_LRU, MissLatency: 20}
+	DEFAULT_L1D = CacheConfig{Size: 4096, Ways: 2, LineSize: 32, Policy: REPLACE_LRU, WriteBack: true, MissLatency: 20}
+	DEFAULT_L2  = CacheConfig{Size: 65536, Ways: 8, LineSize: 64, Policy: REPLACE_LRU, WriteBack: true, HitLatency: 6, MissLatency: 20}
+)
+
+type CacheStats struct {
+	Reads      uint64
+	Writes     uint64
+	Hits       uint64
+	Misses     uint64
+	Evictions  uint64 //valid lines replaced
+	Writebacks uint64 //dirty lines written to next level
+}
+
+func (s CacheStats) MissRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Misses) / float64(s.Hits+s.Misses)
+}
+
+type cacheLine struct {
+	valid  bool
+	dirty  bool
+	tag    uint64
+	used   uint64 //access time,lru victim is oldest
+	filled uint64 //fill time,fifo victim is oldest
+}
+
+// timing model of set associative cache,only tags are kept,
+// data always comes from ram or bus
+type Cache struct {
+	Config CacheConfig
+	Next   *Cache //next level,nil means memory
+	Stats  CacheStats
+	sets   [][]cacheLine
+	time   uint64
+	seed   uint32 //random replacement state,same run picks same victims
+}
+
+func NewCache(config CacheConfig, next *Cache) (*Cache, error) {
+
+	if config.LineSize == 0 || config.LineSize&(config.LineSize-1) != 0 {
+		return nil, fmt.Errorf("cache line size %d is not power of two", config.LineSize)
+	}
+	if config.Ways == 0 || config.Size == 0 || config.Size%(config.Ways*config.LineSize) != 0 {
+		return nil, fmt.Errorf("cache size %d is not multiple of %d ways of %d byte lines",
+			config.Size, config.Ways, config.LineSize)
+	}
+	c := &Cache{Config: config, Next: next, seed: 1}
+	c.sets = make([][]cacheLine, config.Size/(config.Ways*config.LineSize))
+	for i := range c.sets {
+		c.sets[i] = make([]cacheLine, config.Ways)
+	}
+	return c, nil
+}
+
+// stall cycles of read or write,miss fills line from next level
+// and dirty victim is written back to it
+func (c *Cache) Access(address uint64, write bool) uint32 {
+
+	c.time++
+	if write {
+		c.Stats.Writes++
+	} else {
+		c.Stats.Reads++
+	}
+	line := address / uint64(c.Config.LineSize)
+	set := c.sets[line%uint64(len(c.sets))]
+	tag := line / uint64(len(c.sets))
+	latency := c.Config.HitLatency
+
+	for i := range set {
+		if set[i].valid && set[i].tag == tag {
+			c.Stats.Hits++
+			set[i].used = c.time
+			if write && c.Config.WriteBack {
+				set[i].dirty = true
+			} else if write {
+				latency += c.below(address, true)
+			}
+			return latency
+		}
+	}
+
+	c.Stats.Misses++
+	if write && !c.Config.WriteBack {
+		return latency + c.below(address, true)
+	}
+	victim := &set[c.victim(set)]
+	if victim.valid {
+		c.Stats.Evictions++
+		if victim.dirty {
+			c.Stats.Writebacks++
+			evicted := (victim.tag*uint64(len(c.sets)) + line%uint64(len(c.sets))) * uint64(c.Config.LineSize)
+			latency += c.below(evicted, true)
+		}
+	}
+	latency += c.below(address, false)
+	*victim = cacheLine{valid: true, dirty: write, tag: tag, used: c.time, filled: c.time}
+	return latency
+}
+
+// drops every line,instruction cache never holds dirty ones
+func (c *Cache) Invalidate() {
+	for _, set := range c.sets {
+		clear(set)
+	}
+}
+
+// true if both addresses fall in same line
+func (c *Cache) SameLine(a uint64, b uint64) bool {
+	return a/uint64(c.Config.LineSize) == b/uint64(c.Config.LineSize)
+}
+
+func (c *Cache) below(address uint64, write bool) uint32 {
+	if c.Next != nil {
+		return c.Next.Access(address, write)
+	}
+	return c.Config.MissLatency
+}
+
+// invalid way first,then by policy
+func (c *Cache) victim(set []cacheLine) int {
+
+	for i := range set {
+		if !set[i].valid {
+			return i
+		}
+	}
+	v := 0
+	switch c.Config.Policy {
+	case REPLACE_LRU:
+		for i := range set {
+			if set[i].used < set[v].used {
+				v = i
+			}
+		}
+	case REPLACE_FIFO:
+		for i := range set {
+			if set[i].filled < set[v].filled {
+				v = i
+			}
+		}
+	case REPLACE_RANDOM:
+		//xorshift32
+		c.seed ^= c.seed << 13
+		c.seed ^= c.seed >> 17
+		c.seed ^= c.seed << 5
+		v = int(c.seed % uint32(len(set)))
+	}
+	return v
+}
+
+// l1 instruction and data caches,l2 is unified behind both when given
+func (cpu *Cpu) SetCaches(l1i CacheConfig, l1d CacheConfig, l2 *CacheConfig) error {
+
+	var next *Cache
+	var err error
+	if l2 != nil {
+		if next, err = NewCache(*l2, nil); err != nil {
+			return err
+		}
+	}
+	if cpu.ICache, err = NewCache(l1i, next); err != nil {
+		return err
+	}
+	cpu.DCache, err = NewCache(l1d, next)
+	return err
+}
@@ -0,0 +1,127 @@
+package cpu
+
+import (
+	"testing"
+)
+
+func TestCacheReplacement(t *testing.T) {
+
+	//two sets of two 16 byte lines,0x00,0x40 and 0x80 share set 0
+	config := CacheConfig{Size: 64, Ways: 2, LineSize: 16, MissLatency: 10, WriteBack: true}
+	lru, _ := NewCache(config, nil)
+	config.Policy = REPLACE_FIFO
+	fifo, _ := NewCache(config, nil)
+
+	for _, c := range []*Cache{lru, fifo} {
+		c.Access(0x00, false)
+		c.Access(0x40, false)
+		c.Access(0x04, false)
+		c.Access(0x80, false)
+	}
+	//lru evicted 0x40,fifo evicted 0x00 although it was used last
+	if lru.Access(0x08, false) != 0 || fifo.Access(0x08, false) != 10 {
+		t.Errorf("\"TestCacheReplacement()\" FAILED, lru %+v fifo %+v", lru.Stats, fifo.Stats)
+	}
+	if lru.Stats.Hits != 2 || lru.Stats.Misses != 3 || lru.Stats.Evictions != 1 {
+		t.Errorf("\"TestCacheReplacement()\" FAILED, lru %+v", lru.Stats)
+	}
+
+	config.Policy = REPLACE_RANDOM
+	random, _ := NewCache(config, nil)
+	for i := uint64(0); i < 100; i++ {
+		random.Access(i*0x40, false)
+	}
+	if random.Stats.Misses != 100 || random.Stats.Evictions != 98 {
+		t.Errorf("\"TestCacheReplacement()\" FAILED, random %+v", random.Stats)
+	}
+
+	if _, err := NewCache(CacheConfig{Size: 64, Ways: 3, LineSize: 16}, nil); err == nil {
+		t.Errorf("\"TestCacheReplacement()\" FAILED, 3 ways of 16 bytes accepted for 64 byte cache")
+	}
+	if _, err := NewCache(CacheConfig{Size: 96, Ways: 2, LineSize: 24}, nil); err == nil {
+		t.Errorf("\"TestCacheReplacement()\" FAILED, 24 byte line accepted")
+	}
+
+}
+
+func TestCacheWritePolicy(t *testing.T) {
+
+	l2, _ := NewCache(CacheConfig{Size: 256, Ways: 4, LineSize: 16, HitLatency: 3, MissLatency: 10, WriteBack: true}, nil)
+	back, _ := NewCache(CacheConfig{Size: 32, Ways: 1, LineSize: 16, WriteBack: true}, l2)
+	through, _ := NewCache(CacheConfig{Size: 32, Ways: 1, LineSize: 16, MissLatency: 10}, nil)
+
+	//write miss allocates,dirty victim goes to l2 which already holds it
+	if back.Access(0x00, true) != 13 || back.Access(0x04, true) != 0 || back.Access(0x20, false) != 3+13 {
+		t.Errorf("\"TestCacheWritePolicy()\" write-back FAILED, %+v l2 %+v", back.Stats, l2.Stats)
+	}
+	if back.Stats.Writebacks != 1 || l2.Stats.Writes != 1 || l2.Stats.Hits != 1 {
+		t.Errorf("\"TestCacheWritePolicy()\" write-back FAILED, %+v l2 %+v", back.Stats, l2.Stats)
+	}
+
+	//every write goes to memory,miss does not allocate
+	if through.Access(0x00, true) != 10 || through.Access(0x00, false) != 10 || through.Access(0x00, true) != 10 {
+		t.Errorf("\"TestCacheWritePolicy()\" write-through FAILED, %+v", through.Stats)
+	}
+	if through.Stats.Hits != 1 || through.Stats.Misses != 2 || through.Stats.Writebacks != 0 {
+		t.Errorf("\"TestCacheWritePolicy()\" write-through FAILED, %+v", through.Stats)
+	}
+
+}
+
+func TestCachedPipeline(t *testing.T) {
+
+	//sum of array,load use in loop body
+	var program = []uint32{
+		0x10000513, //addi a0, x0, 0x100
+		0x00800313, //addi t1, x0, 8
+		0x00000593, //addi a1, x0, 0
+		0x00052283, //lw t0, 0(a0)
+		0x005585b3, //add a1, a1, t0
+		0x00450513, //addi a0, a0, 4
+		0xfff30313, //addi t1, t1, -1
+		0xfe0318e3, //bne t1, x0, 0x0c
+		0x00b52023, //sw a1, 0(a0)
+		0x00100613, //addi a2, x0, 1
+	}
+
+	run := func(core Core) *Cpu {
+		cpu := core.Arch()
+		for i, v := range program {
+			cpu.Ram.SetLine(uint32(i*4), v)
+		}
+		for i := uint32(0); i < 8; i++ {
+			cpu.Ram.SetLine(0x100+i*4, i+1)
+		}
+		for i := 0; i < 2000 && cpu.regFile.GetRegVal(12) == 0; i++ {
+			core.ClockCycle()
+		}
+		if cpu.regFile.GetRegVal(11) != 36 || cpu.Ram.GetLine(0x120) != 36 {
+			t.Errorf("\"TestCachedPipeline()\" FAILED, a1 -> %d", cpu.regFile.GetRegVal(11))
+		}
+		return cpu
+	}
+
+	plain := run(&Cpu{})
+	cached := &Cpu{}
+	l2 := DEFAULT_L2
+	if err := cached.SetCaches(DEFAULT_L1I, DEFAULT_L1D, &l2); err != nil {
+		t.Fatal(err)
+	}
+	run(cached)
+	i, d := cached.ICache.Stats, cached.DCache.Stats
+	t.Logf("cycles %d vs %d icache %+v dcache %+v l2 %+v", cached.Stats.Cycles, plain.Stats.Cycles, i, d, cached.DCache.Next.Stats)
+	//two instruction lines and two data lines are cold,
+	//l2 misses once per 64 byte line
+	if i.Misses != 2 || d.Misses != 2 || d.Hits != 7 || cached.DCache.Next.Stats.Misses != 2 ||
+		cached.Stats.Cycles <= plain.Stats.Cycles {
+		t.Errorf("\"TestCachedPipeline()\" FAILED, cycles %d icache %+v dcache %+v", cached.Stats.Cycles, i, d)
+	}
+
+	ooo := NewOutOfOrder(&Cpu{}, DEFAULT_OOO)
+	ooo.Arch().SetCaches(DEFAULT_L1I, DEFAULT_L1D, &l2)
+	run(ooo)
+	if ooo.Arch().DCache.Stats.Misses != 2 || ooo.Arch().DCache.Stats.Writes != 1 {
+		t.Errorf("\"TestCachedPipeline()\" out-of-order FAILED, dcache %+v", ooo.Arch().DCache.Stats)
+	}
+
+}
@@ -0,0 +1,81 @@
+package cpu
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+var csrNames = map[uint32]string{
+	CSR_SSTATUS: "sstatus", CSR_SIE: "sie", CSR_STVEC: "stvec", CSR_SCOUNTEN: "scounteren",
+	CSR_SENVCFG: "senvcfg", CSR_SSCRATCH: "sscratch", CSR_SEPC: "sepc", CSR_SCAUSE: "scause",
+	CSR_STVAL: "stval", CSR_SIP: "sip", CSR_SATP: "satp", CSR_MSTATUS: "mstatus", CSR_MISA: "misa",
+	CSR_MEDELEG: "medeleg", CSR_MIDELEG: "mideleg", CSR_MIE: "mie", CSR_MTVEC: "mtvec",
+	CSR_MCOUNTEN: "mcounteren", CSR_MENVCFG: "menvcfg", CSR_MSCRATCH: "mscratch", CSR_MEPC: "mepc",
+	CSR_MCAUSE: "mcause", CSR_MTVAL: "mtval", CSR_MIP: "mip", CSR_MSECCFG: "mseccfg",
+	CSR_MVENDOR: "mvendorid", CSR_MARCHID: "marchid", CSR_MIMPID: "mimpid", CSR_MHARTID: "mhartid",
+	CSR_SEED: "seed", CSR_VSTART: "vstart", CSR_TIME: "time", CSR_VL: "vl", CSR_VTYPE: "vtype",
+	CSR_VLENB: "vlenb",
+}
+
+func csrName(address uint32) string {
+	switch {
+	case csrNames[address] != "":
+		return csrNames[address]
+	case address >= CSR_PMPCFG0 && address < CSR_PMPADDR0:
+		return fmt.Sprintf("pmpcfg%d", address-CSR_PMPCFG0)
+	case address >= CSR_PMPADDR0 && address < CSR_PMPADDR0+64:
+		return fmt.Sprintf("pmpaddr%d", address-CSR_PMPADDR0)
+	}
+	return fmt.Sprintf("csr%#x", address)
+}
+
+// log of retired instructions in spike --log-commits format,
+// privilege,pc,instruction word,register and csr writes,
+// then memory reads and writes at virtual address.
+// trapped instructions don't retire and are not logged
+type CommitLog struct {
+	w    io.Writer
+	err  error
+	Hart int
+}
+
+func NewCommitLog(w io.Writer) *CommitLog {
+	return &CommitLog{w: w}
+}
+
+// first write error,log is cut there
+func (c *CommitLog) Err() error {
+	return c.err
+}
+
+func (c *CommitLog) record(cpu *Cpu, inst *Instruction) {
+
+	if c.err != nil {
+		return
+	}
+	digits := int(cpu.xlen() / 4)
+	line := strings.Builder{}
+	fmt.Fprintf(&line, "core %3d: %d 0x%0*x (0x%08x)", c.Hart, inst.priv, digits, inst.pc, inst.romline)
+
+	if inst.wbop != nil && inst.wbop.dest != 0 {
+		fmt.Fprintf(&line, " x%-2d 0x%0*x", inst.wbop.dest, digits, cpu.trunc(inst.wbop.data))
+	}
+	if w := inst.csrWrite; w != nil {
+		fmt.Fprintf(&line, " c%d_%s 0x%0*x", w.dest, csrName(w.dest), digits, cpu.trunc(w.data))
+	}
+	if m := inst.memop; m != nil && inst.vecop == nil {
+		size := int(1) << (inst.funct3 & 0b11)
+		data := m.data & (^uint64(0) >> (64 - size*8))
+		//sc doesn't read and failed one doesn't write
+		sc := inst.opcode == AMO && m.amo == SC
+		if m.optype != STORE && !sc {
+			fmt.Fprintf(&line, " mem 0x%0*x", digits, inst.vaddr)
+		}
+		if m.optype != LOAD && !(sc && inst.wbop.data != 0) {
+			fmt.Fprintf(&line, " mem 0x%0*x 0x%0*x", digits, inst.vaddr, size*2, data)
+		}
+	}
+	line.WriteByte('\n')
+	_, c.err = io.WriteString(c.w, line.String())
+}
@@ -0,0 +1,56 @@
+package cpu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCommitLog(t *testing.T) {
+
+	program := map[uint32]uint32{
+		0x00: 0x10000513, //addi a0, x0, 0x100
+		0x04: 0x123452b7, //lui t0, 0x12345
+		0x08: 0x00552023, //sw t0, 0(a0)
+		0x0c: 0x00154383, //lbu t2, 1(a0)
+		0x10: 0x34029073, //csrrw x0, mscratch, t0
+		0x14: 0x100525af, //lr.w a1, (a0)
+		0x18: 0x18a5262f, //sc.w a2, a0, (a0)
+		0x1c: 0x0000006f, //jal x0, 0x1c
+	}
+	var in, out bytes.Buffer
+	inorder := &Cpu{CommitLog: NewCommitLog(&in)}
+	ooo := NewOutOfOrder(&Cpu{CommitLog: NewCommitLog(&out)}, DEFAULT_OOO)
+	for _, core := range []Core{inorder, ooo} {
+		for address, v := range program {
+			core.Arch().Ram.SetLine(address, v)
+		}
+		for i := 0; i < 40; i++ {
+			core.ClockCycle()
+		}
+	}
+
+	want := []string{
+		"core   0: 3 0x00000000 (0x10000513) x10 0x00000100",
+		"core   0: 3 0x00000004 (0x123452b7) x5  0x12345000",
+		"core   0: 3 0x00000008 (0x00552023) mem 0x00000100 0x12345000",
+		"core   0: 3 0x0000000c (0x00154383) x7  0x00000050 mem 0x00000101",
+		"core   0: 3 0x00000010 (0x34029073) c832_mscratch 0x12345000",
+		"core   0: 3 0x00000014 (0x100525af) x11 0x12345000 mem 0x00000100",
+		"core   0: 3 0x00000018 (0x18a5262f) x12 0x00000000 mem 0x00000100 0x00000100",
+		"core   0: 3 0x0000001c (0x0000006f)",
+	}
+	lines := strings.Split(in.String(), "\n")
+	for i, w := range want {
+		if i >= len(lines) || lines[i] != w {
+			t.Errorf("\"TestCommitLog()\" FAILED, line %d\n%s", i, in.String())
+			break
+		}
+	}
+	//both timing models retire same stream
+	n := min(len(lines), len(strings.Split(out.String(), "\n"))) - 1
+	if !strings.HasPrefix(out.String(), strings.Join(lines[:n], "\n")) || n < len(want) {
+		t.Errorf("\"TestCommitLog()\" out-of-order FAILED\n%s", out.String())
+	}
+
+}
@@ -0,0 +1,48 @@
+package cpu
+
+import (
+	"fmt"
+)
+
+// timing model of hart,models differ in how instructions flow
+// but share decode,execute semantics and architectural state of Cpu
+type Core interface {
+	ClockCycle()
+	Arch() *Cpu
+	Report() PerfReport
+	Snap(s *Snapshot)
+}
+
+// in-order pipeline is the Cpu itself
+func (cpu *Cpu) Arch() *Cpu {
+	return cpu
+}
+
+// compares architectural state of two harts,
+// nil when registers,privilege,trap csrs,ram and retired count match
+func (cpu *Cpu) ArchDiff(other *Cpu) error {
+
+	for i := uint32(0); i < 32; i++ {
+		if a, b := cpu.regFile.GetRegVal(i), other.regFile.GetRegVal(i); a != b {
+			return fmt.Errorf("x%d %#x != %#x", i, a, b)
+		}
+	}
+	if cpu.priv != other.priv {
+		return fmt.Errorf("privilege %d != %d", cpu.priv, other.priv)
+	}
+	if cpu.traps != other.traps {
+		return fmt.Errorf("trap csrs %+v != %+v", cpu.traps, other.traps)
+	}
+	if cpu.Ram.Size() != other.Ram.Size() {
+		return fmt.Errorf("ram size %d != %d", cpu.Ram.Size(), other.Ram.Size())
+	}
+	for a := uint32(0); uint64(a) < cpu.Ram.Size(); a += 4 {
+		if x, y := cpu.Ram.GetLine(a), other.Ram.GetLine(a); x != y {
+			return fmt.Errorf("ram %#x %#x != %#x", a, x, y)
+		}
+	}
+	if cpu.Stats.Retired != other.Stats.Retired {
+		return fmt.Errorf("retired %d != %d", cpu.Stats.Retired, other.Stats.Retired)
+	}
+	return nil
+}
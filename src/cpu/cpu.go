@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"math/bits"
 	"os"
 	"Go_emu/src/ram"
 	"Go_emu/src/register"
@@ -23,11 +22,14 @@ const (
 	B InstructionType = 4
 	U InstructionType = 5
 	J InstructionType = 6
+	V InstructionType = 7
 )
 
 const (
 	STORE MemopsType = 1
 	LOAD  MemopsType = 2
+	//read-modify-write of AMO and SC
+	AMO_RMW MemopsType = 3
 )
 
 const (
@@ -49,18 +51,41 @@ var opcodesMapping = map[uint32]InstructionType{
 	0b0110111: U,
 	0b1101111: J,
 	0b0010111: U,
+	0b1110011: I,
+	0b0011011: I,
+	0b0111011: R,
+	0b0001111: I,
+	0b0101111: R,
+}
+
+const (
+	OPIMM32 = 0b0011011
+	OP32    = 0b0111011
+)
+
+var rv64Opcodes = map[uint32]bool{
+	OPIMM32: true,
+	OP32:    true,
+}
+
+// LD,LWU and SD,funct3 values of LOAD and STORE reserved in rv32
+func rv64MemOp(opcode uint32, funct3 uint32) bool {
+	return opcode == 0b0000011 && (funct3 == 0x3 || funct3 == 0x6) || opcode == 0b0100011 && funct3 == 0x3
 }
 
 type Memops struct {
-	optype    MemopsType
-	data      uint32
-	address   uint32
-	data_mask uint32
-	signed    bool
+	optype        MemopsType
+	data          uint64
+	address       uint64
+	data_mask     uint64
+	latency       uint32 //address translation stall cycles
+	accessLatency uint32 //data cache,wait state and memory port stall cycles of memory stage
+	amo           uint32 //A extension funct5
+	signed        bool
 }
 
 type Wbops struct {
-	data uint32
+	data uint64
 	dest uint32
 }
 
@@ -68,26 +93,97 @@ type Instruction struct {
 	instype   InstructionType
 	romline   uint32
 	funct7    uint32
-	rs2       uint32
-	rs1       uint32
+	rs2       uint64
+	rs1       uint64
 	rd        uint32
 	funct3    uint32
 	opcode    uint32
-	imm       uint32
+	imm       uint64
 	memop     *Memops
 	wbop      *Wbops
 	stage     Stage
 	rs2_index uint32
 	rs1_index uint32
-	pc        uint32
+	pc        uint64
+	vecop     *Vecops
+	trap      *Trap      //exception raised by instruction
+	redirect  *Redirect  //instruction changed pc outside of branch logic
+	priv      Privilege  //privilege level instruction was fetched at
+	predicted uint64     //next fetch pc chosen by branch predictor
+	kind      BranchKind //control transfer kind btb reported at fetch
+	history   uint64     //global history direction predictor had at fetch
+	taken     bool       //control transfer resolved in execute
+	target    uint64
+	forwarded [2]int //slots rs1 and rs2 were forwarded from,0 if read from register file
+	csrWrite  *Wbops //csr address and value it holds after write
+	vaddr     uint64 //data address before translation
+}
+
+type PipelineStats struct {
+	Cycles    uint64
+	Retired   uint64 //instructions completed write back
+	Redirects RedirectStats
+	Issue     IssueStats
+	Bus       BusStats
+	LoadUse   uint64            //bubbles inserted by load use interlock
+	Forwarded map[string]uint64 //operands of retired instructions by slot they were forwarded from
+	Types     map[string]uint64 //retired instructions by format
+	Mnemonics map[string]uint64 //retired instructions by mnemonic
+}
+
+// cycles per retired instruction
+func (s PipelineStats) CPI() float64 {
+	if s.Retired == 0 {
+		return 0
+	}
+	return float64(s.Cycles) / float64(s.Retired)
+}
+
+// retired instructions per cycle
+func (s PipelineStats) IPC() float64 {
+	if s.Cycles == 0 {
+		return 0
+	}
+	return float64(s.Retired) / float64(s.Cycles)
 }
 
 type Cpu struct {
 	regFile     register.RegisterFile //cpu registers
-	instStorage [5]*Instruction
-	stall       bool   //to stall cpu in case of control and some hazards
-	pc          uint32 //program counter
+	instStorage [][]*Instruction      //slot latches holding bundle of issue width,configured by SetPipeline
+	layout      *pipelineLayout
+	interlock   bool         //operand not ready,execute gets bubble next cycle
+	loadUse     bool         //interlock waits for load data
+	leftover    *Instruction //younger half of split bundle waiting in front of execute
+	pc          uint64       //program counter
 	Ram         ram.Ram
+	Xlen        uint32      //32 or 64,zero value means rv32
+	Vector      *VectorUnit //optional rvv unit,nil when extension absent
+	Entropy     *EntropySource
+	csrs        map[uint32]*Csr
+	traps       trapRegs
+	reserved    reservation
+	Bus         Bus //physical address space,nil means Ram mapped at 0
+	pmp         pmpRegs
+	priv        Privilege //current privilege level
+	powered     bool      //reset state applied
+	speculative bool      //executing on possibly wrong path,translation has no side effects
+	ITlb        *Tlb
+	DTlb        *Tlb
+	ICache      *Cache //optional l1 caches,nil means fetch and data access go to memory
+	DCache      *Cache
+	RamWait     WaitStates   //wait states of Ram when there is no Bus
+	SinglePort  bool         //fetch and data access share one memory port
+	portFree    uint64       //first cycle single port is free
+	fetchWait   uint32       //stall cycles left before pending fetch is delivered
+	fetchNext   *Instruction //translated fetch waiting for its stall
+	memWait     uint32       //stall cycles left of memory stage translation and data access
+	Predictor   *BranchPredictor
+	Trace       *KonataTrace //optional pipeline log of in-order pipeline
+	CommitLog   *CommitLog   //optional spike style log of retired instructions
+	Lockstep    *Lockstep    //optional golden model checking retired instructions
+	Travel      *TimeTravel  //optional record of cycles for reverse execution
+	Input       *InputLog    //optional record or replay of host input
+	Stats       PipelineStats
 }
 
 func IsBranchIns(inst *Instruction) bool {
@@ -97,7 +193,7 @@ func IsBranchIns(inst *Instruction) bool {
 	}
 
 	if inst.instype == B || inst.instype == J ||
-		inst.opcode == 1100111 {
+		inst.opcode == JALR {
 
 		return true
 	}
@@ -108,6 +204,10 @@ func SignExtend(data uint32, pos uint8) uint32 {
 	return uint32(int32(data<<(32-pos)) >> (32 - pos))
 }
 
+func SignExtend64(data uint64, pos uint8) uint64 {
+	return uint64(int64(data<<(64-pos)) >> (64 - pos))
+}
+
 // includes [end:start]
 // end 31<---------------0 start
 func SubBits(source uint32, start uint32, end uint32) uint32 {
@@ -116,7 +216,30 @@ func SubBits(source uint32, start uint32, end uint32) uint32 {
 	return source & mask
 }
 
-func (inst *Instruction) extractOperands(romline uint32, regFile register.RegisterFile) {
+func (cpu *Cpu) xlen() uint32 {
+	if cpu.Xlen == 64 {
+		return 64
+	}
+	return 32
+}
+
+// cut value to register width,rv32 values are kept zero extended
+func (cpu *Cpu) trunc(val uint64) uint64 {
+	if cpu.xlen() == 32 {
+		return val & 0xFFFF_FFFF
+	}
+	return val
+}
+
+// register value as signed XLEN integer
+func (cpu *Cpu) signed(val uint64) int64 {
+	if cpu.xlen() == 32 {
+		return int64(int32(val))
+	}
+	return int64(val)
+}
+
+func (inst *Instruction) extractOperands(romline uint32, regFile *register.RegisterFile) {
 
 	if inst.instype == R || inst.instype == S || inst.instype == B {
 
@@ -146,22 +269,22 @@ func (inst *Instruction) extractOperands(romline uint32, regFile register.Regist
 
 		inst.funct7 = SubBits(romline, 25, 31)
 	case I:
-		inst.imm = SubBits(romline, 20, 31)
+		inst.imm = uint64(SubBits(romline, 20, 31))
 	case S:
-		inst.imm = SubBits(romline, 25, 31)<<5 | SubBits(romline, 7, 11)
+		inst.imm = uint64(SubBits(romline, 25, 31)<<5 | SubBits(romline, 7, 11))
 
 	case B:
-		inst.imm = ((SubBits(romline, 31, 31) << 11) |
+		inst.imm = uint64(((SubBits(romline, 31, 31) << 11) |
 			(SubBits(romline, 7, 7) << 10) |
 			(SubBits(romline, 25, 30) << 4) |
-			SubBits(romline, 8, 11)) << 1
+			SubBits(romline, 8, 11)) << 1)
 	case U:
-		inst.imm = SubBits(romline, 12, 31)
+		inst.imm = uint64(SubBits(romline, 12, 31))
 	case J:
-		inst.imm = ((SubBits(romline, 31, 31) << 19) |
+		inst.imm = uint64(((SubBits(romline, 31, 31) << 19) |
 			(SubBits(romline, 12, 19) << 11) |
 			(SubBits(romline, 20, 20) << 10) |
-			(SubBits(romline, 21, 30))) << 1
+			(SubBits(romline, 21, 30))) << 1)
 
 	}
 
@@ -171,41 +294,113 @@ func (inst *Instruction) extractOperands(romline uint32, regFile register.Regist
 // get instruction from ram
 func (cpu *Cpu) fetchInst(instChannel chan *Instruction) {
 	var inst *Instruction = nil
-	if !cpu.stall {
-		data := cpu.Ram.GetLine(cpu.pc)
-		if data != 0 {
-			inst = &Instruction{
-				romline: data,
-				stage:   IF,
-				pc:      cpu.pc,
-			}
-		}
+	//pending fetch of other pc is stale
+	if cpu.fetchNext != nil && cpu.fetchNext.pc != cpu.pc {
+		cpu.fetchNext = nil
+		cpu.fetchWait = 0
+	}
+	if cpu.fetchNext == nil {
+		cpu.fetchNext, cpu.fetchWait = cpu.fetchLine(cpu.pc)
+	}
+	if cpu.fetchWait > 0 {
+		cpu.fetchWait--
+	} else {
+		inst = cpu.fetchNext
+		cpu.fetchNext = nil
+	}
+	if inst != nil && inst.trap == nil {
+		cpu.predictor().predict(inst, cpu.inflight()...)
 	}
 
 	instChannel <- inst
 
 }
 
+// translate pc and read instruction word,
+// faulting fetch is passed down pipeline with its trap
+func (cpu *Cpu) fetchLine(pc uint64) (*Instruction, uint32) {
+
+	//memory stage of this cycle or earlier access holds the port
+	if cpu.ICache == nil && cpu.portBusy() {
+		cpu.Stats.Bus.FetchStalls++
+		return nil, 0
+	}
+	pa, latency, fault := cpu.translate(pc, 4, ACCESS_FETCH, cpu.priv)
+	if fault != nil {
+		return &Instruction{stage: IF, pc: pc, priv: cpu.priv, trap: fault}, 0
+	}
+	if cpu.ICache != nil {
+		latency += cpu.ICache.Access(pa, false)
+	} else {
+		latency += cpu.claimPort(pa, false)
+	}
+	data := uint32(cpu.readPhys(pa, 4))
+	if data == 0 {
+		return nil, 0
+	}
+	return &Instruction{
+		romline: data,
+		stage:   IF,
+		pc:      pc,
+		priv:    cpu.priv,
+	}, latency
+}
+
+// second fetch lane of wide core reads next sequential line,
+// nil if first one leaves sequential path or line is not ready this cycle
+func (cpu *Cpu) fetchSecond(first *Instruction) *Instruction {
+
+	if first == nil || first.trap != nil || first.predicted != first.pc+4 {
+		return nil
+	}
+	//fetch block ends at page or cache line boundary
+	pc := cpu.trunc(first.pc + 4)
+	if pc&0xFFF == 0 || (cpu.ICache != nil && !cpu.ICache.SameLine(first.pc, pc)) {
+		return nil
+	}
+	inst, latency := cpu.fetchLine(pc)
+	if inst == nil || inst.trap != nil || latency > 0 {
+		return nil
+	}
+	cpu.predictor().predict(inst, append(cpu.inflight(), first)...)
+	return inst
+}
+
 // stage 2
 // parse instruction
 // get operands
 // fetch registers
 func (cpu *Cpu) decodeInst(inst *Instruction, instChannelOut chan *Instruction) {
 
-	if inst == nil {
-		instChannelOut <- nil
+	if inst == nil || inst.trap != nil {
+		instChannelOut <- inst
 		return
 	}
 
 	var opcode = 0b1111111 & inst.romline
 
-	if instype, ok := opcodesMapping[opcode]; ok {
+	//word sized rv64 opcodes and doubleword memory ops are reserved in rv32
+	_, rv64only := rv64Opcodes[opcode]
+	rv64only = rv64only || rv64MemOp(opcode, SubBits(inst.romline, 12, 14))
+
+	if instype, ok := opcodesMapping[opcode]; ok && (!rv64only || cpu.xlen() == 64) {
 
 		inst.instype = instype
 		inst.opcode = opcode
 
-		inst.extractOperands(inst.romline, cpu.regFile)
+		inst.extractOperands(inst.romline, &cpu.regFile)
+		cpu.checkRegisters(inst)
+		inst.stage = ID
+	} else if cpu.Vector != nil && (opcode == OPV ||
+		((opcode == LOADV || opcode == STOREV) && isVectorMemWidth(SubBits(inst.romline, 12, 14)))) {
+
+		inst.instype = V
+		inst.opcode = opcode
+		inst.extractVectorOperands(inst.romline, &cpu.regFile)
+		cpu.checkRegisters(inst)
 		inst.stage = ID
+	} else {
+		inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline))
 	}
 
 	instChannelOut <- inst
@@ -219,13 +414,34 @@ func (cpu *Cpu) executeInst(inst *Instruction, instChannelOut chan *Instruction)
 		instChannelOut <- nil
 		return
 	}
-	switch inst.instype {
+	shmask := uint64(cpu.xlen() - 1)
+	instype := inst.instype
+	//instruction already faulted in decode
+	if inst.trap != nil {
+		instype = 0
+	}
+	switch instype {
 	case R:
 		{
 			inst.wbop = &Wbops{
 
 				dest: inst.rd,
 			}
+			//LR,SC,AMO*
+			if inst.opcode == AMO {
+				cpu.atomic(inst)
+				break
+			}
+			//MUL,DIV,REM and word forms
+			if inst.funct7 == 0x01 {
+				cpu.mulDiv(inst)
+				break
+			}
+			//ADDW,SUBW,SLLW,SRLW,SRAW
+			if inst.opcode == OP32 {
+				cpu.wordOp(inst)
+				break
+			}
 			switch inst.funct3 {
 
 			//ADD , SUB
@@ -239,6 +455,11 @@ func (cpu *Cpu) executeInst(inst *Instruction, instChannelOut chan *Instruction)
 					//SUB
 					case 0x20:
 						inst.wbop.data = inst.rs1 - inst.rs2
+					//AES32*,SHA512*,rv32 only
+					default:
+						if cpu.xlen() != 32 || !cryptoR(inst) {
+							inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline))
+						}
 
 					}
 
@@ -250,10 +471,13 @@ func (cpu *Cpu) executeInst(inst *Instruction, instChannelOut chan *Instruction)
 			//OR
 			case 0x6:
 				inst.wbop.data = inst.rs1 | inst.rs2
+			//AND
+			case 0x7:
+				inst.wbop.data = inst.rs1 & inst.rs2
 
 			//SLL
 			case 0x1:
-				inst.wbop.data = inst.rs1 << (inst.rs2 & 0b11111)
+				inst.wbop.data = inst.rs1 << (inst.rs2 & shmask)
 			//SRL , SRA
 			case 0x5:
 				{
@@ -261,24 +485,24 @@ func (cpu *Cpu) executeInst(inst *Instruction, instChannelOut chan *Instruction)
 					switch inst.funct7 {
 					//SRL
 					case 0x0:
-						inst.wbop.data = inst.rs1 >> (inst.rs2 & 0b11111)
+						inst.wbop.data = inst.rs1 >> (inst.rs2 & shmask)
 					//SRA
 					case 0x20:
-						inst.wbop.data = uint32(int(inst.rs1) >> (inst.rs2 & 0b11111))
+						inst.wbop.data = uint64(cpu.signed(inst.rs1) >> (inst.rs2 & shmask))
 
 					}
 
 				}
 			//SLT
 			case 0x2:
-				var fl uint32 = 0
-				if int32(inst.rs1) < int32(inst.rs2) {
+				var fl uint64 = 0
+				if cpu.signed(inst.rs1) < cpu.signed(inst.rs2) {
 					fl = 1
 				}
 				inst.wbop.data = fl
 			//SLTU
 			case 0x3:
-				var fl uint32 = 0
+				var fl uint64 = 0
 				if inst.rs1 < inst.rs2 {
 					fl = 1
 				}
@@ -288,6 +512,7 @@ func (cpu *Cpu) executeInst(inst *Instruction, instChannelOut chan *Instruction)
 		}
 	case I:
 
+		imm := cpu.trunc(SignExtend64(inst.imm, 12))
 		switch inst.opcode {
 		case 0b0010011:
 			inst.wbop = &Wbops{
@@ -299,49 +524,67 @@ func (cpu *Cpu) executeInst(inst *Instruction, instChannelOut chan *Instruction)
 			//ADDI
 			case 0x0:
 				//sign extend
-				inst.wbop.data = SignExtend(inst.imm, 12) + inst.rs1
+				inst.wbop.data = imm + inst.rs1
 			//XORI
 			case 0x4:
 				//sign extend
-				inst.wbop.data = SignExtend(inst.imm, 12) ^ inst.rs1
+				inst.wbop.data = imm ^ inst.rs1
 			//ORI
 			case 0x6:
 				//sign extend
-				inst.wbop.data = SignExtend(inst.imm, 12) | inst.rs1
+				inst.wbop.data = imm | inst.rs1
 			//ANDI
 			case 0x7:
 				//sign extend
-				inst.wbop.data = SignExtend(inst.imm, 12) & inst.rs1
-			//SLLI
+				inst.wbop.data = imm & inst.rs1
+			//SLLI,SHA256*
 			case 0x1:
 
-				inst.wbop.data = inst.rs1 << (inst.imm & 0b11111)
+				if imm := uint32(inst.imm); SubBits(imm, 5, 11) == 0b0001000 {
+					//rv64 sign extends 32 bit result,rest of immediates next to them are reserved
+					if imm >= SHA256SUM0 && imm <= SHA256SIG1 {
+						inst.wbop.data = uint64(int32(Sha256(imm, uint32(inst.rs1))))
+					} else {
+						inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline))
+					}
+				} else {
+					inst.wbop.data = inst.rs1 << (inst.imm & shmask)
+				}
 			//SRLI,SRAI
 			case 0x5:
-				shv := SubBits(inst.imm, 0, 4)
-				switch SubBits(inst.imm, 5, 11) {
+				//rv64 shamt is 6 bits,so funct6 instead of funct7
+				shv := inst.imm & shmask
+				switch inst.imm >> 5 &^ (shmask >> 5) {
 				case 0b0:
 					inst.wbop.data = inst.rs1 >> shv
 				case 0b0100000:
-					inst.wbop.data = uint32(int(inst.rs1) >> shv)
+					inst.wbop.data = uint64(cpu.signed(inst.rs1) >> shv)
 				}
 			//SLTI
 			case 0x2:
-				var fl uint32 = 0
-				if int32(inst.rs1) < int32(SignExtend(inst.imm, 12)) {
+				var fl uint64 = 0
+				if cpu.signed(inst.rs1) < cpu.signed(imm) {
 					fl = 1
 				}
 				inst.wbop.data = fl
 			//SLTIU
 			case 0x3:
-				var fl uint32 = 0
-				if inst.rs1 < SignExtend(inst.imm, 12) {
+				var fl uint64 = 0
+				if inst.rs1 < imm {
 					fl = 1
 				}
 				inst.wbop.data = fl
 
 			}
 
+			//ADDIW,SLLIW,SRLIW,SRAIW
+		case OPIMM32:
+			inst.wbop = &Wbops{
+
+				dest: inst.rd,
+			}
+			cpu.wordOp(inst)
+
 			//MEMORY LOADS
 		case 0b0000011:
 			inst.memop = &Memops{
@@ -355,28 +598,41 @@ func (cpu *Cpu) executeInst(inst *Instruction, instChannelOut chan *Instruction)
 			switch inst.funct3 {
 			//LB
 			case 0x0:
-				inst.memop.address = inst.rs1 + SignExtend(inst.imm, 12)
+				inst.memop.address = inst.rs1 + imm
 				inst.memop.data_mask = 0xFF
 				inst.memop.signed = true
 			//LH
 			case 0x1:
-				inst.memop.address = inst.rs1 + SignExtend(inst.imm, 12)
+				inst.memop.address = inst.rs1 + imm
 				inst.memop.data_mask = 0xFFFF
 				inst.memop.signed = true
 			//LW
 			case 0x2:
-				inst.memop.address = inst.rs1 + SignExtend(inst.imm, 12)
+				inst.memop.address = inst.rs1 + imm
 				inst.memop.data_mask = 0xFFFF_FFFF
 				inst.memop.signed = true
+			//LD
+			case 0x3:
+				inst.memop.address = inst.rs1 + imm
+				inst.memop.data_mask = 0xFFFF_FFFF_FFFF_FFFF
+				inst.memop.signed = true
 			//LBU
 			case 0x4:
-				inst.memop.address = inst.rs1 + SignExtend(inst.imm, 12)
+				inst.memop.address = inst.rs1 + imm
 				inst.memop.data_mask = 0xFF
 			//LHU
 			case 0x5:
-				inst.memop.address = inst.rs1 + SignExtend(inst.imm, 12)
+				inst.memop.address = inst.rs1 + imm
 				inst.memop.data_mask = 0xFFFF
-
+			//LWU
+			case 0x6:
+				inst.memop.address = inst.rs1 + imm
+				inst.memop.data_mask = 0xFFFF_FFFF
+			default:
+				inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline))
+			}
+			if inst.memop != nil {
+				inst.memop.address = cpu.trunc(inst.memop.address)
 			}
 			//JALR
 		case 0b1100111:
@@ -386,64 +642,69 @@ func (cpu *Cpu) executeInst(inst *Instruction, instChannelOut chan *Instruction)
 				dest: inst.rd,
 				data: inst.pc + 4,
 			}
-			cpu.pc = inst.rs1 + SignExtend(inst.imm, 12)
+			inst.taken = true
+			inst.target = cpu.trunc(inst.rs1+imm) &^ 1
+
+			//FENCE,FENCE.I
+		case MISC_MEM:
+			cpu.fence(inst)
+
+			//CSR*,ECALL,EBREAK,SRET,MRET,WFI
+		case SYSTEM:
+			if inst.funct3 == 0x0 {
+				cpu.systemInst(inst)
+			} else if inst.funct3 == 0x4 || !cpu.csrAccess(inst) {
+				inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline))
+			}
 
 		}
 	case S:
 		inst.memop = &Memops{
-			optype: STORE,
+			optype:  STORE,
+			address: cpu.trunc(inst.rs1 + SignExtend64(inst.imm, 12)),
 		}
 		switch inst.funct3 {
 		//SB
 		case 0x0:
-			inst.memop.address = inst.rs1 + SignExtend(inst.imm, 12)
 			inst.memop.data_mask = 0xFFFF_FF00
 			inst.memop.data = inst.rs2 & 0xFF
 		//SH
 		case 0x1:
-			inst.memop.address = inst.rs1 + SignExtend(inst.imm, 12)
 			inst.memop.data_mask = 0xFFFF_0000
 			inst.memop.data = inst.rs2 & 0xFFFF
 		//SW
 		case 0x2:
-			inst.memop.address = inst.rs1 + SignExtend(inst.imm, 12)
 			inst.memop.data_mask = 0x0
 			inst.memop.data = inst.rs2 & 0xFFFF_FFFF
-
+		//SD
+		case 0x3:
+			inst.memop.data_mask = 0x0
+			inst.memop.data = inst.rs2
+		default:
+			inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline))
 		}
 	case B:
 
+		inst.target = cpu.trunc(inst.pc + SignExtend64(inst.imm, 13))
 		switch inst.funct3 {
 		//BEQ
 		case 0x0:
-			if inst.rs1 == inst.rs2 {
-				cpu.pc = inst.pc + SignExtend(inst.imm, 12)
-			}
+			inst.taken = inst.rs1 == inst.rs2
 		//BNE
 		case 0x1:
-			if inst.rs1 != inst.rs2 {
-				cpu.pc = inst.pc + SignExtend(inst.imm, 12)
-			}
+			inst.taken = inst.rs1 != inst.rs2
 		//BLT
 		case 0x4:
-			if int32(inst.rs1) < int32(inst.rs2) {
-				cpu.pc = inst.pc + SignExtend(inst.imm, 12)
-			}
+			inst.taken = cpu.signed(inst.rs1) < cpu.signed(inst.rs2)
 		//BGE
 		case 0x5:
-			if int32(inst.rs1) >= int32(inst.rs2) {
-				cpu.pc = inst.pc + SignExtend(inst.imm, 12)
-			}
+			inst.taken = cpu.signed(inst.rs1) >= cpu.signed(inst.rs2)
 		//BLTU
 		case 0x6:
-			if inst.rs1 < inst.rs2 {
-				cpu.pc = inst.pc + SignExtend(inst.imm, 12)
-			}
+			inst.taken = inst.rs1 < inst.rs2
 		//BGEU
 		case 0x7:
-			if inst.rs1 >= inst.rs2 {
-				cpu.pc = inst.pc + SignExtend(inst.imm, 12)
-			}
+			inst.taken = inst.rs1 >= inst.rs2
 		}
 	case J:
 		//JAL
@@ -452,7 +713,8 @@ func (cpu *Cpu) executeInst(inst *Instruction, instChannelOut chan *Instruction)
 			dest: inst.rd,
 			data: inst.pc + 4,
 		}
-		cpu.pc = inst.pc + SignExtend(inst.imm, 20)
+		inst.taken = true
+		inst.target = cpu.trunc(inst.pc + SignExtend64(inst.imm, 21))
 	case U:
 		switch inst.opcode {
 		//LUI
@@ -460,16 +722,24 @@ func (cpu *Cpu) executeInst(inst *Instruction, instChannelOut chan *Instruction)
 			inst.wbop = &Wbops{
 
 				dest: inst.rd,
-				data: SignExtend(inst.imm<<12, 31),
+				data: SignExtend64(inst.imm<<12, 32),
 			}
 		//AUIPC
 		case 0b0010111:
 			inst.wbop = &Wbops{
 
 				dest: inst.rd,
-				data: inst.pc + SignExtend(inst.imm<<12, 31),
+				data: inst.pc + SignExtend64(inst.imm<<12, 32),
 			}
 		}
+	case V:
+		cpu.executeVector(inst)
+	}
+	if inst.memop != nil {
+		cpu.translateMemop(inst)
+	}
+	if inst.wbop != nil {
+		inst.wbop.data = cpu.trunc(inst.wbop.data)
 	}
 	inst.stage = IE
 	instChannelOut <- inst
@@ -483,24 +753,40 @@ func (cpu *Cpu) memOps(inst *Instruction, instChannelOut chan *Instruction) {
 		instChannelOut <- nil
 		return
 	}
-	if inst.memop != nil {
+	if inst.vecop != nil && inst.memop != nil {
+
+		cpu.vectorMemOps(inst)
+
+	} else if inst.memop != nil {
+
+		//access size from funct3,byte addressed so sub word access
+		//lands on right lane of memory line.
+		//address is physical,translation and pmp check faulted in execute
+		size := uint32(1) << (inst.funct3 & 0b11)
+		address := inst.memop.address
 
-		if inst.memop.optype == LOAD {
+		inst.memop.accessLatency = cpu.dataAccess(address, size, inst.memop.optype != LOAD)
+		if inst.opcode == AMO {
 
-			data := cpu.Ram.GetLine(inst.memop.address) & inst.memop.data_mask
+			cpu.atomicMemOps(inst, size)
+
+		} else if inst.memop.optype == LOAD {
+
+			data := cpu.readPhys(address, size)
 			if inst.memop.signed {
-				data = SignExtend(data, uint8(bits.OnesCount32(inst.memop.data_mask)))
+				data = SignExtend64(data, uint8(size*8))
 			}
-			inst.wbop.data = data
+			inst.wbop.data = cpu.trunc(data)
 
 		} else {
 
-			data := cpu.Ram.GetLine(inst.memop.address)
-			data = (data & inst.memop.data_mask) | inst.memop.data
-			cpu.Ram.SetLine(inst.memop.address, data)
+			cpu.writePhys(inst, address, size, inst.memop.data)
 
 		}
 	}
+	if inst.memop != nil {
+		cpu.memWait = inst.memop.latency + inst.memop.accessLatency
+	}
 	inst.stage = MEM
 	instChannelOut <- inst
 
@@ -516,101 +802,202 @@ func (cpu *Cpu) writeBack(inst *Instruction, instChannelOut chan *Instruction) {
 
 	if inst.wbop != nil {
 
+		if cpu.Travel != nil {
+			cpu.Travel.registerWrite(cpu, inst.wbop)
+		}
 		cpu.regFile.SetRegVal(inst.wbop.dest, inst.wbop.data)
 	}
 	inst.stage = WB
+	if cpu.Travel != nil {
+		cpu.Travel.retire(inst)
+	}
+	cpu.countRetired(inst)
+	if cpu.CommitLog != nil {
+		cpu.CommitLog.record(cpu, inst)
+	}
+	if cpu.Lockstep != nil {
+		cpu.Lockstep.check(cpu, inst)
+	}
 
 	instChannelOut <- inst
 
 }
 
-func (cpu *Cpu) hazardHandler() {
+// forwards results of executed instructions to decoded ones,
+// returns true if bundle about to execute must wait
+// for operand that is not ready yet
+func (cpu *Cpu) hazardHandler() bool {
 
-	////if branch instruction already executed,remove stall
-	if cpu.stall && IsBranchIns(cpu.instStorage[4]) {
-		cpu.stall = false
+	l := cpu.pipeline()
+	latch := cpu.instStorage
+	cpu.loadUse = false
+	if l.execute == 0 {
+		return false
 	}
 
-	////if decoded instruction is branch inst,
-	////stall cpu(not fetch new instuction until cur inst executed)
-	if IsBranchIns(cpu.instStorage[1]) {
-
-		cpu.instStorage[0] = nil
-		cpu.stall = true
+	////vector register file is accessed by vector memory ops in memory stage,
+	////hold next vector instruction until previous one leaves it.
+	////vector instructions issue alone so they are always in lane 0
+	if next := latch[l.execute-1][0]; next != nil && next.vecop != nil {
+		for i := l.execute; i < l.memory; i++ {
+			if prev := latch[i][0]; prev != nil && prev.vecop != nil && prev.memop != nil {
+				return true
+			}
+		}
 	}
 
-	if cpu.instStorage[1] != nil && (cpu.instStorage[1].rs2_index != 0 || cpu.instStorage[1].rs1_index != 0) {
-
-		rs1_found := false
-		rs2_found := false
-
-		for i := 2; i < len(cpu.instStorage); i++ {
-
-			if cpu.instStorage[i] == nil || cpu.instStorage[i].wbop == nil {
+	interlock := false
+	for c := l.execute - 1; c >= l.decode; c-- {
+		for _, consumer := range latch[c] {
+			if consumer == nil || consumer.trap != nil || (consumer.rs2_index == 0 && consumer.rs1_index == 0) {
 				continue
 			}
+			if cpu.forward(consumer) && c == l.execute-1 {
+				interlock = true
+				cpu.loadUse = true
+			}
+		}
+	}
+	return interlock
+}
 
-			//detect interlock for load instructions
-			//if load instruction
+// copies youngest executed results into consumer operands,
+// returns true if operand waits for load data
+func (cpu *Cpu) forward(consumer *Instruction) bool {
 
-			if i == 2 && cpu.instStorage[2].opcode == 0b0000011 &&
-				(cpu.instStorage[2].wbop.dest == cpu.instStorage[1].rs1_index ||
-					cpu.instStorage[2].wbop.dest == cpu.instStorage[1].rs2_index) {
+	l := cpu.pipeline()
+	latch := cpu.instStorage
+	rs1_found := false
+	rs2_found := false
+	wait := false
 
-				//insert nop in pipeline
+	for i := l.execute; i < len(latch); i++ {
 
-				cpu.instStorage[0] = cpu.instStorage[1]
-				cpu.pc = cpu.instStorage[1].pc
-				cpu.instStorage[1] = nil
-				break
+		//younger lane of bundle wins
+		for lane := len(latch[i]) - 1; lane >= 0; lane-- {
 
+			//x0 is never forwarded
+			producer := latch[i][lane]
+			if producer == nil || producer.wbop == nil || producer.wbop.dest == 0 {
+				continue
 			}
-			if !rs1_found && cpu.instStorage[i].wbop.dest == cpu.instStorage[1].rs1_index {
-
-				cpu.instStorage[1].rs1 = cpu.instStorage[i].wbop.data
-				rs1_found = true
+			rs1 := !rs1_found && producer.wbop.dest == consumer.rs1_index
+			rs2 := !rs2_found && producer.wbop.dest == consumer.rs2_index
+			if !rs1 && !rs2 {
+				continue
 			}
-			if !rs2_found && cpu.instStorage[i].wbop.dest == cpu.instStorage[1].rs2_index {
-
-				cpu.instStorage[1].rs2 = cpu.instStorage[i].wbop.data
-				rs2_found = true
+			rs1_found = rs1_found || rs1
+			rs2_found = rs2_found || rs2
+
+			//load data is ready after memory stage,
+			//interlock inserts bubble in front of load use.
+			//other operand may still come from older producer
+			//that retires while consumer waits
+			if producer.memop != nil && i < l.memDone {
+				wait = true
+				continue
+			}
+			if rs1 {
+				consumer.rs1 = producer.wbop.data
+				consumer.forwarded[0] = i
+			}
+			if rs2 {
+				consumer.rs2 = producer.wbop.data
+				consumer.forwarded[1] = i
 			}
-
 			if rs2_found && rs1_found {
-				break
+				return wait
 			}
-
 		}
+	}
+	return wait
+}
 
+// slot holding younger instructions in this cycle,
+// -1 if whole pipeline advances
+func (cpu *Cpu) holdSlot() int {
+
+	l := cpu.pipeline()
+	//multi cycle vector instruction keeps execute stage,
+	//older instructions drain while front of pipeline waits
+	if cpu.vectorBusy() {
+		return l.execute
+	}
+	//memory stage waits for data tlb,younger instructions hold
+	if cpu.memWait > 0 {
+		cpu.memWait--
+		return l.memory
+	}
+	if cpu.interlock {
+		if cpu.loadUse {
+			cpu.Stats.LoadUse++
+		}
+		return l.execute
 	}
+	return -1
 }
 
 func (cpu *Cpu) ClockCycle() {
 
-	fetched := make(chan *Instruction)
-	decoded := make(chan *Instruction)
-	executed := make(chan *Instruction)
-	memopsed := make(chan *Instruction)
-	wbed := make(chan *Instruction)
+	//hart stops at first lockstep mismatch or input log error
+	if cpu.stopped() {
+		return
+	}
+	cpu.powerOn()
+	cpu.Stats.Cycles++
+	if cpu.Input != nil {
+		cpu.Input.deliver(cpu)
+	}
 
-	go cpu.fetchInst(fetched)
-	go cpu.decodeInst(cpu.instStorage[0], decoded)
-	go cpu.executeInst(cpu.instStorage[1], executed)
-	go cpu.memOps(cpu.instStorage[2], memopsed)
-	go cpu.writeBack(cpu.instStorage[3], wbed)
+	l := cpu.pipeline()
+	hold := cpu.holdSlot()
+	var split *Instruction
+	if hold < l.execute {
+		split = cpu.issueBundle()
+	}
+	if split != nil {
+		hold = l.execute - 1
+	}
+	cpu.advance(hold)
+	if split != nil {
+		cpu.instStorage[hold][0] = split
+	}
+
+	//fetch follows predicted path
+	if fetched := cpu.instStorage[0]; hold < 0 && fetched[0] != nil && fetched[0].pc == cpu.pc {
+		cpu.pc = cpu.trunc(youngest(fetched).predicted)
+	}
 
-	cpu.instStorage[4] = <-wbed
-	cpu.instStorage[3] = <-memopsed
-	cpu.instStorage[2] = <-executed
-	cpu.instStorage[1] = <-decoded
-	cpu.instStorage[0] = <-fetched
+	//fetch ran concurrently with execute,
+	//wrong path is squashed now that every slot latched
+	if hold < l.execute {
+		cpu.resolveBundle()
+	}
 
-	cpu.hazardHandler()
-	//if we don't have branch instruction
-	if cpu.instStorage[0] != nil && cpu.instStorage[0].pc == cpu.pc && !cpu.stall {
-		cpu.pc = cpu.pc + 4
+	cpu.interlock = cpu.hazardHandler()
+	if cpu.Input != nil {
+		cpu.Input.endCycle(cpu.Stats.Cycles)
 	}
+	if cpu.Trace != nil {
+		cpu.Trace.record(cpu)
+	}
+	if cpu.Travel != nil {
+		cpu.Travel.endCycle(cpu)
+	}
+
+}
+
+// boot state set by machine profile before first cycle
+func (cpu *Cpu) SetPc(pc uint64) {
+	cpu.pc = pc
+}
+
+func (cpu *Cpu) SetRegister(index uint32, val uint64) {
+	cpu.regFile.SetRegVal(index, cpu.trunc(val))
+}
 
+func (cpu *Cpu) Register(index uint32) uint64 {
+	return cpu.regFile.GetRegVal(index)
 }
 
 func (cpu *Cpu) LoadRom(path string) {
@@ -79,7 +79,7 @@ func TestDecodeInst(t *testing.T) {
 	//init registers with test data
 	regFile := register.RegisterFile{}
 	for i := 0; i < 32; i++ {
-		regFile.SetRegVal(uint32(i), uint32(i))
+		regFile.SetRegVal(uint32(i), uint64(i))
 	}
 
 	outchan := make(chan *Instruction)
@@ -197,14 +197,14 @@ func TestDecodeInst(t *testing.T) {
 
 }
 
-// Note that all control input instruction has same pc after execution as imm
-// (IsBranchIns(inst) && cpu.pc != decoded.imm)
+// Note that all control input instruction has same next pc after execution as imm
+// (IsBranchIns(inst) && decoded.nextPc() != decoded.imm)
 func TestExecuteInst(t *testing.T) {
 
 	regFile := register.RegisterFile{}
 	outchan := make(chan *Instruction)
 	for i := 0; i < 32; i++ {
-		regFile.SetRegVal(uint32(i), uint32(i))
+		regFile.SetRegVal(uint32(i), uint64(i))
 	}
 
 	var input_instructions = []*Instruction{
@@ -288,7 +288,7 @@ func TestExecuteInst(t *testing.T) {
 		executed := <-outchan
 		if v.wbop != nil && !reflect.DeepEqual(v.wbop, executed.wbop) ||
 			(v.memop != nil && !reflect.DeepEqual(v.memop, executed.memop)) ||
-			(IsBranchIns(inst) && cpu.pc != decoded.imm) {
+			(IsBranchIns(inst) && executed.nextPc() != decoded.imm) {
 			t.Errorf("\"TestExecuteInst()\" FAILED, expected -> %v, got -> %v opcode -> %07b romline->  %032b", executed, v, v.opcode, v.romline)
 			return
 		}
@@ -315,7 +315,7 @@ func TestDataHazardHandler(t *testing.T) {
 	regFile := register.RegisterFile{}
 
 	for i := 0; i < 32; i++ {
-		regFile.SetRegVal(uint32(i), uint32(i))
+		regFile.SetRegVal(uint32(i), uint64(i))
 	}
 	cpu := Cpu{
 		regFile: regFile,
@@ -370,7 +370,7 @@ func TestInterlockDataHazardHandler(t *testing.T) {
 	regFile := register.RegisterFile{}
 
 	for i := 0; i < 32; i++ {
-		regFile.SetRegVal(uint32(i), uint32(i))
+		regFile.SetRegVal(uint32(i), uint64(i))
 	}
 	cpu := Cpu{
 		regFile: regFile,
@@ -399,6 +399,36 @@ func TestInterlockDataHazardHandler(t *testing.T) {
 
 }
 
+func TestLoadUseOtherOperand(t *testing.T) {
+
+	//addi x2, x0, 7
+	//addi x0, x0, 0
+	//lw x1, 0x100(x0)
+	//add x3, x2, x1
+	//x2 comes from producer older than load,it
+	//retires while add waits for load data
+	program := []uint32{
+		iType(7, 0, 0, 2, 0b0010011),
+		iType(0, 0, 0, 0, 0b0010011),
+		iType(0x100, 0, 2, 1, 0b0000011),
+		rType(0, 1, 2, 0, 3, 0b0110011),
+	}
+	for name, config := range Pipelines {
+		cpu := Cpu{}
+		cpu.SetPipeline(config)
+		for i, v := range program {
+			cpu.Ram.SetLine(uint32(i*4), v)
+		}
+		cpu.Ram.Write(0x100, 4, 5)
+		for i := 0; i < 50; i++ {
+			cpu.ClockCycle()
+		}
+		if cpu.regFile.GetRegVal(3) != 12 {
+			t.Errorf("\"TestLoadUseOtherOperand()\" FAILED on %s, expected -> 12, got -> %d", name, cpu.regFile.GetRegVal(3))
+		}
+	}
+}
+
 func TestControlHazardHandler(t *testing.T) {
 
 	//test interlock data hazard
@@ -441,7 +471,7 @@ func TestControlHazardHandler(t *testing.T) {
 	regFile := register.RegisterFile{}
 
 	for i := 0; i < 32; i++ {
-		regFile.SetRegVal(uint32(i), uint32(i))
+		regFile.SetRegVal(uint32(i), uint64(i))
 	}
 	cpu := Cpu{
 		regFile: regFile,
@@ -513,3 +543,172 @@ func TestRomExecution(t *testing.T) {
 
 	}
 }
+
+func TestRv64Execution(t *testing.T) {
+
+	//addi a0, x0, -1
+	//srli a1, a0, 32
+	//addiw a2, a1, 1
+	//addiw a3, a1, 0
+	//slli a4, a1, 36
+	//sd a4, 0x100(x0)
+	//ld a5, 0x100(x0)
+	//lwu a6, 0x104(x0)
+	//lw a7, 0x104(x0)
+	//subw s2, x0, a1
+	//sraiw s3, a3, 4
+	//mulw s4, a1, a1
+	//mulhu s5, a0, a0
+	//lui s6, 0x80000
+	//srai s7, s6, 63
+
+	var program = []uint32{
+		0xfff00513,
+		0x02055593,
+		0x0015861b,
+		0x0005869b,
+		0x02459713,
+		0x10e03023,
+		0x10003783,
+		0x10406803,
+		0x10402883,
+		0x40b0093b,
+		0x4046d99b,
+		0x02b58a3b,
+		0x02a53ab3,
+		0x80000b37,
+		0x43fb5b93,
+	}
+
+	var expected = map[uint32]uint64{
+		10: 0xFFFF_FFFF_FFFF_FFFF,
+		11: 0x0000_0000_FFFF_FFFF,
+		12: 0,
+		13: 0xFFFF_FFFF_FFFF_FFFF,
+		14: 0xFFFF_FFF0_0000_0000,
+		15: 0xFFFF_FFF0_0000_0000,
+		16: 0x0000_0000_FFFF_FFF0,
+		17: 0xFFFF_FFFF_FFFF_FFF0,
+		18: 1,
+		19: 0xFFFF_FFFF_FFFF_FFFF,
+		20: 1,
+		21: 0xFFFF_FFFF_FFFF_FFFE,
+		22: 0xFFFF_FFFF_8000_0000,
+		23: 0xFFFF_FFFF_FFFF_FFFF,
+	}
+
+	cpu := Cpu{
+		Xlen: 64,
+	}
+	for i, v := range program {
+		cpu.Ram.SetLine(uint32(i*4), v)
+	}
+	for i := 0; i < 1000; i++ {
+		cpu.ClockCycle()
+	}
+	for reg, v := range expected {
+		if cpu.regFile.GetRegVal(reg) != v {
+			t.Errorf("\"TestRv64Execution()\" FAILED x%d, expected -> %016x, got -> %016x", reg, v, cpu.regFile.GetRegVal(reg))
+		}
+	}
+
+	//word instructions do not exist in rv32
+	cpu = Cpu{}
+	for i, v := range program {
+		cpu.Ram.SetLine(uint32(i*4), v)
+	}
+	for i := 0; i < 1000; i++ {
+		cpu.ClockCycle()
+	}
+	if cpu.regFile.GetRegVal(10) != 0xFFFF_FFFF || cpu.regFile.GetRegVal(12) != 0 {
+		t.Errorf("\"TestRv64Execution()\" FAILED, rv32 a0 -> %x a2 -> %x", cpu.regFile.GetRegVal(10), cpu.regFile.GetRegVal(12))
+	}
+
+	//neither do ld,lwu and sd,sd must not write word after 0x100
+	var doubleword = map[uint32]uint32{
+		0x00: 0x04000293, //addi t0, x0, 0x40
+		0x04: 0x30529073, //csrrw x0, mtvec, t0
+		0x08: 0xfff00513, //addi a0, x0, -1
+		0x0c: 0x10a03023, //sd a0, 0x100(x0)
+		0x10: 0x10003783, //ld a5, 0x100(x0)
+		0x14: 0x10406803, //lwu a6, 0x104(x0)
+		0x40: 0x00148493, //addi s1, s1, 1
+		0x44: 0x34102473, //csrrs s0, mepc, x0
+		0x48: 0x00440413, //addi s0, s0, 4
+		0x4c: 0x34141073, //csrrw x0, mepc, s0
+		0x50: 0x30200073, //mret
+	}
+	cpu = Cpu{}
+	for address, v := range doubleword {
+		cpu.Ram.SetLine(address, v)
+	}
+	cpu.Ram.SetLine(0x104, 0x1234_5678)
+	for i := 0; i < 1000; i++ {
+		cpu.ClockCycle()
+	}
+	if cpu.regFile.GetRegVal(9) != 3 || cpu.Ram.GetLine(0x100) != 0 || cpu.Ram.GetLine(0x104) != 0x1234_5678 ||
+		cpu.regFile.GetRegVal(15) != 0 || cpu.regFile.GetRegVal(16) != 0 {
+		t.Errorf("\"TestRv64Execution()\" FAILED, rv32 traps -> %d memory -> %x %x a5 -> %x a6 -> %x", cpu.regFile.GetRegVal(9),
+			cpu.Ram.GetLine(0x100), cpu.Ram.GetLine(0x104), cpu.regFile.GetRegVal(15), cpu.regFile.GetRegVal(16))
+	}
+
+}
+
+func TestFenceI(t *testing.T) {
+
+	//lui t0, 0x02a50
+	//addi t0, t0, 0x513
+	//fence rw, rw
+	//sw t0, 0x14(x0)   //patch next instruction to addi a0, a0, 42
+	//fence.i
+	//addi a0, a0, 7
+	//addi a1, x0, 1
+
+	var self_modifying = []uint32{
+		0x02a502b7,
+		0x51328293,
+		0x0330000f,
+		0x00502a23,
+		0x0000100f,
+		0x00750513,
+		0x00100593,
+	}
+
+	for _, cached := range []bool{false, true} {
+		cpu := Cpu{}
+		if cached {
+			if err := cpu.SetCaches(DEFAULT_L1I, DEFAULT_L1D, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i, v := range self_modifying {
+			cpu.Ram.SetLine(uint32(i*4), v)
+		}
+		//first fetch after fence.i refills line of patched instruction
+		var fenced *CacheStats
+		refetch := false
+		for i := 0; i < 1000; i++ {
+			cpu.ClockCycle()
+			if !cached {
+				continue
+			}
+			if fenced == nil && cpu.Stats.Redirects.Serial == 1 {
+				stats := cpu.ICache.Stats
+				fenced = &stats
+			} else if fenced != nil && !refetch && cpu.ICache.Stats.Reads > fenced.Reads {
+				refetch = true
+				if cpu.ICache.Stats.Misses == fenced.Misses {
+					t.Errorf("\"TestFenceI()\" FAILED, refetch hit stale icache line")
+				}
+			}
+		}
+		if cached && !refetch {
+			t.Errorf("\"TestFenceI()\" FAILED, no fetch after fence.i")
+		}
+
+		if cpu.regFile.GetRegVal(10) != 42 || cpu.regFile.GetRegVal(11) != 1 {
+			t.Errorf("\"TestFenceI()\" FAILED, a0 -> %d a1 -> %d", cpu.regFile.GetRegVal(10), cpu.regFile.GetRegVal(11))
+		}
+	}
+
+}
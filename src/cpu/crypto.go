@@ -0,0 +1,190 @@
+package cpu
+
+import (
+	"math/bits"
+	"math/rand/v2"
+)
+
+// zkne/zknd/zknh funct7 values of OP major opcode (funct3 000)
+// aes ones carry byte select in funct7[6:5]
+const (
+	AES32ESI    = 0b10001
+	AES32ESMI   = 0b10011
+	AES32DSI    = 0b10101
+	AES32DSMI   = 0b10111
+	SHA512SUM0R = 0b0101000
+	SHA512SUM1R = 0b0101001
+	SHA512SIG0L = 0b0101010
+	SHA512SIG1L = 0b0101011
+	SHA512SIG0H = 0b0101110
+	SHA512SIG1H = 0b0101111
+)
+
+// zknh sha256 ones are OP-IMM funct3 001 with imm[11:0]
+const (
+	SHA256SUM0 = 0x100
+	SHA256SUM1 = 0x101
+	SHA256SIG0 = 0x102
+	SHA256SIG1 = 0x103
+)
+
+var aesSbox = [256]uint8{
+	0x63, 0x7c, 0x77, 0x7b, 0xf2, 0x6b, 0x6f, 0xc5, 0x30, 0x01, 0x67, 0x2b, 0xfe, 0xd7, 0xab, 0x76,
+	0xca, 0x82, 0xc9, 0x7d, 0xfa, 0x59, 0x47, 0xf0, 0xad, 0xd4, 0xa2, 0xaf, 0x9c, 0xa4, 0x72, 0xc0,
+	0xb7, 0xfd, 0x93, 0x26, 0x36, 0x3f, 0xf7, 0xcc, 0x34, 0xa5, 0xe5, 0xf1, 0x71, 0xd8, 0x31, 0x15,
+	0x04, 0xc7, 0x23, 0xc3, 0x18, 0x96, 0x05, 0x9a, 0x07, 0x12, 0x80, 0xe2, 0xeb, 0x27, 0xb2, 0x75,
+	0x09, 0x83, 0x2c, 0x1a, 0x1b, 0x6e, 0x5a, 0xa0, 0x52, 0x3b, 0xd6, 0xb3, 0x29, 0xe3, 0x2f, 0x84,
+	0x53, 0xd1, 0x00, 0xed, 0x20, 0xfc, 0xb1, 0x5b, 0x6a, 0xcb, 0xbe, 0x39, 0x4a, 0x4c, 0x58, 0xcf,
+	0xd0, 0xef, 0xaa, 0xfb, 0x43, 0x4d, 0x33, 0x85, 0x45, 0xf9, 0x02, 0x7f, 0x50, 0x3c, 0x9f, 0xa8,
+	0x51, 0xa3, 0x40, 0x8f, 0x92, 0x9d, 0x38, 0xf5, 0xbc, 0xb6, 0xda, 0x21, 0x10, 0xff, 0xf3, 0xd2,
+	0xcd, 0x0c, 0x13, 0xec, 0x5f, 0x97, 0x44, 0x17, 0xc4, 0xa7, 0x7e, 0x3d, 0x64, 0x5d, 0x19, 0x73,
+	0x60, 0x81, 0x4f, 0xdc, 0x22, 0x2a, 0x90, 0x88, 0x46, 0xee, 0xb8, 0x14, 0xde, 0x5e, 0x0b, 0xdb,
+	0xe0, 0x32, 0x3a, 0x0a, 0x49, 0x06, 0x24, 0x5c, 0xc2, 0xd3, 0xac, 0x62, 0x91, 0x95, 0xe4, 0x79,
+	0xe7, 0xc8, 0x37, 0x6d, 0x8d, 0xd5, 0x4e, 0xa9, 0x6c, 0x56, 0xf4, 0xea, 0x65, 0x7a, 0xae, 0x08,
+	0xba, 0x78, 0x25, 0x2e, 0x1c, 0xa6, 0xb4, 0xc6, 0xe8, 0xdd, 0x74, 0x1f, 0x4b, 0xbd, 0x8b, 0x8a,
+	0x70, 0x3e, 0xb5, 0x66, 0x48, 0x03, 0xf6, 0x0e, 0x61, 0x35, 0x57, 0xb9, 0x86, 0xc1, 0x1d, 0x9e,
+	0xe1, 0xf8, 0x98, 0x11, 0x69, 0xd9, 0x8e, 0x94, 0x9b, 0x1e, 0x87, 0xe9, 0xce, 0x55, 0x28, 0xdf,
+	0x8c, 0xa1, 0x89, 0x0d, 0xbf, 0xe6, 0x42, 0x68, 0x41, 0x99, 0x2d, 0x0f, 0xb0, 0x54, 0xbb, 0x16,
+}
+
+var aesInvSbox [256]uint8
+
+func init() {
+	for i, v := range aesSbox {
+		aesInvSbox[v] = uint8(i)
+	}
+}
+
+// multiplication in GF(2^8) modulo aes polynomial
+func gfMul(a uint8, b uint8) uint8 {
+	var p uint8
+	for b != 0 {
+		if b&1 == 1 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return p
+}
+
+func aesMixColumnByteFwd(so uint8) uint32 {
+	return uint32(gfMul(so, 3))<<24 | uint32(so)<<16 | uint32(so)<<8 | uint32(gfMul(so, 2))
+}
+
+func aesMixColumnByteInv(so uint8) uint32 {
+	return uint32(gfMul(so, 0xb))<<24 | uint32(gfMul(so, 0xd))<<16 | uint32(gfMul(so, 0x9))<<8 | uint32(gfMul(so, 0xe))
+}
+
+// aes32* instructions,bs selects byte of rs2
+func Aes32(funct5 uint32, bs uint32, rs1 uint32, rs2 uint32) uint32 {
+
+	shamt := int(bs * 8)
+	si := uint8(rs2 >> shamt)
+	var mixed uint32
+	switch funct5 {
+	case AES32ESI:
+		mixed = uint32(aesSbox[si])
+	case AES32ESMI:
+		mixed = aesMixColumnByteFwd(aesSbox[si])
+	case AES32DSI:
+		mixed = uint32(aesInvSbox[si])
+	case AES32DSMI:
+		mixed = aesMixColumnByteInv(aesInvSbox[si])
+	}
+	return rs1 ^ bits.RotateLeft32(mixed, shamt)
+}
+
+func Sha256(imm uint32, rs1 uint32) uint32 {
+
+	ror := func(n int) uint32 { return bits.RotateLeft32(rs1, -n) }
+	switch imm {
+	case SHA256SUM0:
+		return ror(2) ^ ror(13) ^ ror(22)
+	case SHA256SUM1:
+		return ror(6) ^ ror(11) ^ ror(25)
+	case SHA256SIG0:
+		return ror(7) ^ ror(18) ^ (rs1 >> 3)
+	case SHA256SIG1:
+		return ror(17) ^ ror(19) ^ (rs1 >> 10)
+	}
+	return 0
+}
+
+// rv32 sha512 instructions,each computes one half of 64 bit function
+func Sha512(funct7 uint32, rs1 uint32, rs2 uint32) uint32 {
+
+	switch funct7 {
+	case SHA512SUM0R:
+		return rs1<<25 ^ rs1<<30 ^ rs1>>28 ^ rs2>>7 ^ rs2>>2 ^ rs2<<4
+	case SHA512SUM1R:
+		return rs1<<23 ^ rs1>>14 ^ rs1>>18 ^ rs2>>9 ^ rs2<<18 ^ rs2<<14
+	case SHA512SIG0L:
+		return rs1>>1 ^ rs1>>7 ^ rs1>>8 ^ rs2<<31 ^ rs2<<25 ^ rs2<<24
+	case SHA512SIG0H:
+		return rs1>>1 ^ rs1>>7 ^ rs1>>8 ^ rs2<<31 ^ rs2<<24
+	case SHA512SIG1L:
+		return rs1<<3 ^ rs1>>6 ^ rs1>>19 ^ rs2>>29 ^ rs2<<26 ^ rs2<<13
+	case SHA512SIG1H:
+		return rs1<<3 ^ rs1>>6 ^ rs1>>19 ^ rs2>>29 ^ rs2<<13
+	}
+	return 0
+}
+
+// OP funct3 000 encodings other than ADD/SUB
+// returns false if funct7 is not crypto instruction
+func cryptoR(inst *Instruction) bool {
+
+	switch {
+	case inst.funct7&0b11111 == AES32ESI || inst.funct7&0b11111 == AES32ESMI ||
+		inst.funct7&0b11111 == AES32DSI || inst.funct7&0b11111 == AES32DSMI:
+		inst.wbop.data = uint64(Aes32(inst.funct7&0b11111, inst.funct7>>5, uint32(inst.rs1), uint32(inst.rs2)))
+	case inst.funct7 >= SHA512SUM0R && inst.funct7 <= SHA512SIG1L,
+		inst.funct7 == SHA512SIG0H || inst.funct7 == SHA512SIG1H:
+		inst.wbop.data = uint64(Sha512(inst.funct7, uint32(inst.rs1), uint32(inst.rs2)))
+	default:
+		return false
+	}
+	return true
+}
+
+// deterministic entropy source behind zkr seed csr
+type EntropySource struct {
+	pcg *rand.PCG
+	rng *rand.Rand
+}
+
+func NewEntropySource(seed uint64) *EntropySource {
+	pcg := rand.NewPCG(seed, seed^0x9e3779b97f4a7c15)
+	return &EntropySource{pcg: pcg, rng: rand.New(pcg)}
+}
+
+// source continuing same seed csr sequence
+func (e *EntropySource) Clone() *EntropySource {
+	state, _ := e.pcg.MarshalBinary()
+	c := NewEntropySource(0)
+	c.pcg.UnmarshalBinary(state)
+	return c
+}
+
+// seed csr value,always ES16 with 16 bits of entropy
+func (e *EntropySource) Seed() uint64 {
+	const es16 = 0b10 << 30
+	return es16 | e.rng.Uint64()&0xFFFF
+}
+
+// reseeds entropy source,same seed gives same seed csr sequence
+func (cpu *Cpu) SeedEntropy(seed uint64) {
+	cpu.Entropy = NewEntropySource(seed)
+}
+
+func (cpu *Cpu) entropy() *EntropySource {
+	if cpu.Entropy == nil {
+		cpu.Entropy = NewEntropySource(0)
+	}
+	return cpu.Entropy
+}
@@ -0,0 +1,314 @@
+package cpu
+
+import (
+	"Go_emu/src/register"
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+	"math/rand/v2"
+	"testing"
+)
+
+// aes-128 key schedule,words are little endian like rv32 loads
+func aesExpandKey(key []byte) [44]uint32 {
+
+	var rk [44]uint32
+	for i := 0; i < 4; i++ {
+		rk[i] = binary.LittleEndian.Uint32(key[i*4:])
+	}
+	rcon := uint32(1)
+	for i := 4; i < 44; i++ {
+		t := rk[i-1]
+		if i%4 == 0 {
+			t = bits.RotateLeft32(t, -8)
+			t = Aes32(AES32ESI, 0, 0, t) ^ Aes32(AES32ESI, 1, 0, t) ^
+				Aes32(AES32ESI, 2, 0, t) ^ Aes32(AES32ESI, 3, 0, t)
+			t ^= rcon
+			rcon = uint32(gfMul(uint8(rcon), 2))
+		}
+		rk[i] = rk[i-4] ^ t
+	}
+	return rk
+}
+
+// aes-128 encryption built only from aes32esmi/aes32esi
+func aesEncrypt(rk [44]uint32, plain []byte) [4]uint32 {
+
+	var state [4]uint32
+	for i := range state {
+		state[i] = binary.LittleEndian.Uint32(plain[i*4:]) ^ rk[i]
+	}
+	for round := 1; round <= 10; round++ {
+		funct := uint32(AES32ESMI)
+		if round == 10 {
+			funct = AES32ESI
+		}
+		var next [4]uint32
+		for c := 0; c < 4; c++ {
+			next[c] = rk[round*4+c]
+			for bs := 0; bs < 4; bs++ {
+				next[c] = Aes32(funct, uint32(bs), next[c], state[(c+bs)%4])
+			}
+		}
+		state = next
+	}
+	return state
+}
+
+// equivalent inverse cipher from aes32dsmi/aes32dsi
+// middle round keys need InvMixColumns
+func aesDecrypt(rk [44]uint32, state [4]uint32) [4]uint32 {
+
+	invMix := func(w uint32) uint32 {
+		var r uint32
+		for bs := uint32(0); bs < 4; bs++ {
+			r = Aes32(AES32DSMI, bs, r, Aes32(AES32ESI, bs, 0, w))
+		}
+		return r
+	}
+	for i := range state {
+		state[i] ^= rk[40+i]
+	}
+	for round := 9; round >= 0; round-- {
+		funct := uint32(AES32DSMI)
+		if round == 0 {
+			funct = AES32DSI
+		}
+		var next [4]uint32
+		for c := 0; c < 4; c++ {
+			next[c] = rk[round*4+c]
+			if round != 0 {
+				next[c] = invMix(next[c])
+			}
+			for bs := 0; bs < 4; bs++ {
+				next[c] = Aes32(funct, uint32(bs), next[c], state[(c-bs+4)%4])
+			}
+		}
+		state = next
+	}
+	return state
+}
+
+func TestAes32(t *testing.T) {
+
+	//FIPS-197 appendix B
+	key := []byte{0x2b, 0x7e, 0x15, 0x16, 0x28, 0xae, 0xd2, 0xa6, 0xab, 0xf7, 0x15, 0x88, 0x09, 0xcf, 0x4f, 0x3c}
+	plain := []byte{0x32, 0x43, 0xf6, 0xa8, 0x88, 0x5a, 0x30, 0x8d, 0x31, 0x31, 0x98, 0xa2, 0xe0, 0x37, 0x07, 0x34}
+	expected := []byte{0x39, 0x25, 0x84, 0x1d, 0x02, 0xdc, 0x09, 0xfb, 0xdc, 0x11, 0x85, 0x97, 0x19, 0x6a, 0x0b, 0x32}
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	for n := 0; n < 17; n++ {
+
+		//then random blocks against standard library
+		if n > 0 {
+			for i := range key {
+				key[i] = byte(rng.Uint32())
+				plain[i] = byte(rng.Uint32())
+			}
+			block, _ := aes.NewCipher(key)
+			block.Encrypt(expected, plain)
+		}
+
+		rk := aesExpandKey(key)
+		state := aesEncrypt(rk, plain)
+		for i, v := range state {
+			if v != binary.LittleEndian.Uint32(expected[i*4:]) {
+				t.Errorf("\"TestAes32()\" FAILED, expected -> %x, got -> %08x", expected, state)
+				return
+			}
+		}
+		state = aesDecrypt(rk, state)
+		for i, v := range state {
+			if v != binary.LittleEndian.Uint32(plain[i*4:]) {
+				t.Errorf("\"TestAes32()\" decryption FAILED, expected -> %x, got -> %08x", plain, state)
+				return
+			}
+		}
+	}
+
+}
+
+func TestSha256(t *testing.T) {
+
+	k := [64]uint32{
+		0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1, 0x923f82a4, 0xab1c5ed5,
+		0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3, 0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174,
+		0xe49b69c1, 0xefbe4786, 0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+		0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147, 0x06ca6351, 0x14292967,
+		0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13, 0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85,
+		0xa2bfe8a1, 0xa81a664b, 0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+		0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a, 0x5b9cca4f, 0x682e6ff3,
+		0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208, 0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2,
+	}
+	h := [8]uint32{0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a, 0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19}
+
+	//single padded block of "abc"
+	block := make([]byte, 64)
+	copy(block, "abc")
+	block[3] = 0x80
+	block[63] = 24
+
+	var w [64]uint32
+	for i := 0; i < 16; i++ {
+		w[i] = binary.BigEndian.Uint32(block[i*4:])
+	}
+	for i := 16; i < 64; i++ {
+		w[i] = Sha256(SHA256SIG1, w[i-2]) + w[i-7] + Sha256(SHA256SIG0, w[i-15]) + w[i-16]
+	}
+	a, b, c, d, e, f, g, hh := h[0], h[1], h[2], h[3], h[4], h[5], h[6], h[7]
+	for i := 0; i < 64; i++ {
+		t1 := hh + Sha256(SHA256SUM1, e) + (e&f ^ ^e&g) + k[i] + w[i]
+		t2 := Sha256(SHA256SUM0, a) + (a&b ^ a&c ^ b&c)
+		a, b, c, d, e, f, g, hh = t1+t2, a, b, c, d+t1, e, f, g
+	}
+	h[0], h[1], h[2], h[3] = h[0]+a, h[1]+b, h[2]+c, h[3]+d
+	h[4], h[5], h[6], h[7] = h[4]+e, h[5]+f, h[6]+g, h[7]+hh
+
+	expected := sha256.Sum256([]byte("abc"))
+	for i, v := range h {
+		if v != binary.BigEndian.Uint32(expected[i*4:]) {
+			t.Errorf("\"TestSha256()\" FAILED, expected -> %x, got -> %x", expected, h)
+			return
+		}
+	}
+
+}
+
+func TestSha512(t *testing.T) {
+
+	sum0 := func(x uint64) uint64 {
+		return bits.RotateLeft64(x, -28) ^ bits.RotateLeft64(x, -34) ^ bits.RotateLeft64(x, -39)
+	}
+	sum1 := func(x uint64) uint64 {
+		return bits.RotateLeft64(x, -14) ^ bits.RotateLeft64(x, -18) ^ bits.RotateLeft64(x, -41)
+	}
+	sig0 := func(x uint64) uint64 {
+		return bits.RotateLeft64(x, -1) ^ bits.RotateLeft64(x, -8) ^ x>>7
+	}
+	sig1 := func(x uint64) uint64 {
+		return bits.RotateLeft64(x, -19) ^ bits.RotateLeft64(x, -61) ^ x>>6
+	}
+
+	rng := rand.New(rand.NewPCG(3, 4))
+	for n := 0; n < 1000; n++ {
+		x := rng.Uint64()
+		hi, lo := uint32(x>>32), uint32(x)
+		join := func(h uint32, l uint32) uint64 { return uint64(h)<<32 | uint64(l) }
+
+		if join(Sha512(SHA512SUM0R, hi, lo), Sha512(SHA512SUM0R, lo, hi)) != sum0(x) ||
+			join(Sha512(SHA512SUM1R, hi, lo), Sha512(SHA512SUM1R, lo, hi)) != sum1(x) ||
+			join(Sha512(SHA512SIG0H, hi, lo), Sha512(SHA512SIG0L, lo, hi)) != sig0(x) ||
+			join(Sha512(SHA512SIG1H, hi, lo), Sha512(SHA512SIG1L, lo, hi)) != sig1(x) {
+			t.Errorf("\"TestSha512()\" FAILED for %016x", x)
+			return
+		}
+	}
+
+}
+
+func TestCryptoRomExecution(t *testing.T) {
+
+	var program = []uint32{
+		0x03000e13, //addi t3, x0, 0x30
+		0x305e1073, //csrrw x0, mtvec, t3
+		0x3243f537, //lui a0, 0x3243f
+		0x6a850513, //addi a0, a0, 0x6a8
+		0x123455b7, //lui a1, 0x12345
+		0x66a58633, //aes32esmi a2, a1, a0, 1
+		0xeaa586b3, //aes32dsi a3, a1, a0, 3
+		0x10251713, //sha256sig0 a4, a0
+		0x52b507b3, //sha512sum1r a5, a0, a1
+		0x015012f3, //csrrw t0, seed, x0
+		0x01501373, //csrrw t1, seed, x0
+		0x015023f3, //csrrs t2, seed, x0
+		0x34202ef3, //csrrs t4, mcause, x0
+	}
+
+	run := func(seed uint64) *Cpu {
+		regFile := register.RegisterFile{}
+		for i := 0; i < 32; i++ {
+			regFile.SetRegVal(uint32(i), uint64(i))
+		}
+		cpu := &Cpu{
+			regFile: regFile,
+		}
+		cpu.SeedEntropy(seed)
+		for i, v := range program {
+			cpu.Ram.SetLine(uint32(i*4), v)
+		}
+		for i := 0; i < 100; i++ {
+			cpu.ClockCycle()
+		}
+		return cpu
+	}
+
+	//a0 = 0x3243f6a8,a1 = 0x12345000.
+	//esmi: sbox(0xf6) = 0x42,mixcolumn {c6 42 42 84} rotated to byte 1
+	//dsi: inverse sbox(0x32) = 0xa1 in byte 3
+	//sig0 and sum1r: FIPS 180-4 4.1.2 and 4.1.3 functions of 0x3243f6a8 and 0x3243f6a8_12345000
+	cpu := run(42)
+	expected := map[uint32]uint64{12: 0x5076d4c6, 13: 0xb3345000, 14: 0xab86f5a8, 15: 0x0009dfb7}
+	for reg, v := range expected {
+		if cpu.regFile.GetRegVal(reg) != v {
+			t.Errorf("\"TestCryptoRomExecution()\" FAILED x%d, expected -> %08x, got -> %08x", reg, v, cpu.regFile.GetRegVal(reg))
+		}
+	}
+
+	//seed is ES16 and reproducible,read only access traps
+	t0, t1 := cpu.regFile.GetRegVal(5), cpu.regFile.GetRegVal(6)
+	again := run(42)
+	if t0>>30 != 0b10 || t1>>30 != 0b10 || t0 == t1 ||
+		again.regFile.GetRegVal(5) != t0 || again.regFile.GetRegVal(6) != t1 ||
+		cpu.regFile.GetRegVal(7) != 7 || cpu.regFile.GetRegVal(29) != CAUSE_ILLEGAL_INST {
+		t.Errorf("\"TestCryptoRomExecution()\" seed FAILED, t0 -> %x t1 -> %x t2 -> %x mcause -> %d",
+			t0, t1, cpu.regFile.GetRegVal(7), cpu.regFile.GetRegVal(29))
+	}
+
+}
+
+func TestCryptoIllegal(t *testing.T) {
+
+	var program = map[uint32]uint32{
+		0x00: 0x04000293, //addi t0, x0, 0x40
+		0x04: 0x30529073, //csrrw x0, mtvec, t0
+		0x08: 0x20000913, //addi s2, x0, 0x200
+		0x0c: 0x06b50633, //funct7 3 of add
+		0x10: 0x10451713, //sha256 reserved imm 0x104
+		0x14: 0x66a58633, //aes32esmi a2, a1, a0, 1
+		0x18: 0x52b507b3, //sha512sum1r a5, a0, a1
+		0x1c: 0x3243f537, //lui a0, 0x3243f
+		0x20: 0x10251713, //sha256sig0 a4, a0 -> 0xfa2cf570 sign extended
+		0x24: 0x06300993, //addi s3, x0, 99
+		0x40: 0x343023f3, //csrrs t2, mtval, x0
+		0x44: 0x00792023, //sw t2, 0(s2)
+		0x48: 0x00490913, //addi s2, s2, 4
+		0x4c: 0x34102473, //csrrs s0, mepc, x0
+		0x50: 0x00440413, //addi s0, s0, 4
+		0x54: 0x34141073, //csrrw x0, mepc, s0
+		0x58: 0x30200073, //mret
+	}
+
+	//aes32 and sha512 ones exist only in rv32
+	for xlen, trapped := range map[uint32][]uint32{
+		32: {0x06b50633, 0x10451713, 0},
+		64: {0x06b50633, 0x10451713, 0x66a58633, 0x52b507b3, 0},
+	} {
+		cpu := Cpu{Xlen: xlen}
+		for address, v := range program {
+			cpu.Ram.SetLine(address, v)
+		}
+		for i := 0; i < 1000; i++ {
+			cpu.ClockCycle()
+		}
+		for i, v := range trapped {
+			if got := cpu.Ram.GetLine(0x200 + uint32(i*4)); got != v {
+				t.Errorf("\"TestCryptoIllegal()\" FAILED rv%d, trap %d mtval -> %08x expected %08x", xlen, i, got, v)
+			}
+		}
+		if cpu.regFile.GetRegVal(19) != 99 || cpu.regFile.GetRegVal(14) != cpu.trunc(0xffff_ffff_fa2c_f570) {
+			t.Errorf("\"TestCryptoIllegal()\" FAILED rv%d, s3 -> %d a4 -> %x", xlen, cpu.regFile.GetRegVal(19), cpu.regFile.GetRegVal(14))
+		}
+	}
+
+}
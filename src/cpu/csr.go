@@ -0,0 +1,122 @@
+package cpu
+
+const SYSTEM = 0b1110011
+
+// csr addresses
+const (
+	CSR_SEED   = 0x015
+	CSR_VSTART = 0x008
+	CSR_TIME   = 0xC01 //provided by platform timer
+	CSR_VL     = 0xC20
+	CSR_VTYPE  = 0xC21
+	CSR_VLENB  = 0xC22
+)
+
+// read/write hooks of single control and status register
+// Write is nil for read only registers
+type Csr struct {
+	Read  func() uint64
+	Write func(val uint64)
+	Input string //source name in input log,value comes from outside hart
+}
+
+func (cpu *Cpu) readCsr(csr *Csr) uint64 {
+	if csr.Input != "" {
+		return cpu.Observe(csr.Input, csr.Read)
+	}
+	return csr.Read()
+}
+
+// adds or replaces csr at address
+func (cpu *Cpu) RegisterCsr(address uint32, csr Csr) {
+	cpu.csrFile()[address] = &csr
+}
+
+func (cpu *Cpu) csrFile() map[uint32]*Csr {
+
+	if cpu.csrs != nil {
+		return cpu.csrs
+	}
+	cpu.csrs = map[uint32]*Csr{}
+
+	//zkr entropy source,only read-write access is legal
+	cpu.csrs[CSR_SEED] = &Csr{
+		Read:  func() uint64 { return cpu.entropy().Seed() },
+		Write: func(uint64) {},
+		Input: "seed",
+	}
+
+	cpu.registerTrapCsrs()
+	cpu.registerPmpCsrs()
+
+	if cpu.Vector != nil {
+		v := cpu.Vector
+		cpu.csrs[CSR_VSTART] = &Csr{
+			Read:  func() uint64 { return 0 },
+			Write: func(uint64) {},
+		}
+		cpu.csrs[CSR_VL] = &Csr{Read: func() uint64 { return uint64(v.Vl()) }}
+		cpu.csrs[CSR_VTYPE] = &Csr{Read: func() uint64 {
+			//vill is always most significant bit of XLEN
+			if v.vill() {
+				return 1 << (cpu.xlen() - 1)
+			}
+			return uint64(v.Vtype())
+		}}
+		cpu.csrs[CSR_VLENB] = &Csr{Read: func() uint64 { return uint64(v.Vlenb()) }}
+	}
+
+	return cpu.csrs
+}
+
+// CSRRW,CSRRS,CSRRC and immediate forms
+// returns false for access to missing csr,write to read only one
+// or access above instruction privilege
+func (cpu *Cpu) csrAccess(inst *Instruction) bool {
+
+	csr, ok := cpu.csrFile()[uint32(inst.imm)]
+	if !ok {
+		return false
+	}
+
+	src := inst.rs1
+	//immediate forms use rs1 field as zero extended value
+	if inst.funct3 >= 0x5 {
+		src = uint64(inst.rs1_index)
+	}
+
+	//CSRRS and CSRRC with x0 source only read
+	write := inst.funct3&0b11 == 0b01 || inst.rs1_index != 0
+	//seed may only be accessed with a write
+	if inst.imm == CSR_SEED && !write {
+		return false
+	}
+	if write && csr.Write == nil || !cpu.csrAllowed(uint32(inst.imm), inst.priv, write) {
+		return false
+	}
+
+	old := cpu.readCsr(csr)
+	if write {
+		switch inst.funct3 & 0b11 {
+		//CSRRW
+		case 0b01:
+			csr.Write(cpu.trunc(src))
+		//CSRRS
+		case 0b10:
+			csr.Write(cpu.trunc(old | src))
+		//CSRRC
+		case 0b11:
+			csr.Write(cpu.trunc(old &^ src))
+		}
+		//reading input source again would take next value
+		if csr.Input == "" {
+			inst.csrWrite = &Wbops{dest: uint32(inst.imm), data: csr.Read()}
+		}
+	}
+
+	inst.wbop = &Wbops{
+		dest: inst.rd,
+		data: old,
+	}
+	return true
+}
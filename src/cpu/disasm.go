@@ -0,0 +1,203 @@
+package cpu
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	opMnemonics     = [8]string{"add", "sll", "slt", "sltu", "xor", "srl", "or", "and"}
+	mulDivMnemonics = [8]string{"mul", "mulh", "mulhsu", "mulhu", "div", "divu", "rem", "remu"}
+	opImmMnemonics  = [8]string{"addi", "slli", "slti", "sltiu", "xori", "srli", "ori", "andi"}
+	loadMnemonics   = [8]string{"lb", "lh", "lw", "ld", "lbu", "lhu", "lwu", ""}
+	storeMnemonics  = [8]string{"sb", "sh", "sw", "sd", "", "", "", ""}
+	branchMnemonics = [8]string{"beq", "bne", "", "", "blt", "bge", "bltu", "bgeu"}
+	csrMnemonics    = [8]string{"", "csrrw", "csrrs", "csrrc", "", "csrrwi", "csrrsi", "csrrci"}
+	vecMemMnemonics = [4]string{"e", "uxei", "se", "oxei"}
+	systemMnemonics = map[uint32]string{ECALL: "ecall", EBREAK: "ebreak", SRET: "sret", MRET: "mret", WFI: "wfi"}
+	cryptoMnemonics = map[uint32]string{
+		AES32ESI: "aes32esi", AES32ESMI: "aes32esmi", AES32DSI: "aes32dsi", AES32DSMI: "aes32dsmi",
+		SHA512SUM0R: "sha512sum0r", SHA512SUM1R: "sha512sum1r", SHA512SIG0L: "sha512sig0l",
+		SHA512SIG1L: "sha512sig1l", SHA512SIG0H: "sha512sig0h", SHA512SIG1H: "sha512sig1h",
+	}
+	sha256Mnemonics = map[uint32]string{
+		SHA256SUM0: "sha256sum0", SHA256SUM1: "sha256sum1", SHA256SIG0: "sha256sig0", SHA256SIG1: "sha256sig1",
+	}
+	amoMnemonics = map[uint32]string{
+		AMOADD: "amoadd", AMOSWAP: "amoswap", LR: "lr", SC: "sc", AMOXOR: "amoxor", AMOOR: "amoor",
+		AMOAND: "amoand", AMOMIN: "amomin", AMOMAX: "amomax", AMOMINU: "amominu", AMOMAXU: "amomaxu",
+	}
+)
+
+// assembler name of instruction word,
+// "unknown" for encodings emulator doesn't implement
+func Mnemonic(romline uint32) string {
+
+	opcode := romline & 0b1111111
+	funct3 := SubBits(romline, 12, 14)
+	funct7 := SubBits(romline, 25, 31)
+	name := ""
+	switch opcode {
+	case 0b0110011:
+		switch {
+		case funct7 == 0x01:
+			name = mulDivMnemonics[funct3]
+		case funct7 == 0x20 && funct3 == 0x0:
+			name = "sub"
+		case funct7 == 0x20 && funct3 == 0x5:
+			name = "sra"
+		case funct7 == 0x0:
+			name = opMnemonics[funct3]
+		case funct3 == 0x0 && cryptoMnemonics[funct7] != "":
+			name = cryptoMnemonics[funct7]
+		case funct3 == 0x0:
+			name = cryptoMnemonics[funct7&0b11111]
+		}
+	case OP32:
+		switch {
+		case funct7 == 0x01 && (funct3 == 0x0 || funct3 >= 0x4):
+			name = mulDivMnemonics[funct3] + "w"
+		case funct7 == 0x20 && funct3 == 0x0:
+			name = "subw"
+		case funct7 == 0x20 && funct3 == 0x5:
+			name = "sraw"
+		case funct7 == 0x0 && (funct3 == 0x0 || funct3 == 0x1 || funct3 == 0x5):
+			name = opMnemonics[funct3] + "w"
+		}
+	case 0b0010011:
+		name = opImmMnemonics[funct3]
+		imm := SubBits(romline, 20, 31)
+		if funct3 == 0x1 && imm>>5 == 0b0001000 {
+			name = sha256Mnemonics[imm]
+		}
+		if funct3 == 0x5 && funct7>>1 == 0b010000 {
+			name = "srai"
+		}
+	case OPIMM32:
+		switch {
+		case funct3 == 0x0:
+			name = "addiw"
+		case funct3 == 0x1:
+			name = "slliw"
+		case funct3 == 0x5 && funct7 == 0x20:
+			name = "sraiw"
+		case funct3 == 0x5:
+			name = "srliw"
+		}
+	case 0b0000011:
+		name = loadMnemonics[funct3]
+	case 0b0100011:
+		name = storeMnemonics[funct3]
+	case 0b1100011:
+		name = branchMnemonics[funct3]
+	case 0b1101111:
+		name = "jal"
+	case 0b1100111:
+		name = "jalr"
+	case 0b0110111:
+		name = "lui"
+	case 0b0010111:
+		name = "auipc"
+	case MISC_MEM:
+		name = []string{"fence", "fence.i"}[funct3&1]
+	case SYSTEM:
+		name = csrMnemonics[funct3]
+		if funct3 == 0x0 && funct7 == SFENCE_VMA {
+			name = "sfence.vma"
+		} else if funct3 == 0x0 {
+			name = systemMnemonics[SubBits(romline, 20, 31)]
+		}
+	case AMO:
+		if name = amoMnemonics[funct7>>2]; name != "" {
+			name += []string{".w", ".d"}[funct3&1]
+		}
+	case OPV:
+		switch {
+		case funct3 != OPCFG:
+			name = "vop"
+		case romline>>31 == 0:
+			name = "vsetvli"
+		case romline>>30 == 0b11:
+			name = "vsetivli"
+		default:
+			name = "vsetvl"
+		}
+	case LOADV, STOREV:
+		mop := SubBits(romline, 26, 27)
+		name = "vs"
+		if opcode == LOADV {
+			name = "vl"
+		}
+		name += vecMemMnemonics[mop]
+	}
+	if name == "" {
+		return "unknown"
+	}
+	return name
+}
+
+var abiNames = [32]string{
+	"zero", "ra", "sp", "gp", "tp", "t0", "t1", "t2", "s0", "s1", "a0", "a1", "a2", "a3", "a4", "a5",
+	"a6", "a7", "s2", "s3", "s4", "s5", "s6", "s7", "s8", "s9", "s10", "s11", "t3", "t4", "t5", "t6",
+}
+
+// assembler text of instruction word,branch and jump
+// offsets are relative to instruction pc
+func Disassemble(romline uint32) string {
+
+	name := Mnemonic(romline)
+	opcode := romline & 0b1111111
+	funct3 := SubBits(romline, 12, 14)
+	rd := abiNames[SubBits(romline, 7, 11)]
+	rs1 := abiNames[SubBits(romline, 15, 19)]
+	rs2 := abiNames[SubBits(romline, 20, 24)]
+	immI := int64(SignExtend64(uint64(SubBits(romline, 20, 31)), 12))
+	immS := int64(SignExtend64(uint64(SubBits(romline, 25, 31)<<5|SubBits(romline, 7, 11)), 12))
+
+	switch opcode {
+	case 0b0110011, OP32:
+		return fmt.Sprintf("%s %s, %s, %s", name, rd, rs1, rs2)
+	case 0b0010011, OPIMM32:
+		switch {
+		case strings.HasPrefix(name, "sha256"):
+			return fmt.Sprintf("%s %s, %s", name, rd, rs1)
+		case funct3 == 0x1 || funct3 == 0x5:
+			return fmt.Sprintf("%s %s, %s, %d", name, rd, rs1, SubBits(romline, 20, 25))
+		}
+		return fmt.Sprintf("%s %s, %s, %d", name, rd, rs1, immI)
+	case 0b0000011:
+		return fmt.Sprintf("%s %s, %d(%s)", name, rd, immI, rs1)
+	case 0b0100011:
+		return fmt.Sprintf("%s %s, %d(%s)", name, rs2, immS, rs1)
+	case 0b1100011:
+		imm := SubBits(romline, 31, 31)<<12 | SubBits(romline, 7, 7)<<11 |
+			SubBits(romline, 25, 30)<<5 | SubBits(romline, 8, 11)<<1
+		return fmt.Sprintf("%s %s, %s, %d", name, rs1, rs2, int64(SignExtend64(uint64(imm), 13)))
+	case 0b1101111:
+		imm := SubBits(romline, 31, 31)<<20 | SubBits(romline, 12, 19)<<12 |
+			SubBits(romline, 20, 20)<<11 | SubBits(romline, 21, 30)<<1
+		return fmt.Sprintf("%s %s, %d", name, rd, int64(SignExtend64(uint64(imm), 21)))
+	case 0b1100111:
+		return fmt.Sprintf("%s %s, %d(%s)", name, rd, immI, rs1)
+	case 0b0110111, 0b0010111:
+		return fmt.Sprintf("%s %s, %#x", name, rd, SubBits(romline, 12, 31))
+	case SYSTEM:
+		csr := SubBits(romline, 20, 31)
+		switch {
+		case name == "sfence.vma":
+			return fmt.Sprintf("%s %s, %s", name, rs1, rs2)
+		case funct3 >= 0x5:
+			return fmt.Sprintf("%s %s, %#x, %d", name, rd, csr, SubBits(romline, 15, 19))
+		case funct3 != 0x0:
+			return fmt.Sprintf("%s %s, %#x, %s", name, rd, csr, rs1)
+		}
+	case AMO:
+		if strings.HasPrefix(name, "lr") {
+			return fmt.Sprintf("%s %s, (%s)", name, rd, rs1)
+		}
+		if name != "unknown" {
+			return fmt.Sprintf("%s %s, %s, (%s)", name, rd, rs2, rs1)
+		}
+	}
+	return name
+}
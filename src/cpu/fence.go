@@ -0,0 +1,20 @@
+package cpu
+
+const MISC_MEM = 0b0001111
+
+// FENCE,FENCE.I
+func (cpu *Cpu) fence(inst *Instruction) {
+
+	switch inst.funct3 {
+	//FENCE
+	//pipeline is in order with single memory port,
+	//so every memory access is already ordered
+	case 0x0:
+	//FENCE.I
+	//older stores finish memory stage in this cycle,
+	//instructions fetched before them are discarded and
+	//refetched past invalidated instruction cache,see syncFetch
+	case 0x1:
+		inst.redirect = &Redirect{Pc: inst.pc + 4, Priv: inst.priv, Cause: REDIRECT_SERIAL}
+	}
+}
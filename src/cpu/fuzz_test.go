@@ -0,0 +1,238 @@
+package cpu
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// generated code starts at 0,loads and stores stay in data
+// region s0 points to. fetch of zero word after code stops hart
+const (
+	FUZZ_CHUNK     = 4 //input bytes per generated instruction
+	FUZZ_MAX_INSTS = 256
+	FUZZ_DATA_BASE = 0x1000
+	FUZZ_DATA_SIZE = 256
+)
+
+// random but valid rv32i stream,x1-x7 are written and
+// read back soon after so most operands are forwarded
+type fuzzProgram struct {
+	words  []uint32
+	recent []uint32 //last destination registers,youngest last
+}
+
+func rType(funct7 uint32, rs2 uint32, rs1 uint32, funct3 uint32, rd uint32, opcode uint32) uint32 {
+	return funct7<<25 | rs2<<20 | rs1<<15 | funct3<<12 | rd<<7 | opcode
+}
+
+func iType(imm uint32, rs1 uint32, funct3 uint32, rd uint32, opcode uint32) uint32 {
+	return (imm&0xFFF)<<20 | rs1<<15 | funct3<<12 | rd<<7 | opcode
+}
+
+func sType(imm uint32, rs2 uint32, rs1 uint32, funct3 uint32) uint32 {
+	return (imm>>5&0x7F)<<25 | rs2<<20 | rs1<<15 | funct3<<12 | (imm&0x1F)<<7 | 0b0100011
+}
+
+func bType(imm uint32, rs2 uint32, rs1 uint32, funct3 uint32) uint32 {
+	return (imm>>12&1)<<31 | (imm>>5&0x3F)<<25 | rs2<<20 | rs1<<15 | funct3<<12 |
+		(imm>>1&0xF)<<8 | (imm>>11&1)<<7 | 0b1100011
+}
+
+func jType(imm uint32, rd uint32) uint32 {
+	return (imm>>20&1)<<31 | (imm>>1&0x3FF)<<21 | (imm>>11&1)<<20 | (imm>>12&0xFF)<<12 | rd<<7 | 0b1101111
+}
+
+// destination from x1-x7
+func (p *fuzzProgram) dest(b byte) uint32 {
+	rd := uint32(b%7) + 1
+	p.recent = append(p.recent, rd)
+	if len(p.recent) > 3 {
+		p.recent = p.recent[1:]
+	}
+	return rd
+}
+
+// source mostly one of last destinations,
+// otherwise any of x0-x7
+func (p *fuzzProgram) source(b byte) uint32 {
+	if b&0b11 != 0 && len(p.recent) > 0 {
+		return p.recent[len(p.recent)-1-int(b>>2)%len(p.recent)]
+	}
+	return uint32(b>>2) % 8
+}
+
+// one instruction from chunk,load use pair is two
+func (p *fuzzProgram) add(c []byte) {
+
+	kind, a, b, d := c[0], c[1], c[2], c[3]
+	funct3 := uint32(b) % 8
+	switch kind % 16 {
+	//register-register alu
+	case 0, 1, 2:
+		funct7 := uint32(0)
+		if funct3 == 0 || funct3 == 5 {
+			funct7 = uint32(d&1) * 0x20
+		}
+		rs1, rs2 := p.source(a), p.source(d>>1)
+		p.words = append(p.words, rType(funct7, rs2, rs1, funct3, p.dest(b>>3), 0b0110011))
+	//register-immediate alu,shift amount in 5 bits
+	case 3, 4, 5:
+		imm := uint32(d) | uint32(a&0xF)<<8
+		if funct3 == 1 || funct3 == 5 {
+			imm = imm&0x1F | uint32(a&0x10)<<6
+			if funct3 == 1 {
+				imm &= 0x1F
+			}
+		}
+		rs1 := p.source(a >> 4)
+		p.words = append(p.words, iType(imm, rs1, funct3, p.dest(b>>3), 0b0010011))
+	case 6:
+		opcode := []uint32{0b0110111, 0b0010111}[a&1]
+		p.words = append(p.words, uint32(b)<<24|uint32(d)<<12|p.dest(a>>1)<<7|opcode)
+	//load,aligned to its size
+	case 7, 8:
+		funct3 = []uint32{0, 1, 2, 4, 5}[b%5]
+		offset := uint32(a) % FUZZ_DATA_SIZE &^ (1<<(funct3&3) - 1)
+		p.words = append(p.words, iType(offset, 8, funct3, p.dest(d), 0b0000011))
+	//load followed by its use
+	case 9, 10:
+		offset := uint32(a) % FUZZ_DATA_SIZE &^ 3
+		rd := p.dest(d)
+		p.words = append(p.words, iType(offset, 8, 2, rd, 0b0000011))
+		p.words = append(p.words, rType(0, rd, p.source(b), uint32(d>>4)%8&^0b010, p.dest(b>>2), 0b0110011))
+	case 11, 12:
+		funct3 %= 3
+		offset := uint32(a) % FUZZ_DATA_SIZE &^ (1<<funct3 - 1)
+		p.words = append(p.words, sType(offset, p.source(d), 8, funct3))
+	//forward branch over up to three instructions
+	case 13, 14:
+		funct3 = []uint32{0, 1, 4, 5, 6, 7}[b%6]
+		p.words = append(p.words, bType(4*(uint32(d%4)+1), p.source(d>>2), p.source(a), funct3))
+	case 15:
+		p.words = append(p.words, jType(4*(uint32(b%4)+1), p.dest(a)))
+	}
+}
+
+// s0 holds data base,data region gets input bytes
+func fuzzGenerate(data []byte) []uint32 {
+
+	p := &fuzzProgram{words: []uint32{0x00001437}} //lui s0, 0x1
+	for i := 1; i+FUZZ_CHUNK <= len(data) && len(p.words) < FUZZ_MAX_INSTS; i += FUZZ_CHUNK {
+		p.add(data[i : i+FUZZ_CHUNK])
+	}
+	return p.words
+}
+
+func fuzzCores() []string {
+	names := []string{"ooo"}
+	for name := range Pipelines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runs program on core selected by first byte next to golden model,
+// returns first difference
+func fuzzRun(data []byte) error {
+
+	if len(data) == 0 {
+		return nil
+	}
+	names := fuzzCores()
+	var core Core = &Cpu{}
+	switch name := names[int(data[0])%len(names)]; name {
+	case "ooo":
+		core = NewOutOfOrder(&Cpu{}, DEFAULT_OOO)
+	default:
+		core.Arch().SetPipeline(Pipelines[name])
+	}
+	cpu := core.Arch()
+	words := fuzzGenerate(data)
+	for i, w := range words {
+		cpu.Ram.SetLine(uint32(i*4), w)
+	}
+	for i := uint32(0); i < FUZZ_DATA_SIZE; i++ {
+		cpu.Ram.Write(FUZZ_DATA_BASE+i, 1, uint64(data[int(i)%len(data)]))
+	}
+
+	l, err := NewLockstep(cpu)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < 20*len(words)+100 && l.Err() == nil; i++ {
+		core.ClockCycle()
+	}
+	if l.Err() != nil {
+		return l.Err()
+	}
+	//code ran to its end on both
+	g, err := l.Golden.Step()
+	if err != nil {
+		return err
+	}
+	if g != nil {
+		return fmt.Errorf("core stopped before %#x %s golden model retired", g.pc, Disassemble(g.romline))
+	}
+	return cpu.ArchDiff(l.Golden)
+}
+
+// drops instruction chunks while program still fails
+func fuzzMinimize(data []byte) []byte {
+
+	for changed := true; changed; {
+		changed = false
+		for i := len(data) - FUZZ_CHUNK; i >= 1; i -= FUZZ_CHUNK {
+			shorter := append(append([]byte(nil), data[:i]...), data[i+FUZZ_CHUNK:]...)
+			if fuzzRun(shorter) != nil {
+				data = shorter
+				changed = true
+			}
+		}
+	}
+	return data
+}
+
+// reproducer in go fuzz corpus format,
+// go test replays it with seed corpus
+func fuzzSave(data []byte) (string, error) {
+	dir := filepath.Join("testdata", "fuzz", "FuzzPipeline")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("minimized-%x", sha256.Sum256(data))[:len("minimized-")+16])
+	return path, os.WriteFile(path, []byte(fmt.Sprintf("go test fuzz v1\n[]byte(%q)\n", data)), 0644)
+}
+
+func FuzzPipeline(f *testing.F) {
+
+	f.Add([]byte{0, 3, 0x11, 0x20, 0x05, 9, 0x40, 0x0b, 0x12, 13, 0x01, 0x02, 0x03, 0, 0x09, 0x0a, 0x0b})
+	for core := range fuzzCores() {
+		seed := []byte{byte(core)}
+		for i := 0; i < 64*FUZZ_CHUNK; i++ {
+			seed = append(seed, byte(i*37+core*11))
+		}
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		err := fuzzRun(data)
+		if err == nil {
+			return
+		}
+		small := fuzzMinimize(data)
+		path, saveErr := fuzzSave(small)
+		listing := strings.Builder{}
+		for i, w := range fuzzGenerate(small) {
+			fmt.Fprintf(&listing, "  %04x: %08x %s\n", i*4, w, Disassemble(w))
+		}
+		t.Fatalf("\"FuzzPipeline()\" FAILED on %s, %v\nreproducer %s %v\n%s",
+			fuzzCores()[int(small[0])%len(fuzzCores())], fuzzRun(small), path, saveErr, listing.String())
+	})
+
+}
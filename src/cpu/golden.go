@@ -0,0 +1,523 @@
+package cpu
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// opcodes core execute matches by value
+const (
+	LOAD_OP   = 0b0000011
+	OPIMM     = 0b0010011
+	AUIPC     = 0b0010111
+	STORE_OP  = 0b0100011
+	OP        = 0b0110011
+	LUI       = 0b0110111
+	BRANCH_OP = 0b1100011
+)
+
+// functional model lockstep checks cores against. only decode is
+// shared with pipelines,every instruction is executed and committed
+// again here so bug in execute,memory or write back of core can't
+// hide in golden model. csr registry,address translation and pmp
+// are architectural state both use. returns error for instruction
+// golden model does not implement (crypto,vector),trapped instruction
+// is returned after trap was taken and nil means no instruction at pc
+func (cpu *Cpu) Step() (*Instruction, error) {
+
+	cpu.powerOn()
+	cpu.Stats.Cycles++
+	inst := &Instruction{stage: IF, pc: cpu.pc, priv: cpu.priv}
+	if pa, _, fault := cpu.translate(cpu.pc, 4, ACCESS_FETCH, cpu.priv); fault != nil {
+		inst.trap = fault
+	} else if inst.romline = uint32(cpu.Ram.Read(uint32(pa), 4)); inst.romline == 0 {
+		return nil, nil
+	}
+
+	if inst.trap == nil {
+		ch := make(chan *Instruction, 1)
+		cpu.decodeInst(inst, ch)
+		<-ch
+	}
+	if irq, ok := cpu.pendingInterrupt(inst.priv); ok && inst.trap == nil {
+		inst.trap = &Trap{cause: 1<<(cpu.xlen()-1) | irq}
+	}
+	if inst.trap == nil {
+		if err := cpu.goldenExecute(inst); err != nil {
+			return inst, err
+		}
+	}
+	if inst.trap != nil {
+		cpu.goldenTrap(inst)
+		return inst, nil
+	}
+
+	if inst.wbop != nil {
+		cpu.regFile.SetRegVal(inst.wbop.dest, cpu.trunc(inst.wbop.data))
+	}
+	inst.stage = WB
+	cpu.Stats.Retired++
+	cpu.pc = cpu.trunc(inst.nextPc())
+	if r := inst.redirect; r != nil {
+		cpu.priv = r.Priv
+		cpu.pc = r.Pc
+	}
+	return inst, nil
+}
+
+// result of decoded instruction,memory is accessed directly
+func (cpu *Cpu) goldenExecute(inst *Instruction) error {
+
+	illegal := func() { inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline)) }
+	result := func(v uint64) { inst.wbop = &Wbops{dest: inst.rd, data: v} }
+	imm := cpu.trunc(SignExtend64(inst.imm, 12))
+	shmask := uint64(cpu.xlen() - 1)
+
+	if cpu.goldenCrypto(inst) {
+		return fmt.Errorf("golden model does not implement %s", Disassemble(inst.romline))
+	}
+	switch inst.opcode {
+	case LUI:
+		result(SignExtend64(inst.imm<<12, 32))
+	case AUIPC:
+		result(inst.pc + SignExtend64(inst.imm<<12, 32))
+	case JAL:
+		result(inst.pc + 4)
+		inst.taken = true
+		inst.target = cpu.trunc(inst.pc + SignExtend64(inst.imm, 21))
+	case JALR:
+		result(inst.pc + 4)
+		inst.taken = true
+		inst.target = cpu.trunc(inst.rs1+imm) &^ 1
+	case BRANCH_OP:
+		a, b := inst.rs1, inst.rs2
+		sa, sb := cpu.signed(a), cpu.signed(b)
+		taken := map[uint32]bool{0x0: a == b, 0x1: a != b, 0x4: sa < sb, 0x5: sa >= sb, 0x6: a < b, 0x7: a >= b}
+		t, ok := taken[inst.funct3]
+		if !ok {
+			illegal()
+			break
+		}
+		inst.taken = t
+		inst.target = cpu.trunc(inst.pc + SignExtend64(inst.imm, 13))
+	case LOAD_OP:
+		if inst.funct3 == 0x7 {
+			illegal()
+			break
+		}
+		size := uint32(1) << (inst.funct3 & 0b11)
+		pa, ok := cpu.goldenAddress(inst, cpu.trunc(inst.rs1+imm), size, ACCESS_LOAD)
+		if !ok {
+			break
+		}
+		data := cpu.Ram.Read(uint32(pa), size)
+		if inst.funct3 < 0x4 {
+			data = SignExtend64(data, uint8(size*8))
+		}
+		inst.memop = &Memops{optype: LOAD, address: pa}
+		result(data)
+	case STORE_OP:
+		if inst.funct3 > 0x3 {
+			illegal()
+			break
+		}
+		size := uint32(1) << inst.funct3
+		pa, ok := cpu.goldenAddress(inst, cpu.trunc(inst.rs1+SignExtend64(inst.imm, 12)), size, ACCESS_STORE)
+		if !ok {
+			break
+		}
+		data := inst.rs2 & (^uint64(0) >> (64 - size*8))
+		inst.memop = &Memops{optype: STORE, address: pa, data: data}
+		cpu.Ram.Write(uint32(pa), size, data)
+	case OPIMM, OPIMM32:
+		//shift immediates carry funct6/funct7 above shamt
+		upper := inst.imm >> 5 &^ (shmask >> 5)
+		if inst.opcode == OPIMM32 {
+			upper = inst.imm >> 5
+		}
+		alt := upper == 0b0100000
+		shift := inst.funct3 == 0x1 || inst.funct3 == 0x5
+		if shift && upper != 0 && !(inst.funct3 == 0x5 && alt) ||
+			inst.opcode == OPIMM32 && !shift && inst.funct3 != 0x0 {
+			illegal()
+			break
+		}
+		cpu.goldenAlu(inst, imm, inst.imm, alt, inst.opcode == OPIMM32)
+	case OP, OP32:
+		word := inst.opcode == OP32
+		switch inst.funct7 {
+		case 0x0, 0x20:
+			alt := inst.funct7 == 0x20
+			if alt && inst.funct3 != 0x0 && inst.funct3 != 0x5 ||
+				word && inst.funct3 != 0x0 && inst.funct3 != 0x1 && inst.funct3 != 0x5 {
+				illegal()
+				break
+			}
+			cpu.goldenAlu(inst, inst.rs2, inst.rs2, alt, word)
+		case 0x01:
+			//no high half word multiplies
+			if word && inst.funct3 > 0x0 && inst.funct3 < 0x4 {
+				illegal()
+				break
+			}
+			result(cpu.goldenMulDiv(inst))
+		default:
+			illegal()
+		}
+	case AMO:
+		cpu.goldenAtomic(inst)
+	case MISC_MEM:
+		//FENCE,FENCE.I,memory is accessed in program order
+		if inst.funct3 > 0x1 {
+			illegal()
+		}
+	case SYSTEM:
+		cpu.goldenSystem(inst)
+	default:
+		return fmt.Errorf("golden model does not implement %s", Disassemble(inst.romline))
+	}
+	return nil
+}
+
+// zkne/zknd/zknh encodings,left to core
+func (cpu *Cpu) goldenCrypto(inst *Instruction) bool {
+
+	switch {
+	case inst.opcode == OPIMM && inst.funct3 == 0x1:
+		return inst.imm >= SHA256SUM0 && inst.imm <= SHA256SIG1
+	case inst.opcode == OP && inst.funct3 == 0x0 && cpu.xlen() == 32:
+		aes := inst.funct7 & 0b11111
+		return aes == AES32ESI || aes == AES32ESMI || aes == AES32DSI || aes == AES32DSMI ||
+			inst.funct7 >= SHA512SUM0R && inst.funct7 <= SHA512SIG1L ||
+			inst.funct7 == SHA512SIG0H || inst.funct7 == SHA512SIG1H
+	}
+	return false
+}
+
+// physical address of data access,raises fault
+func (cpu *Cpu) goldenAddress(inst *Instruction, va uint64, size uint32, access AccessType) (uint64, bool) {
+	priv := inst.priv
+	if priv == PRIV_M && cpu.traps.mstatus&MSTATUS_MPRV != 0 {
+		priv = Privilege(cpu.traps.mstatus & MSTATUS_MPP >> 11)
+	}
+	pa, _, fault := cpu.translate(va, size, access, priv)
+	if fault != nil {
+		inst.raise(fault.cause, fault.tval)
+		return 0, false
+	}
+	return pa, true
+}
+
+// OP,OP-IMM and word forms,b is rs2 or immediate
+func (cpu *Cpu) goldenAlu(inst *Instruction, b uint64, shamt uint64, alt bool, word bool) {
+
+	a := inst.rs1
+	if word {
+		shamt &= 0x1F
+	} else {
+		shamt &= uint64(cpu.xlen() - 1)
+	}
+	var res uint64
+	switch inst.funct3 {
+	case 0x0:
+		res = a + b
+		if alt && (inst.opcode == OP || inst.opcode == OP32) {
+			res = a - b
+		}
+	case 0x1:
+		res = a << shamt
+	case 0x2:
+		if cpu.signed(a) < cpu.signed(b) {
+			res = 1
+		}
+	case 0x3:
+		if cpu.trunc(a) < cpu.trunc(b) {
+			res = 1
+		}
+	case 0x4:
+		res = a ^ b
+	case 0x5:
+		switch {
+		case word && alt:
+			res = uint64(int32(a) >> shamt)
+		case word:
+			res = uint64(uint32(a) >> shamt)
+		case alt:
+			res = uint64(cpu.signed(a) >> shamt)
+		default:
+			res = cpu.trunc(a) >> shamt
+		}
+	case 0x6:
+		res = a | b
+	case 0x7:
+		res = a & b
+	}
+	if word {
+		res = uint64(int64(int32(res)))
+	}
+	inst.wbop = &Wbops{dest: inst.rd, data: res}
+}
+
+// M extension from full width products and quotients
+func (cpu *Cpu) goldenMulDiv(inst *Instruction) uint64 {
+
+	word := inst.opcode == OP32
+	xlen := cpu.xlen()
+	if word {
+		xlen = 32
+	}
+	mask := ^uint64(0) >> (64 - xlen)
+	a, b := inst.rs1&mask, inst.rs2&mask
+	sa, sb := int64(a<<(64-xlen))>>(64-xlen), int64(b<<(64-xlen))>>(64-xlen)
+	sext := func(v uint64) uint64 { return uint64(int64(v<<(64-xlen)) >> (64 - xlen)) }
+
+	//high half of signed,signed-unsigned and unsigned products
+	high := func(signedA bool, signedB bool) uint64 {
+		if xlen == 32 && !signedA && !signedB {
+			return a * b >> 32
+		}
+		if xlen == 32 {
+			x, y := int64(a), int64(b)
+			if signedA {
+				x = sa
+			}
+			if signedB {
+				y = sb
+			}
+			return uint64(x * y >> 32)
+		}
+		hi, _ := bits.Mul64(a, b)
+		if signedA && sa < 0 {
+			hi -= b
+		}
+		if signedB && sb < 0 {
+			hi -= a
+		}
+		return hi
+	}
+	minInt := int64(math.MinInt64) >> (64 - xlen)
+
+	switch inst.funct3 {
+	case 0x0:
+		return sext(a * b)
+	case 0x1:
+		return high(true, true)
+	case 0x2:
+		return high(true, false)
+	case 0x3:
+		return high(false, false)
+	case 0x4:
+		switch {
+		case sb == 0:
+			return ^uint64(0)
+		case sa == minInt && sb == -1:
+			return uint64(sa)
+		}
+		return uint64(sa / sb)
+	case 0x5:
+		if b == 0 {
+			return ^uint64(0)
+		}
+		return sext(a / b)
+	case 0x6:
+		switch {
+		case sb == 0:
+			return uint64(sa)
+		case sa == minInt && sb == -1:
+			return 0
+		}
+		return uint64(sa % sb)
+	default:
+		if b == 0 {
+			return sext(a)
+		}
+		return sext(a % b)
+	}
+}
+
+// LR,SC and AMO* as single read-modify-write
+func (cpu *Cpu) goldenAtomic(inst *Instruction) {
+
+	funct5 := inst.funct7 >> 2
+	size := map[uint32]uint32{0x2: 4, 0x3: 8}[inst.funct3]
+	if size == 0 || size*8 > cpu.xlen() || funct5 > AMOMAXU || funct5&0b11 != 0 && funct5 > SC {
+		inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline))
+		return
+	}
+	access := ACCESS_STORE
+	if funct5 == LR {
+		access = ACCESS_LOAD
+	}
+	if inst.rs1%uint64(size) != 0 {
+		cause := uint64(CAUSE_STORE_MISALIGNED)
+		if funct5 == LR {
+			cause = CAUSE_LOAD_MISALIGNED
+		}
+		inst.raise(cause, inst.rs1)
+		return
+	}
+	pa, ok := cpu.goldenAddress(inst, inst.rs1, size, access)
+	if !ok {
+		return
+	}
+
+	old := SignExtend64(cpu.Ram.Read(uint32(pa), size), uint8(size*8))
+	src := SignExtend64(inst.rs2, uint8(size*8))
+	inst.memop = &Memops{optype: AMO_RMW, address: pa, data: inst.rs2, amo: funct5}
+	inst.wbop = &Wbops{dest: inst.rd, data: old}
+	switch funct5 {
+	case LR:
+		inst.memop.optype = LOAD
+		cpu.reserved = reservation{valid: true, address: pa}
+		return
+	case SC:
+		inst.wbop.data = 1
+		if cpu.reserved.valid && cpu.reserved.address == pa {
+			cpu.Ram.Write(uint32(pa), size, inst.rs2)
+			inst.wbop.data = 0
+		}
+		cpu.reserved.valid = false
+		return
+	}
+
+	var res uint64
+	less := func(signed bool) bool {
+		if signed {
+			return int64(old) < int64(src)
+		}
+		return old<<(64-size*8) < src<<(64-size*8)
+	}
+	switch funct5 {
+	case AMOSWAP:
+		res = src
+	case AMOADD:
+		res = old + src
+	case AMOXOR:
+		res = old ^ src
+	case AMOAND:
+		res = old & src
+	case AMOOR:
+		res = old | src
+	case AMOMIN, AMOMINU:
+		res = src
+		if less(funct5 == AMOMIN) {
+			res = old
+		}
+	case AMOMAX, AMOMAXU:
+		res = old
+		if less(funct5 == AMOMAX) {
+			res = src
+		}
+	}
+	res &= ^uint64(0) >> (64 - size*8)
+	inst.memop.data = res
+	cpu.Ram.Write(uint32(pa), size, res)
+}
+
+// csr access,ECALL,EBREAK,xRET,WFI and SFENCE.VMA
+func (cpu *Cpu) goldenSystem(inst *Instruction) {
+
+	t := &cpu.traps
+	illegal := func() { inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline)) }
+	serial := func(pc uint64, priv Privilege) {
+		inst.redirect = &Redirect{Pc: pc, Priv: priv, Cause: REDIRECT_SERIAL}
+	}
+
+	if inst.funct3 != 0x0 {
+		csr, ok := cpu.csrFile()[uint32(inst.imm)]
+		if !ok || inst.funct3 == 0x4 {
+			illegal()
+			return
+		}
+		src := inst.rs1
+		if inst.funct3 >= 0x5 {
+			src = uint64(inst.rs1_index)
+		}
+		op := inst.funct3 & 0b11
+		write := op == 0b01 || inst.rs1_index != 0
+		if write && csr.Write == nil || !cpu.csrAllowed(uint32(inst.imm), inst.priv, write) ||
+			inst.imm == CSR_SEED && !write {
+			illegal()
+			return
+		}
+		old := csr.Read()
+		switch {
+		case !write:
+		case op == 0b01:
+			csr.Write(cpu.trunc(src))
+		case op == 0b10:
+			csr.Write(cpu.trunc(old | src))
+		default:
+			csr.Write(cpu.trunc(old &^ src))
+		}
+		inst.wbop = &Wbops{dest: inst.rd, data: old}
+		return
+	}
+
+	switch {
+	case inst.imm>>5 == SFENCE_VMA && inst.rd == 0:
+		if inst.priv == PRIV_U || inst.priv == PRIV_S && t.mstatus&MSTATUS_TVM != 0 {
+			illegal()
+			return
+		}
+		asid := uint32(inst.rs2) & 0x1FF
+		for _, tlb := range []*Tlb{cpu.itlb(), cpu.dtlb()} {
+			tlb.Flush(inst.rs1, inst.rs1_index == 0, asid, inst.rs2_index == 0)
+		}
+		serial(inst.pc+4, inst.priv)
+	case inst.rd != 0 || inst.rs1_index != 0:
+		illegal()
+	case inst.imm == ECALL:
+		inst.raise(CAUSE_ECALL_U+uint64(inst.priv), 0)
+	case inst.imm == EBREAK:
+		inst.raise(CAUSE_BREAKPOINT, inst.pc)
+	case inst.imm == MRET && inst.priv == PRIV_M:
+		priv := Privilege(t.mstatus & MSTATUS_MPP >> 11)
+		mpie := t.mstatus & MSTATUS_MPIE
+		t.mstatus = t.mstatus&^(MSTATUS_MIE|MSTATUS_MPP) | mpie>>4 | MSTATUS_MPIE
+		if priv != PRIV_M {
+			t.mstatus &^= MSTATUS_MPRV
+		}
+		serial(t.mepc, priv)
+	case inst.imm == SRET && (inst.priv == PRIV_M || inst.priv == PRIV_S && t.mstatus&MSTATUS_TSR == 0):
+		priv := Privilege(t.mstatus & MSTATUS_SPP >> 8)
+		spie := t.mstatus & MSTATUS_SPIE
+		t.mstatus = t.mstatus&^(MSTATUS_SIE|MSTATUS_SPP|MSTATUS_MPRV) | spie>>4 | MSTATUS_SPIE
+		serial(t.sepc, priv)
+	case inst.imm == WFI && (inst.priv == PRIV_M || inst.priv == PRIV_S && t.mstatus&MSTATUS_TW == 0):
+	default:
+		illegal()
+	}
+}
+
+// enters handler of trapped instruction,
+// delegated traps below m mode go to s mode
+func (cpu *Cpu) goldenTrap(inst *Instruction) {
+
+	t := &cpu.traps
+	interrupt := inst.trap.cause>>(cpu.xlen()-1) != 0
+	code := inst.trap.cause &^ (1 << (cpu.xlen() - 1))
+	deleg := t.medeleg
+	if interrupt {
+		deleg = t.mideleg
+	}
+
+	tvec := t.mtvec
+	if inst.priv <= PRIV_S && deleg>>code&1 != 0 {
+		t.sepc, t.scause, t.stval = inst.pc, inst.trap.cause, inst.trap.tval
+		sie := t.mstatus & MSTATUS_SIE
+		t.mstatus = t.mstatus&^(MSTATUS_SIE|MSTATUS_SPIE|MSTATUS_SPP) | sie<<4 | uint64(inst.priv)<<8
+		cpu.priv = PRIV_S
+		tvec = t.stvec
+	} else {
+		t.mepc, t.mcause, t.mtval = inst.pc, inst.trap.cause, inst.trap.tval
+		mie := t.mstatus & MSTATUS_MIE
+		t.mstatus = t.mstatus&^(MSTATUS_MIE|MSTATUS_MPIE|MSTATUS_MPP) | mie<<4 | uint64(inst.priv)<<11
+		cpu.priv = PRIV_M
+	}
+	cpu.pc = tvec &^ 0b11
+	if tvec&0b1 != 0 && interrupt {
+		cpu.pc += 4 * code
+	}
+}
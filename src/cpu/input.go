@@ -0,0 +1,236 @@
+package cpu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const INPUT_LOG_VERSION = 1
+
+var inputLogHeader = fmt.Sprintf("go-emu input log %d", INPUT_LOG_VERSION)
+
+// host input guest observed in cycle. pushed sources
+// carry Data,read sources (time,seed) carry Value
+type InputEvent struct {
+	Cycle  uint64
+	Source string
+	Value  uint64
+	Data   []byte
+}
+
+func (e InputEvent) String() string {
+	if e.Data != nil {
+		return fmt.Sprintf("%d %s %s", e.Cycle, e.Source, strconv.Quote(string(e.Data)))
+	}
+	return fmt.Sprintf("%d %s =%#x", e.Cycle, e.Source, e.Value)
+}
+
+// every nondeterministic input of run with cycle guest observed it.
+// host pushes data (uart bytes,keys) at any time,it is queued and
+// delivered to sink at start of next cycle. values of read sources
+// are taken when hart reads them. replay delivers and returns logged
+// inputs at logged cycles and drops host pushes,so run with same rom
+// and configuration repeats exactly.
+// stages run concurrently,so reads of one cycle are recorded at its
+// end ordered by source and replayed in whatever order they arrive
+type InputLog struct {
+	mu       sync.Mutex //host pushes and reads from stage goroutines
+	sinks    map[string]func(data []byte)
+	queued   []InputEvent
+	observed []InputEvent //reads of current cycle
+	w        io.Writer
+	replay   []InputEvent
+	next     int
+	taken    map[int]bool //logged reads of current cycle already returned
+	err      error
+}
+
+// records to w,nil writer only moves host input to cycle boundary
+func NewInputRecorder(w io.Writer) *InputLog {
+	l := &InputLog{w: w, sinks: map[string]func([]byte){}}
+	if w != nil {
+		l.write(inputLogHeader)
+	}
+	return l
+}
+
+// reads whole log written by recorder
+func NewInputReplay(r io.Reader) (*InputLog, error) {
+
+	l := &InputLog{sinks: map[string]func([]byte){}, replay: []InputEvent{}, taken: map[int]bool{}}
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || scanner.Text() != inputLogHeader {
+		return nil, fmt.Errorf("not %s", inputLogHeader)
+	}
+	for n := 2; scanner.Scan(); n++ {
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("input log line %d: %q", n, scanner.Text())
+		}
+		e := InputEvent{Source: fields[1]}
+		var err error
+		if e.Cycle, err = strconv.ParseUint(fields[0], 10, 64); err == nil {
+			if value, ok := strings.CutPrefix(fields[2], "="); ok {
+				e.Value, err = strconv.ParseUint(value, 0, 64)
+			} else {
+				var data string
+				data, err = strconv.Unquote(fields[2])
+				e.Data = []byte(data)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("input log line %d: %v", n, err)
+		}
+		if last := len(l.replay) - 1; last >= 0 && e.Cycle < l.replay[last].Cycle {
+			return nil, fmt.Errorf("input log line %d: cycle %d before %d", n, e.Cycle, l.replay[last].Cycle)
+		}
+		l.replay = append(l.replay, e)
+	}
+	return l, scanner.Err()
+}
+
+// device receiving pushed source
+func (l *InputLog) Sink(source string, deliver func(data []byte)) {
+	l.sinks[source] = deliver
+}
+
+// queues host input for next cycle,dropped while replaying
+func (l *InputLog) Push(source string, data []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.replay == nil {
+		l.queued = append(l.queued, InputEvent{Source: source, Data: append([]byte{}, data...)})
+	}
+}
+
+func (l *InputLog) Replaying() bool {
+	return l.replay != nil
+}
+
+// first write error or replay divergence,hart stops there
+func (l *InputLog) Err() error {
+	return l.err
+}
+
+func (l *InputLog) fail(format string, args ...any) {
+	if l.err == nil {
+		l.err = fmt.Errorf(format, args...)
+	}
+}
+
+// log nothing going back can't undo
+func (l *InputLog) rewindable() bool {
+	return l.w == nil && l.replay == nil
+}
+
+func (l *InputLog) write(line string) {
+	if l.err == nil {
+		_, l.err = fmt.Fprintln(l.w, line)
+	}
+}
+
+func (l *InputLog) record(e InputEvent) {
+	if l.w != nil {
+		l.write(e.String())
+	}
+}
+
+// logged read of source in cycle,nil once log ended or after divergence
+func (l *InputLog) take(cycle uint64, source string) *InputEvent {
+
+	if l.err != nil || l.next == len(l.replay) {
+		return nil
+	}
+	for i := l.next; i < len(l.replay) && l.replay[i].Cycle == cycle; i++ {
+		if e := &l.replay[i]; e.Source == source && e.Data == nil && !l.taken[i] {
+			l.taken[i] = true
+			return e
+		}
+	}
+	l.fail("input replay diverged at cycle %d,guest observed %s,log has %v", cycle, source, &l.replay[l.next])
+	return nil
+}
+
+// records reads of cycle sorted by source,
+// replay checks every logged one was observed
+func (l *InputLog) endCycle(cycle uint64) {
+
+	if l.replay != nil {
+		for ; l.err == nil && l.next < len(l.replay) && l.replay[l.next].Cycle == cycle && l.replay[l.next].Data == nil; l.next++ {
+			if !l.taken[l.next] {
+				l.fail("input replay diverged at cycle %d,guest did not observe %v", cycle, &l.replay[l.next])
+			}
+		}
+		l.taken = map[int]bool{}
+		return
+	}
+	sort.SliceStable(l.observed, func(i, j int) bool { return l.observed[i].Source < l.observed[j].Source })
+	for _, e := range l.observed {
+		l.record(e)
+	}
+	l.observed = l.observed[:0]
+}
+
+// hands queued or logged pushed input to sinks,
+// called at start of cycle
+func (l *InputLog) deliver(cpu *Cpu) {
+
+	cycle := cpu.Stats.Cycles
+	if l.replay != nil {
+		for l.err == nil && l.next < len(l.replay) && l.replay[l.next].Cycle == cycle && l.replay[l.next].Data != nil {
+			l.next++
+			l.push(&l.replay[l.next-1])
+		}
+		if l.next < len(l.replay) && l.replay[l.next].Cycle < cycle {
+			l.fail("input replay diverged at cycle %d,guest did not observe %v", cycle, &l.replay[l.next])
+		}
+		return
+	}
+
+	l.mu.Lock()
+	queued := l.queued
+	l.queued = nil
+	l.mu.Unlock()
+	for i := range queued {
+		queued[i].Cycle = cycle
+		l.record(queued[i])
+		l.push(&queued[i])
+	}
+}
+
+func (l *InputLog) push(e *InputEvent) {
+	if sink := l.sinks[e.Source]; sink != nil {
+		sink(e.Data)
+	} else {
+		l.fail("no device receives input %s", e.Source)
+	}
+}
+
+// value of input guest reads from outside hart,
+// logged or taken from log in replay
+func (cpu *Cpu) Observe(source string, read func() uint64) uint64 {
+
+	l := cpu.Input
+	if l == nil {
+		return read()
+	}
+	if l.replay != nil {
+		l.mu.Lock()
+		e := l.take(cpu.Stats.Cycles, source)
+		l.mu.Unlock()
+		if e != nil {
+			return e.Value
+		}
+		return read()
+	}
+	v := read()
+	l.mu.Lock()
+	l.observed = append(l.observed, InputEvent{Cycle: cpu.Stats.Cycles, Source: source, Value: v})
+	l.mu.Unlock()
+	return v
+}
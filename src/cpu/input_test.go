@@ -0,0 +1,75 @@
+package cpu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestInputLog(t *testing.T) {
+
+	//csrrw t0/t1/t2, seed, x0
+	program := []uint32{0x015012F3, 0x01501373, 0x015013F3}
+	run := func(seed uint64, input *InputLog) *Cpu {
+		cpu := &Cpu{}
+		for i, w := range program {
+			cpu.Ram.SetLine(uint32(i*4), w)
+		}
+		cpu.SeedEntropy(seed)
+		cpu.Input = input
+		for i := 0; i < 20; i++ {
+			cpu.ClockCycle()
+		}
+		return cpu
+	}
+	seeds := func(cpu *Cpu) []uint64 {
+		return []uint64{cpu.Register(5), cpu.Register(6), cpu.Register(7)}
+	}
+
+	log := bytes.Buffer{}
+	recorded := run(1, NewInputRecorder(&log))
+	if recorded.Input.Err() != nil || strings.Count(log.String(), " seed =") != 3 || seeds(recorded)[0]>>30 != 0b10 {
+		t.Fatalf("\"TestInputLog()\" FAILED, %v\n%s", recorded.Input.Err(), log.String())
+	}
+	if live := run(2, nil); seeds(live)[0] == seeds(recorded)[0] {
+		t.Fatalf("\"TestInputLog()\" FAILED, entropy seed does not change seed csr")
+	}
+
+	//other entropy seed,values come from log
+	replay, err := NewInputReplay(bytes.NewReader(log.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := run(2, replay)
+	for i, v := range seeds(replayed) {
+		if v != seeds(recorded)[i] || replay.Err() != nil {
+			t.Errorf("\"TestInputLog()\" FAILED, replayed seed %d -> %#x recorded %#x %v", i, v, seeds(recorded)[i], replay.Err())
+		}
+	}
+
+	//deeper pipeline reads seed in later cycles
+	replay, _ = NewInputReplay(bytes.NewReader(log.Bytes()))
+	cpu := &Cpu{}
+	cpu.SetPipeline(DEEP_8)
+	for i, w := range program {
+		cpu.Ram.SetLine(uint32(i*4), w)
+	}
+	cpu.Input = replay
+	for i := 0; i < 20; i++ {
+		cpu.ClockCycle()
+	}
+	if replay.Err() == nil || !strings.Contains(replay.Err().Error(), "diverged") || !cpu.stopped() {
+		t.Errorf("\"TestInputLog()\" FAILED, divergence not detected %v", replay.Err())
+	}
+
+	if _, err := NewInputReplay(strings.NewReader("go-emu input log 2\n")); err == nil {
+		t.Errorf("\"TestInputLog()\" FAILED, other version accepted")
+	}
+	if _, err := NewInputReplay(strings.NewReader(inputLogHeader + "\n5 seed =0x1\n4 seed =0x2\n")); err == nil {
+		t.Errorf("\"TestInputLog()\" FAILED, cycles out of order accepted")
+	}
+	if _, err := NewTimeTravel(run(1, NewInputRecorder(&bytes.Buffer{}))); err == nil {
+		t.Errorf("\"TestInputLog()\" FAILED, recorded input can't be rewound")
+	}
+
+}
@@ -0,0 +1,104 @@
+package cpu
+
+type PairFailure uint8
+
+const (
+	PAIR_OK     PairFailure = iota
+	PAIR_FETCH              //second lane empty,fetch left sequential path or stalled
+	PAIR_SERIAL             //system,fence,atomic,vector or trapped instruction issues alone
+	PAIR_MEMORY             //single memory port
+	PAIR_BRANCH             //single branch unit
+	PAIR_DEPEND             //younger reads result of older one
+)
+
+type IssueStats struct {
+	Bundles  uint64 //cycles issuing into execute
+	Dual     uint64 //bundles of two instructions
+	Leftover uint64 //younger half of split bundle issued alone
+	Fetch    uint64
+	Serial   uint64
+	Memory   uint64
+	Branch   uint64
+	Depend   uint64
+}
+
+// issued bundles holding two instructions
+func (s IssueStats) DualRate() float64 {
+	if s.Bundles == 0 {
+		return 0
+	}
+	return float64(s.Dual) / float64(s.Bundles)
+}
+
+// memory access decided by opcode,memop is built in execute
+func isMemIns(inst *Instruction) bool {
+	return inst.opcode == 0b0000011 || inst.opcode == 0b0100011
+}
+
+// needs whole execute stage,csr and fence side effects
+// must not run next to younger instruction
+func isSerialIns(inst *Instruction) bool {
+	return inst.trap != nil || inst.instype == V || inst.opcode == SYSTEM ||
+		inst.opcode == MISC_MEM || inst.opcode == AMO
+}
+
+// pairing rules of dual issue,older is in lane 0
+func pairFailure(older *Instruction, younger *Instruction) PairFailure {
+
+	switch {
+	case younger == nil:
+		return PAIR_FETCH
+	case isSerialIns(older) || isSerialIns(younger):
+		return PAIR_SERIAL
+	case isMemIns(older) && isMemIns(younger):
+		return PAIR_MEMORY
+	case IsBranchIns(older) && IsBranchIns(younger):
+		return PAIR_BRANCH
+	//operands of bundle are read in decode,no forwarding inside it
+	case older.rd != 0 && (older.rd == younger.rs1_index || older.rd == younger.rs2_index):
+		return PAIR_DEPEND
+	}
+	return PAIR_OK
+}
+
+// checks bundle about to enter execute,returns younger
+// instruction that can't pair,it issues alone next cycle
+func (cpu *Cpu) issueBundle() *Instruction {
+
+	l := cpu.pipeline()
+	if l.execute == 0 {
+		return nil
+	}
+	bundle := cpu.instStorage[l.execute-1]
+	if bundle[0] == nil {
+		return nil
+	}
+	s := &cpu.Stats.Issue
+	s.Bundles++
+	if l.width == 1 {
+		return nil
+	}
+	if bundle[0] == cpu.leftover {
+		s.Leftover++
+		return nil
+	}
+	switch pairFailure(bundle[0], bundle[1]) {
+	case PAIR_OK:
+		s.Dual++
+		return nil
+	case PAIR_FETCH:
+		s.Fetch++
+		return nil
+	case PAIR_SERIAL:
+		s.Serial++
+	case PAIR_MEMORY:
+		s.Memory++
+	case PAIR_BRANCH:
+		s.Branch++
+	case PAIR_DEPEND:
+		s.Depend++
+	}
+	cpu.leftover = bundle[1]
+	bundle[1] = nil
+	return cpu.leftover
+}
@@ -0,0 +1,112 @@
+package cpu
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// pipeline log in kanata 0004 format of konata visualiser.
+// in-order pipeline writes it at end of each cycle,
+// instruction stage is name of slot holding it
+type KonataTrace struct {
+	w       io.Writer
+	err     error
+	cycle   uint64 //last cycle written,0 before first one
+	next    uint64 //id of next instruction in log
+	retired uint64
+	live    map[*Instruction]*konataEntry
+}
+
+type konataEntry struct {
+	id    uint64
+	stage string
+	seen  bool //still in pipeline this cycle
+}
+
+func NewKonataTrace(w io.Writer) *KonataTrace {
+	return &KonataTrace{w: w, live: map[*Instruction]*konataEntry{}}
+}
+
+// first write error,log is cut there
+func (k *KonataTrace) Err() error {
+	return k.err
+}
+
+func (k *KonataTrace) printf(format string, args ...any) {
+	if k.err == nil {
+		_, k.err = fmt.Fprintf(k.w, format, args...)
+	}
+}
+
+// stage changes of instructions latched in slots,
+// instruction that left pipeline retired if it wrote back
+// and was flushed otherwise
+func (k *KonataTrace) record(cpu *Cpu) {
+
+	if k.cycle == 0 {
+		k.printf("Kanata\t0004\nC=\t%d\n", cpu.Stats.Cycles)
+	} else {
+		k.printf("C\t%d\n", cpu.Stats.Cycles-k.cycle)
+	}
+	k.cycle = cpu.Stats.Cycles
+
+	l := cpu.pipeline()
+	for slot, bundle := range cpu.instStorage {
+		for _, inst := range bundle {
+			if inst == nil {
+				continue
+			}
+			e := k.live[inst]
+			if e == nil {
+				e = &konataEntry{id: k.next}
+				k.next++
+				k.live[inst] = e
+				k.printf("I\t%d\t%d\t0\n", e.id, e.id)
+				k.printf("L\t%d\t0\t%08x: %s\n", e.id, inst.pc, Disassemble(inst.romline))
+			}
+			e.seen = true
+			if stage := l.slotName(slot); stage != e.stage {
+				if e.stage != "" {
+					k.printf("E\t%d\t0\t%s\n", e.id, e.stage)
+				}
+				k.printf("S\t%d\t0\t%s\n", e.id, stage)
+				e.stage = stage
+			}
+		}
+	}
+
+	//hazardHandler holds instruction in front of execute next cycle
+	if cpu.interlock && l.execute > 0 {
+		cause := "vector"
+		if cpu.loadUse {
+			cause = "load-use"
+		}
+		for _, inst := range cpu.instStorage[l.execute-1] {
+			if e := k.live[inst]; e != nil {
+				k.printf("L\t%d\t1\t%s interlock at cycle %d \n", e.id, cause, cpu.Stats.Cycles+1)
+			}
+		}
+	}
+
+	var gone []*Instruction
+	for inst, e := range k.live {
+		if !e.seen {
+			gone = append(gone, inst)
+		}
+		e.seen = false
+	}
+	//same order in every run
+	sort.Slice(gone, func(i, j int) bool { return k.live[gone[i]].id < k.live[gone[j]].id })
+	for _, inst := range gone {
+		e := k.live[inst]
+		delete(k.live, inst)
+		k.printf("E\t%d\t0\t%s\n", e.id, e.stage)
+		if inst.stage == WB {
+			k.printf("R\t%d\t%d\t0\n", e.id, k.retired)
+			k.retired++
+		} else {
+			k.printf("R\t%d\t0\t1\n", e.id)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package cpu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestKonataTrace(t *testing.T) {
+
+	//load use and loop exit mispredict
+	var program = []uint32{
+		0x10000513, //addi a0, x0, 0x100
+		0x00200313, //addi t1, x0, 2
+		0x00052283, //lw t0, 0(a0)
+		0x005585b3, //add a1, a1, t0
+		0xfff30313, //addi t1, t1, -1
+		0xfe031ae3, //bne t1, x0, 0x08
+		0x00100613, //addi a2, x0, 1
+	}
+
+	for _, config := range []PipelineConfig{CLASSIC_5, DEEP_8, SINGLE_CYCLE} {
+		var log bytes.Buffer
+		cpu := &Cpu{Trace: NewKonataTrace(&log)}
+		cpu.SetPipeline(config)
+		for i, v := range program {
+			cpu.Ram.SetLine(uint32(i*4), v)
+		}
+		for i := 0; i < 200 && cpu.regFile.GetRegVal(12) == 0; i++ {
+			cpu.ClockCycle()
+		}
+		//fetch stops at zero word,drain pipeline so every
+		//instruction leaves log
+		for i := 0; i < len(config.Slots); i++ {
+			cpu.ClockCycle()
+		}
+
+		started, retired, flushed, stalls := 0, 0, 0, 0
+		stages := map[string]bool{}
+		for _, line := range strings.Split(log.String(), "\n") {
+			f := strings.Split(line, "\t")
+			switch {
+			case f[0] == "I":
+				started++
+			case f[0] == "R" && f[3] == "0":
+				retired++
+			case f[0] == "R" && f[3] == "1":
+				flushed++
+			case f[0] == "S":
+				stages[f[3]] = true
+			case f[0] == "L" && f[2] == "1" && strings.HasPrefix(f[3], "load-use interlock"):
+				stalls++
+			}
+		}
+		if !strings.HasPrefix(log.String(), "Kanata\t0004\nC=\t1\n") || !strings.Contains(log.String(), "\t00000008: lw t0, 0(a0)\n") {
+			t.Errorf("\"TestKonataTrace()\" %s FAILED, log starts %q", config.Name, log.String()[:80])
+		}
+		if uint64(retired) != cpu.Stats.Retired || started != retired+flushed || uint64(stalls) != cpu.Stats.LoadUse ||
+			len(stages) != len(config.Slots) || (len(config.Slots) > 1 && flushed == 0) {
+			t.Errorf("\"TestKonataTrace()\" %s FAILED, started %d retired %d flushed %d stalls %d stages %v",
+				config.Name, started, retired, flushed, stalls, stages)
+		}
+	}
+
+}
@@ -0,0 +1,158 @@
+package cpu
+
+import (
+	"fmt"
+	"strings"
+)
+
+// golden model steps at most this many trapping
+// instructions looking for next retired one
+const LOCKSTEP_MAX_TRAPS = 64
+
+type RegisterDiff struct {
+	Index  uint32
+	Core   uint64
+	Golden uint64
+}
+
+// first retired instruction core and golden model disagree on
+type LockstepMismatch struct {
+	Checked  uint64 //instructions that matched before
+	Pc       uint64
+	Romline  uint32
+	GoldenPc uint64
+	Reason   string
+	Regs     []RegisterDiff
+}
+
+func (m *LockstepMismatch) Error() string {
+	s := strings.Builder{}
+	fmt.Fprintf(&s, "lockstep mismatch after %d instructions at pc %#x (%08x) %s: %s",
+		m.Checked, m.Pc, m.Romline, Disassemble(m.Romline), m.Reason)
+	for _, r := range m.Regs {
+		fmt.Fprintf(&s, "\n  %-4s core %#x golden %#x", abiNames[r.Index], r.Core, r.Golden)
+	}
+	return s.String()
+}
+
+// co-simulation of core and golden copy of its hart,
+// architectural state is compared at every retirement.
+// golden model has no devices and no vector unit,so only
+// bare hart is supported and interrupts come from mip bits
+// software sets
+type Lockstep struct {
+	Golden   *Cpu
+	Checked  uint64
+	mismatch *LockstepMismatch
+}
+
+// copies state of hart before its first cycle,
+// core checks itself from then on
+func NewLockstep(cpu *Cpu) (*Lockstep, error) {
+
+	if cpu.Bus != nil {
+		return nil, fmt.Errorf("lockstep needs bare hart,devices on bus can't be duplicated")
+	}
+	if cpu.powered {
+		return nil, fmt.Errorf("lockstep must start before first cycle")
+	}
+	if cpu.Vector != nil {
+		return nil, fmt.Errorf("lockstep golden model has no vector unit")
+	}
+	g := &Cpu{
+		regFile: cpu.regFile,
+		pc:      cpu.pc,
+		Ram:     cpu.Ram.Clone(),
+		Xlen:    cpu.Xlen,
+		Entropy: cpu.entropy().Clone(),
+		traps:   cpu.traps,
+		pmp:     cpu.pmp,
+	}
+	l := &Lockstep{Golden: g}
+	cpu.Lockstep = l
+	return l, nil
+}
+
+// first mismatch,nil while core agrees with golden model
+func (l *Lockstep) Err() error {
+	if l.mismatch == nil {
+		return nil
+	}
+	return l.mismatch
+}
+
+func (cpu *Cpu) stopped() bool {
+	return cpu.Lockstep != nil && cpu.Lockstep.mismatch != nil || cpu.Input != nil && cpu.Input.err != nil
+}
+
+// steps golden model to its next retired instruction and
+// compares it with one core just retired
+func (l *Lockstep) check(cpu *Cpu, inst *Instruction) {
+
+	if l.mismatch != nil {
+		return
+	}
+	fail := func(golden *Instruction, format string, args ...any) {
+		l.mismatch = &LockstepMismatch{Checked: l.Checked, Pc: inst.pc, Romline: inst.romline,
+			GoldenPc: l.Golden.pc, Reason: fmt.Sprintf(format, args...)}
+		if golden != nil {
+			l.mismatch.GoldenPc = golden.pc
+		}
+	}
+
+	var golden *Instruction
+	for traps := 0; ; traps++ {
+		var err error
+		if golden, err = l.Golden.Step(); err != nil {
+			fail(golden, "%v", err)
+			return
+		}
+		if golden == nil {
+			fail(nil, "golden model stopped at pc %#x", l.Golden.pc)
+			return
+		}
+		if golden.trap == nil {
+			break
+		}
+		if traps == LOCKSTEP_MAX_TRAPS {
+			fail(golden, "golden model keeps trapping at pc %#x", golden.pc)
+			return
+		}
+	}
+
+	switch {
+	case golden.pc != inst.pc || golden.romline != inst.romline:
+		fail(golden, "golden model retired %#x (%08x) %s", golden.pc, golden.romline, Disassemble(golden.romline))
+		return
+	case golden.priv != inst.priv:
+		fail(golden, "privilege %d golden %d", inst.priv, golden.priv)
+		return
+	}
+	if m, g := inst.stored(), golden.stored(); m != g {
+		fail(golden, "store %s golden %s", m, g)
+		return
+	}
+	var regs []RegisterDiff
+	for i := uint32(1); i < 32; i++ {
+		if a, b := cpu.regFile.GetRegVal(i), l.Golden.regFile.GetRegVal(i); a != b {
+			regs = append(regs, RegisterDiff{Index: i, Core: a, Golden: b})
+		}
+	}
+	if regs != nil {
+		fail(golden, "registers differ")
+		l.mismatch.Regs = regs
+		return
+	}
+	l.Checked++
+}
+
+// memory write of instruction,sc and amo data above
+// access size is not written
+func (inst *Instruction) stored() string {
+	m := inst.memop
+	if m == nil || m.optype == LOAD {
+		return "none"
+	}
+	mask := ^uint64(0) >> (64 - 8<<(inst.funct3&0b11))
+	return fmt.Sprintf("%#x <- %#x", m.address, m.data&mask)
+}
@@ -0,0 +1,78 @@
+package cpu
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLockstep(t *testing.T) {
+
+	newCores := map[string]func() Core{
+		"ooo": func() Core { return NewOutOfOrder(&Cpu{}, DEFAULT_OOO) },
+	}
+	for name, config := range Pipelines {
+		newCores[name] = func() Core {
+			cpu := &Cpu{}
+			cpu.SetPipeline(config)
+			return cpu
+		}
+	}
+
+	for core, newCore := range newCores {
+		for name, program := range crossCheckPrograms {
+			c := newCore()
+			for address, v := range program {
+				c.Arch().Ram.SetLine(address, v)
+			}
+			l, err := NewLockstep(c.Arch())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2000; i++ {
+				c.ClockCycle()
+			}
+			if l.Err() != nil || l.Checked != c.Arch().Stats.Retired || l.Checked == 0 {
+				t.Errorf("\"TestLockstep()\" %s %s FAILED, checked %d of %d %v",
+					core, name, l.Checked, c.Arch().Stats.Retired, l.Err())
+			}
+		}
+	}
+
+	//stale sum in register file,like forwarding bug would leave
+	cpu := &Cpu{}
+	for address, v := range crossCheckPrograms["loop"] {
+		cpu.Ram.SetLine(address, v)
+	}
+	l, _ := NewLockstep(cpu)
+	for i := 0; i < 20; i++ {
+		cpu.ClockCycle()
+	}
+	cpu.SetRegister(11, 100)
+	for i := 0; i < 100; i++ {
+		cpu.ClockCycle()
+	}
+	m, ok := l.Err().(*LockstepMismatch)
+	if !ok || len(m.Regs) != 1 || m.Regs[0].Index != 11 || m.Regs[0].Core != 100 || m.Pc != m.GoldenPc {
+		t.Fatalf("\"TestLockstep()\" mismatch FAILED, %v", l.Err())
+	}
+	//hart stopped at mismatch
+	if cpu.Stats.Cycles != 21 || !strings.Contains(m.Error(), "a1   core 0x64 golden") {
+		t.Errorf("\"TestLockstep()\" mismatch FAILED, cycles %d %v", cpu.Stats.Cycles, m)
+	}
+
+	if _, err := NewLockstep(cpu); err == nil {
+		t.Errorf("\"TestLockstep()\" FAILED, lockstep started on running hart")
+	}
+
+	//golden model leaves zkn to core
+	cpu = &Cpu{}
+	cpu.Ram.SetLine(0, 0x66a58633) //aes32esmi a2, a1, a0
+	l, _ = NewLockstep(cpu)
+	for i := 0; i < 10; i++ {
+		cpu.ClockCycle()
+	}
+	if l.Err() == nil || !strings.Contains(l.Err().Error(), "does not implement aes32esmi") {
+		t.Errorf("\"TestLockstep()\" FAILED, unimplemented instruction %v", l.Err())
+	}
+
+}
@@ -0,0 +1,347 @@
+package cpu
+
+// sv32 page table entry bits
+const (
+	PTE_V = 1 << 0
+	PTE_R = 1 << 1
+	PTE_W = 1 << 2
+	PTE_X = 1 << 3
+	PTE_U = 1 << 4
+	PTE_G = 1 << 5
+	PTE_A = 1 << 6
+	PTE_D = 1 << 7
+)
+
+// SFENCE.VMA is SYSTEM funct7 0001001
+const SFENCE_VMA = 0b0001001
+
+const (
+	DEFAULT_TLB_ENTRIES      = 16
+	DEFAULT_TLB_MISS_LATENCY = 2 //one cycle per page table level
+)
+
+type AccessType uint8
+
+const (
+	ACCESS_FETCH AccessType = iota
+	ACCESS_LOAD
+	ACCESS_STORE
+)
+
+func (access AccessType) pageFault() uint64 {
+	return [...]uint64{CAUSE_FETCH_PAGE_FAULT, CAUSE_LOAD_PAGE_FAULT, CAUSE_STORE_PAGE_FAULT}[access]
+}
+
+func (access AccessType) accessFault() uint64 {
+	return [...]uint64{CAUSE_FETCH_ACCESS, CAUSE_LOAD_ACCESS, CAUSE_STORE_ACCESS}[access]
+}
+
+type TlbStats struct {
+	Hits    uint64
+	Misses  uint64
+	Flushes uint64
+}
+
+type tlbEntry struct {
+	vpn     uint32 //full vpn,only vpn[1] is compared for superpages
+	asid    uint32
+	super   bool
+	ppn     uint64
+	pte     uint32
+	pteAddr uint64 //physical address of leaf pte for A/D updates
+}
+
+// fully associative tlb with round robin replacement,
+// latencies are stall cycles added to access
+type Tlb struct {
+	HitLatency  uint32
+	MissLatency uint32
+	Stats       TlbStats
+	entries     []*tlbEntry
+	next        int
+}
+
+func NewTlb(entries int, hitLatency uint32, missLatency uint32) *Tlb {
+	return &Tlb{
+		HitLatency:  hitLatency,
+		MissLatency: missLatency,
+		entries:     make([]*tlbEntry, entries),
+	}
+}
+
+func (tlb *Tlb) lookup(vpn uint32, asid uint32) *tlbEntry {
+	for _, e := range tlb.entries {
+		if e == nil || (e.asid != asid && e.pte&PTE_G == 0) {
+			continue
+		}
+		if e.vpn == vpn || (e.super && e.vpn>>10 == vpn>>10) {
+			return e
+		}
+	}
+	return nil
+}
+
+func (tlb *Tlb) insert(e *tlbEntry) *tlbEntry {
+	if len(tlb.entries) == 0 {
+		return e
+	}
+	tlb.entries[tlb.next] = e
+	tlb.next = (tlb.next + 1) % len(tlb.entries)
+	return e
+}
+
+// drops entries matching SFENCE.VMA operands,
+// global mappings survive asid specific flush
+func (tlb *Tlb) Flush(va uint64, allVa bool, asid uint32, allAsid bool) {
+	tlb.Stats.Flushes++
+	for i, e := range tlb.entries {
+		if e == nil {
+			continue
+		}
+		vpn := uint32(va >> 12)
+		vaMatch := allVa || e.vpn == vpn || (e.super && e.vpn>>10 == vpn>>10)
+		asidMatch := allAsid || (e.asid == asid && e.pte&PTE_G == 0)
+		if vaMatch && asidMatch {
+			tlb.entries[i] = nil
+		}
+	}
+}
+
+func (cpu *Cpu) itlb() *Tlb {
+	if cpu.ITlb == nil {
+		cpu.ITlb = NewTlb(DEFAULT_TLB_ENTRIES, 0, DEFAULT_TLB_MISS_LATENCY)
+	}
+	return cpu.ITlb
+}
+
+func (cpu *Cpu) dtlb() *Tlb {
+	if cpu.DTlb == nil {
+		cpu.DTlb = NewTlb(DEFAULT_TLB_ENTRIES, 0, DEFAULT_TLB_MISS_LATENCY)
+	}
+	return cpu.DTlb
+}
+
+// loads and stores of m mode use MPP privilege when MPRV is set.
+// hart that never ran is in reset mode,instruction given
+// to it was not fetched at any privilege
+func (cpu *Cpu) dataPrivilege(inst *Instruction) Privilege {
+	if !cpu.powered {
+		return PRIV_M
+	}
+	if inst.priv == PRIV_M && cpu.traps.mstatus&MSTATUS_MPRV != 0 {
+		return Privilege(cpu.traps.mstatus & MSTATUS_MPP >> 11)
+	}
+	return inst.priv
+}
+
+// virtual to physical address of size byte access,
+// returns physical address,stall cycles and fault.
+// access crossing page boundary is translated by its first byte
+func (cpu *Cpu) translate(va uint64, size uint32, access AccessType, priv Privilege) (uint64, uint32, *Trap) {
+
+	satp := cpu.traps.satp
+	if priv == PRIV_M || cpu.xlen() != 32 || satp>>31 == 0 {
+		if !cpu.physicalAccess(va, size, access, priv) {
+			return 0, 0, &Trap{cause: access.accessFault(), tval: va}
+		}
+		return va, 0, nil
+	}
+
+	tlb := cpu.dtlb()
+	if access == ACCESS_FETCH {
+		tlb = cpu.itlb()
+	}
+	asid := uint32(satp>>22) & 0x1FF
+	latency := tlb.HitLatency
+	entry := tlb.lookup(uint32(va>>12), asid)
+	if entry == nil {
+		tlb.Stats.Misses++
+		latency = tlb.MissLatency
+		var fault *Trap
+		if entry, fault = cpu.walk(va, access); fault != nil {
+			return 0, 0, fault
+		}
+		entry.asid = asid
+		//wrong path access leaves tlb as it was
+		if !cpu.speculative {
+			tlb.insert(entry)
+		}
+	} else {
+		tlb.Stats.Hits++
+	}
+
+	if !cpu.permitted(entry.pte, access, priv) {
+		return 0, 0, &Trap{cause: access.pageFault(), tval: va}
+	}
+	//hardware A/D update,written back to page table,
+	//speculative access leaves it to commitTranslation
+	if entry.pte&PTE_A == 0 || (access == ACCESS_STORE && entry.pte&PTE_D == 0) {
+		if !cpu.physicalAccess(entry.pteAddr, 4, ACCESS_STORE, PRIV_S) {
+			return 0, 0, &Trap{cause: access.accessFault(), tval: va}
+		}
+		if !cpu.speculative {
+			cpu.setAccessed(entry, access)
+		}
+	}
+
+	ppn := entry.ppn
+	if entry.super {
+		ppn = ppn&^0x3FF | va>>12&0x3FF
+	}
+	pa := ppn<<12 | va&0xFFF
+	if !cpu.physicalAccess(pa, size, access, priv) {
+		return 0, 0, &Trap{cause: access.accessFault(), tval: va}
+	}
+	return pa, latency, nil
+}
+
+// sets A and D of pte in tlb entry and page table
+func (cpu *Cpu) setAccessed(entry *tlbEntry, access AccessType) {
+
+	if entry.pte&PTE_A != 0 && (access != ACCESS_STORE || entry.pte&PTE_D != 0) {
+		return
+	}
+	entry.pte |= PTE_A
+	if access == ACCESS_STORE {
+		entry.pte |= PTE_D
+	}
+	cpu.writePhys(nil, entry.pteAddr, 4, uint64(entry.pte))
+}
+
+// translation side effects of access translated speculatively,
+// walks again at commit to fill tlb and update A/D.
+// access was checked at execute,fault here means page table
+// changed since and is left to next access
+func (cpu *Cpu) commitTranslation(va uint64, access AccessType, priv Privilege) {
+
+	satp := cpu.traps.satp
+	if priv == PRIV_M || cpu.xlen() != 32 || satp>>31 == 0 {
+		return
+	}
+	tlb := cpu.dtlb()
+	asid := uint32(satp>>22) & 0x1FF
+	entry := tlb.lookup(uint32(va>>12), asid)
+	if entry == nil {
+		var fault *Trap
+		if entry, fault = cpu.walk(va, access); fault != nil {
+			return
+		}
+		entry.asid = asid
+		tlb.insert(entry)
+	}
+	cpu.setAccessed(entry, access)
+}
+
+// two level sv32 walk,returns leaf entry without asid
+func (cpu *Cpu) walk(va uint64, access AccessType) (*tlbEntry, *Trap) {
+
+	pageFault := &Trap{cause: access.pageFault(), tval: va}
+	a := (cpu.traps.satp & 0x3FFFFF) << 12
+	vpn := [2]uint64{va >> 12 & 0x3FF, va >> 22 & 0x3FF}
+
+	for level := 1; level >= 0; level-- {
+		pteAddr := a + vpn[level]*4
+		//page table accesses are checked as s mode loads
+		if !cpu.physicalAccess(pteAddr, 4, ACCESS_LOAD, PRIV_S) {
+			return nil, &Trap{cause: access.accessFault(), tval: va}
+		}
+		pte := uint32(cpu.readPhys(pteAddr, 4))
+		if pte&PTE_V == 0 || (pte&PTE_R == 0 && pte&PTE_W != 0) {
+			return nil, pageFault
+		}
+		if pte&(PTE_R|PTE_X) != 0 {
+			ppn := uint64(pte >> 10)
+			//misaligned superpage
+			if level == 1 && ppn&0x3FF != 0 {
+				return nil, pageFault
+			}
+			return &tlbEntry{
+				vpn:     uint32(va >> 12),
+				super:   level == 1,
+				ppn:     ppn,
+				pte:     pte,
+				pteAddr: pteAddr,
+			}, nil
+		}
+		a = uint64(pte>>10) << 12
+	}
+	return nil, pageFault
+}
+
+// physical address must be mapped on bus and allowed by pmp
+func (cpu *Cpu) physicalAccess(pa uint64, size uint32, access AccessType, priv Privilege) bool {
+	return cpu.mapped(pa, size, access) && cpu.pmpAllowed(pa, size, access, priv)
+}
+
+// U bit,SUM and MXR checks of leaf pte
+func (cpu *Cpu) permitted(pte uint32, access AccessType, priv Privilege) bool {
+
+	mstatus := cpu.traps.mstatus
+	if pte&PTE_U != 0 {
+		if priv == PRIV_S && (access == ACCESS_FETCH || mstatus&MSTATUS_SUM == 0) {
+			return false
+		}
+	} else if priv == PRIV_U {
+		return false
+	}
+	switch access {
+	case ACCESS_FETCH:
+		return pte&PTE_X != 0
+	case ACCESS_LOAD:
+		return pte&PTE_R != 0 || (mstatus&MSTATUS_MXR != 0 && pte&PTE_X != 0)
+	}
+	return pte&PTE_W != 0
+}
+
+// translate memory operation built by execute stage,
+// vector ops translate every active element up front
+func (cpu *Cpu) translateMemop(inst *Instruction) {
+
+	access := ACCESS_LOAD
+	if inst.memop.optype == STORE || inst.memop.optype == AMO_RMW {
+		access = ACCESS_STORE
+	}
+	priv := cpu.dataPrivilege(inst)
+
+	if op := inst.vecop; op != nil {
+		op.paddr = make([]uint64, op.vl)
+		for i := uint32(0); i < op.vl; i++ {
+			if !cpu.Vector.active(op, i) {
+				continue
+			}
+			va := cpu.trunc(inst.memop.address + uint64(i)*op.stride)
+			pa, latency, fault := cpu.translate(va, op.eew, access, priv)
+			if fault != nil {
+				inst.raise(fault.cause, fault.tval)
+				return
+			}
+			op.paddr[i] = pa
+			inst.memop.latency += latency
+		}
+		return
+	}
+
+	size := uint32(1) << (inst.funct3 & 0b11)
+	pa, latency, fault := cpu.translate(cpu.trunc(inst.memop.address), size, access, priv)
+	if fault != nil {
+		inst.raise(fault.cause, fault.tval)
+		return
+	}
+	inst.vaddr = cpu.trunc(inst.memop.address)
+	inst.memop.address = pa
+	inst.memop.latency = latency
+}
+
+// SFENCE.VMA,rs1 selects virtual address and rs2 asid,
+// x0 in either means all. fetched instructions are refetched
+func (cpu *Cpu) sfenceVma(inst *Instruction) {
+
+	if inst.priv == PRIV_U || (inst.priv == PRIV_S && cpu.traps.mstatus&MSTATUS_TVM != 0) {
+		inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline))
+		return
+	}
+	asid := uint32(inst.rs2) & 0x1FF
+	cpu.itlb().Flush(inst.rs1, inst.rs1_index == 0, asid, inst.rs2_index == 0)
+	cpu.dtlb().Flush(inst.rs1, inst.rs1_index == 0, asid, inst.rs2_index == 0)
+	inst.redirect = &Redirect{Pc: inst.pc + 4, Priv: inst.priv, Cause: REDIRECT_SERIAL}
+}
@@ -0,0 +1,225 @@
+package cpu
+
+import (
+	"testing"
+)
+
+// root table at 0x10000,second level table at 0x11000
+func buildPageTables(cpu *Cpu) {
+
+	pte := func(pa uint64, flags uint32) uint64 { return pa>>12<<10 | uint64(flags) }
+	root, l0 := uint32(0x10000), uint32(0x11000)
+
+	//0x00400000 -> second level table
+	cpu.Ram.Write(root+1*4, 4, pte(uint64(l0), PTE_V))
+	//0x00800000 -> 4MiB superpage at 0
+	cpu.Ram.Write(root+2*4, 4, pte(0, PTE_V|PTE_R|PTE_W|PTE_X|PTE_A|PTE_D))
+	//0x00c00000 -> misaligned superpage
+	cpu.Ram.Write(root+3*4, 4, pte(0x1000, PTE_V|PTE_R))
+	//0x01000000 -> table outside of ram
+	cpu.Ram.Write(root+4*4, 4, pte(0x100000, PTE_V))
+
+	//user code,user data,supervisor data,user execute only
+	cpu.Ram.Write(l0+0*4, 4, pte(0x2000, PTE_V|PTE_R|PTE_X|PTE_U))
+	cpu.Ram.Write(l0+1*4, 4, pte(0x3000, PTE_V|PTE_R|PTE_W|PTE_U))
+	cpu.Ram.Write(l0+3*4, 4, pte(0x5000, PTE_V|PTE_R|PTE_W))
+	cpu.Ram.Write(l0+4*4, 4, pte(0x6000, PTE_V|PTE_X|PTE_U))
+
+	cpu.traps.satp = 1<<31 | uint64(root>>12)
+}
+
+func TestSv32Translate(t *testing.T) {
+
+	cpu := Cpu{DTlb: NewTlb(4, 1, 7)}
+	buildPageTables(&cpu)
+	cpu.PmpAllowAll()
+
+	check := func(name string, va uint64, access AccessType, priv Privilege, pa uint64, cause uint64) {
+		got, _, fault := cpu.translate(va, 4, access, priv)
+		switch {
+		case cause != 0 && (fault == nil || fault.cause != cause || fault.tval != va):
+			t.Errorf("\"TestSv32Translate()\" %s FAILED, expected cause -> %d got -> %v", name, cause, fault)
+		case cause == 0 && (fault != nil || got != pa):
+			t.Errorf("\"TestSv32Translate()\" %s FAILED, expected -> %x got -> %x %v", name, pa, got, fault)
+		}
+	}
+
+	//first access walks,second hits
+	_, latency, _ := cpu.translate(0x401008, 4, ACCESS_LOAD, PRIV_U)
+	_, hitLatency, _ := cpu.translate(0x401008, 4, ACCESS_LOAD, PRIV_U)
+	if latency != 7 || hitLatency != 1 || cpu.DTlb.Stats.Misses != 1 || cpu.DTlb.Stats.Hits != 1 {
+		t.Errorf("\"TestSv32Translate()\" FAILED, latency -> %d,%d stats -> %+v", latency, hitLatency, cpu.DTlb.Stats)
+	}
+	//load sets A,store sets D in memory
+	if pte := cpu.Ram.Read(0x11004, 4); pte&PTE_A == 0 || pte&PTE_D != 0 {
+		t.Errorf("\"TestSv32Translate()\" A/D FAILED, pte -> %x", pte)
+	}
+	check("store", 0x401010, ACCESS_STORE, PRIV_U, 0x3010, 0)
+	if pte := cpu.Ram.Read(0x11004, 4); pte&PTE_D == 0 {
+		t.Errorf("\"TestSv32Translate()\" A/D FAILED, pte -> %x", pte)
+	}
+
+	check("fetch", 0x400004, ACCESS_FETCH, PRIV_U, 0x2004, 0)
+	check("store to code", 0x400004, ACCESS_STORE, PRIV_U, 0, CAUSE_STORE_PAGE_FAULT)
+	check("user to supervisor page", 0x403000, ACCESS_LOAD, PRIV_U, 0, CAUSE_LOAD_PAGE_FAULT)
+	check("supervisor to user page", 0x401000, ACCESS_LOAD, PRIV_S, 0, CAUSE_LOAD_PAGE_FAULT)
+	check("execute only", 0x404000, ACCESS_LOAD, PRIV_U, 0, CAUSE_LOAD_PAGE_FAULT)
+	check("superpage", 0x800010, ACCESS_LOAD, PRIV_S, 0x10, 0)
+	check("misaligned superpage", 0xc00000, ACCESS_LOAD, PRIV_S, 0, CAUSE_LOAD_PAGE_FAULT)
+	check("unmapped fetch", 0x405000, ACCESS_FETCH, PRIV_U, 0, CAUSE_FETCH_PAGE_FAULT)
+	check("unmapped store", 0x405000, ACCESS_STORE, PRIV_U, 0, CAUSE_STORE_PAGE_FAULT)
+	check("table outside ram", 0x1000000, ACCESS_LOAD, PRIV_S, 0, CAUSE_LOAD_ACCESS)
+	check("machine mode", 0x401000, ACCESS_LOAD, PRIV_M, 0x401000, CAUSE_LOAD_ACCESS)
+	check("machine mode", 0x20000, ACCESS_LOAD, PRIV_M, 0x20000, 0)
+
+	//SUM opens user pages to loads and stores,never to fetch
+	cpu.traps.mstatus |= MSTATUS_SUM
+	check("sum", 0x401000, ACCESS_LOAD, PRIV_S, 0x3000, 0)
+	check("sum fetch", 0x400000, ACCESS_FETCH, PRIV_S, 0, CAUSE_FETCH_PAGE_FAULT)
+	//MXR makes executable pages readable
+	cpu.traps.mstatus |= MSTATUS_MXR
+	check("mxr", 0x404000, ACCESS_LOAD, PRIV_U, 0x6000, 0)
+
+	//mapping change is not seen before flush
+	cpu.Ram.Write(0x11004, 4, 0x7000>>12<<10|PTE_V|PTE_R|PTE_W|PTE_U|PTE_A|PTE_D)
+	check("stale", 0x401000, ACCESS_LOAD, PRIV_U, 0x3000, 0)
+	cpu.DTlb.Flush(0x401000, false, 0, true)
+	check("flushed", 0x401000, ACCESS_LOAD, PRIV_U, 0x7000, 0)
+
+}
+
+func TestSv32Execution(t *testing.T) {
+
+	var machine = []uint32{
+		0x800002b7, //lui t0, 0x80000
+		0x01028293, //addi t0, t0, 0x10
+		0x18029073, //csrrw x0, satp, t0
+		0x004002b7, //lui t0, 0x400
+		0x34129073, //csrrw x0, mepc, t0
+		0x10000293, //addi t0, x0, 0x100
+		0x30529073, //csrrw x0, mtvec, t0
+		0x30200073, //mret
+	}
+	var handler = []uint32{
+		0x34202773, //csrrs a4, mcause, x0
+		0x343027f3, //csrrs a5, mtval, x0
+	}
+	//user program at va 0x400000,pa 0x2000
+	var user = []uint32{
+		0x00401537, //lui a0, 0x401
+		0x02a00593, //addi a1, x0, 42
+		0x00b52023, //sw a1, 0(a0)
+		0x00052603, //lw a2, 0(a0)
+		0x00000073, //ecall
+	}
+
+	run := func(missLatency uint32) (*Cpu, int) {
+		cpu := &Cpu{
+			ITlb: NewTlb(4, 0, missLatency),
+			DTlb: NewTlb(4, 0, missLatency),
+		}
+		buildPageTables(cpu)
+		cpu.PmpAllowAll()
+		cpu.traps.satp = 0
+		for i, v := range machine {
+			cpu.Ram.SetLine(uint32(i*4), v)
+		}
+		for i, v := range handler {
+			cpu.Ram.SetLine(uint32(0x100+i*4), v)
+		}
+		for i, v := range user {
+			cpu.Ram.SetLine(uint32(0x2000+i*4), v)
+		}
+		cycles := 0
+		for ; cycles < 1000 && cpu.regFile.GetRegVal(14) == 0; cycles++ {
+			cpu.ClockCycle()
+		}
+		for i := 0; i < 10; i++ {
+			cpu.ClockCycle()
+		}
+		return cpu, cycles
+	}
+
+	cpu, fast := run(0)
+	if cpu.regFile.GetRegVal(12) != 42 || cpu.Ram.Read(0x3000, 4) != 42 ||
+		cpu.regFile.GetRegVal(14) != CAUSE_ECALL_U || cpu.Ram.Read(0x11004, 4)&PTE_D == 0 {
+		t.Errorf("\"TestSv32Execution()\" FAILED, a2 -> %d mcause -> %d", cpu.regFile.GetRegVal(12), cpu.regFile.GetRegVal(14))
+	}
+	if cpu.ITlb.Stats.Misses != 1 || cpu.DTlb.Stats.Misses != 1 || cpu.DTlb.Stats.Hits != 1 {
+		t.Errorf("\"TestSv32Execution()\" FAILED, itlb -> %+v dtlb -> %+v", cpu.ITlb.Stats, cpu.DTlb.Stats)
+	}
+
+	//each miss stalls fetch or memory stage
+	cpu, slow := run(10)
+	if cpu.regFile.GetRegVal(12) != 42 || slow < fast+20 {
+		t.Errorf("\"TestSv32Execution()\" latency FAILED, cycles -> %d vs %d", slow, fast)
+	}
+
+}
+
+func TestSv32WrongPath(t *testing.T) {
+
+	var machine = []uint32{
+		0x800002b7, //lui t0, 0x80000
+		0x01028293, //addi t0, t0, 0x10
+		0x18029073, //csrrw x0, satp, t0
+		0x004002b7, //lui t0, 0x400
+		0x34129073, //csrrw x0, mepc, t0
+		0x10000293, //addi t0, x0, 0x100
+		0x30529073, //csrrw x0, mtvec, t0
+		0x30200073, //mret
+	}
+	var handler = []uint32{
+		0x34202773, //csrrs a4, mcause, x0
+	}
+	//store and load after taken branch are on wrong path
+	var user = []uint32{
+		0x00401537, //lui a0, 0x401
+		0x02a00593, //addi a1, x0, 42
+		0x00000663, //beq x0, x0, 12
+		0x00b52023, //sw a1, 0(a0)
+		0x00052603, //lw a2, 0(a0)
+		0x00000073, //ecall
+	}
+
+	//nop in place of branch,store and load commit
+	run := func(name string, branch uint32) *Cpu {
+		var core Core = &Cpu{}
+		if name == "ooo" {
+			core = NewOutOfOrder(&Cpu{}, DEFAULT_OOO)
+		} else {
+			core.Arch().SetPipeline(Pipelines[name])
+		}
+		cpu := core.Arch()
+		buildPageTables(cpu)
+		cpu.PmpAllowAll()
+		cpu.traps.satp = 0
+		for i, v := range machine {
+			cpu.Ram.SetLine(uint32(i*4), v)
+		}
+		for i, v := range handler {
+			cpu.Ram.SetLine(uint32(0x100+i*4), v)
+		}
+		for i, v := range user {
+			cpu.Ram.SetLine(uint32(0x2000+i*4), v)
+		}
+		cpu.Ram.SetLine(0x2008, branch)
+		for i := 0; i < 1000 && cpu.regFile.GetRegVal(14) == 0; i++ {
+			core.ClockCycle()
+		}
+		return cpu
+	}
+
+	for _, name := range fuzzCores() {
+		//page table is updated only by accesses that commit
+		cpu := run(name, 0x00000663)
+		if cpu.regFile.GetRegVal(14) != CAUSE_ECALL_U || cpu.Ram.Read(0x11004, 4)&(PTE_A|PTE_D) != 0 || cpu.Ram.Read(0x3000, 4) != 0 {
+			t.Errorf("\"TestSv32WrongPath()\" FAILED on %s, mcause -> %d pte -> %x", name, cpu.regFile.GetRegVal(14), cpu.Ram.Read(0x11004, 4))
+		}
+		cpu = run(name, 0x00000013)
+		if cpu.regFile.GetRegVal(12) != 42 || cpu.Ram.Read(0x11004, 4)&(PTE_A|PTE_D) != PTE_A|PTE_D || cpu.Ram.Read(0x3000, 4) != 42 {
+			t.Errorf("\"TestSv32WrongPath()\" commit FAILED on %s, a2 -> %d pte -> %x", name, cpu.regFile.GetRegVal(12), cpu.Ram.Read(0x11004, 4))
+		}
+	}
+
+}
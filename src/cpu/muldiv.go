@@ -0,0 +1,147 @@
+package cpu
+
+import (
+	"math"
+	"math/bits"
+)
+
+func sext32(val uint64) uint64 {
+	return uint64(int64(int32(val)))
+}
+
+// M extension,OP funct7 0000001 and rv64 OP-32 word forms
+func (cpu *Cpu) mulDiv(inst *Instruction) {
+
+	a, b := inst.rs1, inst.rs2
+	sa, sb := cpu.signed(a), cpu.signed(b)
+	minInt := int64(math.MinInt64)
+	if cpu.xlen() == 32 {
+		minInt = math.MinInt32
+	}
+
+	//MULW,DIVW,DIVUW,REMW,REMUW work on low 32 bits
+	word := inst.opcode == OP32
+	if word {
+		a, b = a&0xFFFF_FFFF, b&0xFFFF_FFFF
+		sa, sb = int64(int32(a)), int64(int32(b))
+		minInt = math.MinInt32
+	}
+
+	var res uint64
+	switch inst.funct3 {
+	//MUL
+	case 0x0:
+		res = a * b
+	//MULH
+	case 0x1:
+		res = cpu.mulHigh(a, b, sa < 0, sb < 0)
+	//MULHSU
+	case 0x2:
+		res = cpu.mulHigh(a, b, sa < 0, false)
+	//MULHU
+	case 0x3:
+		res = cpu.mulHigh(a, b, false, false)
+	//DIV
+	case 0x4:
+		switch {
+		case sb == 0:
+			res = math.MaxUint64
+		case sa == minInt && sb == -1:
+			res = uint64(sa)
+		default:
+			res = uint64(sa / sb)
+		}
+	//DIVU
+	case 0x5:
+		if b == 0 {
+			res = math.MaxUint64
+		} else {
+			res = a / b
+		}
+	//REM
+	case 0x6:
+		switch {
+		case sb == 0:
+			res = uint64(sa)
+		case sa == minInt && sb == -1:
+			res = 0
+		default:
+			res = uint64(sa % sb)
+		}
+	//REMU
+	case 0x7:
+		if b == 0 {
+			res = a
+		} else {
+			res = a % b
+		}
+	}
+
+	if word {
+		res = sext32(res)
+	}
+	inst.wbop.data = res
+}
+
+// upper XLEN bits of product,operands are XLEN wide
+func (cpu *Cpu) mulHigh(a uint64, b uint64, aNeg bool, bNeg bool) uint64 {
+
+	if cpu.xlen() == 32 {
+		x, y := int64(a), int64(b)
+		if aNeg {
+			x = int64(int32(a))
+		}
+		if bNeg {
+			y = int64(int32(b))
+		}
+		return uint64(x*y) >> 32
+	}
+
+	hi, _ := bits.Mul64(a, b)
+	//two's complement correction of unsigned high part
+	if aNeg {
+		hi -= b
+	}
+	if bNeg {
+		hi -= a
+	}
+	return hi
+}
+
+// rv64 ADDIW,SLLIW,SRLIW,SRAIW,ADDW,SUBW,SLLW,SRLW,SRAW
+// results are 32 bit sign extended to 64
+func (cpu *Cpu) wordOp(inst *Instruction) {
+
+	a := uint32(inst.rs1)
+	var b uint32
+	var alt bool
+	if inst.opcode == OPIMM32 {
+		b = uint32(SignExtend64(inst.imm, 12))
+		alt = SubBits(uint32(inst.imm), 5, 11) == 0b0100000
+	} else {
+		b = uint32(inst.rs2)
+		alt = inst.funct7 == 0x20
+	}
+
+	var res uint32
+	switch inst.funct3 {
+	//ADDW,SUBW,ADDIW
+	case 0x0:
+		if alt && inst.opcode == OP32 {
+			res = a - b
+		} else {
+			res = a + b
+		}
+	//SLLW,SLLIW
+	case 0x1:
+		res = a << (b & 0b11111)
+	//SRLW,SRAW,SRLIW,SRAIW
+	case 0x5:
+		if alt {
+			res = uint32(int32(a) >> (b & 0b11111))
+		} else {
+			res = a >> (b & 0b11111)
+		}
+	}
+	inst.wbop.data = sext32(uint64(res))
+}
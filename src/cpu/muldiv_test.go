@@ -0,0 +1,74 @@
+package cpu
+
+import (
+	"testing"
+)
+
+func TestMulDiv(t *testing.T) {
+
+	type mulDivCase struct {
+		xlen   uint32
+		opcode uint32
+		funct3 uint32
+		rs1    uint64
+		rs2    uint64
+		result uint64
+	}
+
+	var cases = []mulDivCase{
+		//MUL
+		{32, 0b0110011, 0x0, 0xFFFF_FFFF, 3, 0xFFFF_FFFD},
+		//MULH -1*-1
+		{32, 0b0110011, 0x1, 0xFFFF_FFFF, 0xFFFF_FFFF, 0},
+		//MULHSU -1*0xffffffff
+		{32, 0b0110011, 0x2, 0xFFFF_FFFF, 0xFFFF_FFFF, 0xFFFF_FFFF},
+		//MULHU
+		{32, 0b0110011, 0x3, 0xFFFF_FFFF, 0xFFFF_FFFF, 0xFFFF_FFFE},
+		//DIV by zero
+		{32, 0b0110011, 0x4, 7, 0, 0xFFFF_FFFF},
+		//DIV overflow
+		{32, 0b0110011, 0x4, 0x8000_0000, 0xFFFF_FFFF, 0x8000_0000},
+		//DIV -7/2
+		{32, 0b0110011, 0x4, 0xFFFF_FFF9, 2, 0xFFFF_FFFD},
+		//DIVU by zero
+		{32, 0b0110011, 0x5, 7, 0, 0xFFFF_FFFF},
+		//REM -7%2
+		{32, 0b0110011, 0x6, 0xFFFF_FFF9, 2, 0xFFFF_FFFF},
+		//REM overflow
+		{32, 0b0110011, 0x6, 0x8000_0000, 0xFFFF_FFFF, 0},
+		//REMU by zero
+		{32, 0b0110011, 0x7, 7, 0, 7},
+		//MULH rv64 -1*-1
+		{64, 0b0110011, 0x1, 0xFFFF_FFFF_FFFF_FFFF, 0xFFFF_FFFF_FFFF_FFFF, 0},
+		//MULHSU rv64 -2*3
+		{64, 0b0110011, 0x2, 0xFFFF_FFFF_FFFF_FFFE, 3, 0xFFFF_FFFF_FFFF_FFFF},
+		//DIV rv64 overflow
+		{64, 0b0110011, 0x4, 0x8000_0000_0000_0000, 0xFFFF_FFFF_FFFF_FFFF, 0x8000_0000_0000_0000},
+		//DIVW overflow
+		{64, OP32, 0x4, 0x1_8000_0000, 0xFFFF_FFFF, 0xFFFF_FFFF_8000_0000},
+		//DIVUW
+		{64, OP32, 0x5, 0x1_FFFF_FFFE, 2, 0x7FFF_FFFF},
+		//REMUW by zero
+		{64, OP32, 0x7, 0x1_8000_0000, 0, 0xFFFF_FFFF_8000_0000},
+	}
+
+	for i, c := range cases {
+		cpu := Cpu{Xlen: c.xlen}
+		inst := &Instruction{
+			opcode: c.opcode,
+			funct3: c.funct3,
+			funct7: 0x01,
+			rs1:    c.rs1,
+			rs2:    c.rs2,
+			wbop:   &Wbops{},
+		}
+		cpu.mulDiv(inst)
+		if cpu.trunc(inst.wbop.data) != c.result {
+			t.Errorf("\"TestMulDiv()\" FAILED case %d, expected -> %x, got -> %x", i, c.result, inst.wbop.data)
+		}
+		if golden := cpu.trunc(cpu.goldenMulDiv(inst)); golden != c.result {
+			t.Errorf("\"TestMulDiv()\" golden FAILED case %d, expected -> %x, got -> %x", i, c.result, golden)
+		}
+	}
+
+}
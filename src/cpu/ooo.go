@@ -0,0 +1,453 @@
+package cpu
+
+// out-of-order core,tomasulo style with reorder buffer.
+// reorder buffer entries are the renamed registers,reservation
+// stations are entries waiting for operands and load/store queue
+// is memory entries in program order.
+// register file,csrs,memory and page table A/D bits change only at commit
+
+const (
+	MUL_LATENCY  = 3
+	DIV_LATENCY  = 12
+	LOAD_LATENCY = 2 //address and memory access
+	//wrong path refill of fetch and dispatch
+	OOO_REDIRECT_PENALTY = 2
+)
+
+type OooConfig struct {
+	Width   int //fetched,dispatched,issued and committed per cycle
+	RobSize int
+	RsSize  int
+	LsqSize int
+}
+
+var DEFAULT_OOO = OooConfig{Width: 2, RobSize: 32, RsSize: 16, LsqSize: 16}
+
+type OooStats struct {
+	RobFull   uint64 //dispatch stall cycles by cause
+	RsFull    uint64
+	LsqFull   uint64
+	Serial    uint64 //dispatch waits for serializing instruction to commit
+	Forwarded uint64 //loads served by older store in queue
+	LoadWaits uint64 //load issue delayed by older store
+}
+
+type oooEntry struct {
+	inst    *Instruction
+	dest    uint32       //renamed register,0 if none
+	src     [2]*oooEntry //producers of rs1 and rs2 not complete at dispatch
+	serial  bool         //alone in reorder buffer,executes as oldest
+	atHead  bool         //executed as oldest,interrupt was checked at issue
+	issued  bool
+	done    bool
+	latency uint32 //cycles left in execution unit
+}
+
+type OutOfOrder struct {
+	Config     OooConfig
+	Stats      OooStats
+	cpu        *Cpu
+	rob        []*oooEntry //oldest first
+	rat        [32]*oooEntry
+	fetchQueue []*Instruction
+	fetchNext  *Instruction
+	fetchWait  uint32
+	fetchStop  bool   //faulting fetch queued,wait for its trap
+	commitWait uint32 //stall cycles left of committed store data access
+}
+
+func NewOutOfOrder(cpu *Cpu, config OooConfig) *OutOfOrder {
+	return &OutOfOrder{Config: config, cpu: cpu}
+}
+
+func (o *OutOfOrder) Arch() *Cpu {
+	return o.cpu
+}
+
+// stages run from commit back to fetch,
+// so each one sees what previous cycle produced
+func (o *OutOfOrder) ClockCycle() {
+
+	if o.cpu.stopped() {
+		return
+	}
+	o.cpu.powerOn()
+	o.cpu.Stats.Cycles++
+	if o.cpu.Input != nil {
+		o.cpu.Input.deliver(o.cpu)
+	}
+
+	o.commit()
+	o.complete()
+	o.issue()
+	o.dispatch()
+	o.fetch()
+	if o.cpu.Input != nil {
+		o.cpu.Input.endCycle(o.cpu.Stats.Cycles)
+	}
+	if o.cpu.Travel != nil {
+		o.cpu.Travel.endCycle(o.cpu)
+	}
+}
+
+// fetch group ends at predicted taken control transfer
+func (o *OutOfOrder) fetch() {
+
+	cpu := o.cpu
+	for n := 0; n < o.Config.Width && len(o.fetchQueue) < 2*o.Config.Width && !o.fetchStop; n++ {
+		if o.fetchNext == nil {
+			o.fetchNext, o.fetchWait = cpu.fetchLine(cpu.pc)
+		}
+		if o.fetchWait > 0 {
+			o.fetchWait--
+			return
+		}
+		inst := o.fetchNext
+		o.fetchNext = nil
+		if inst == nil {
+			return
+		}
+		o.fetchQueue = append(o.fetchQueue, inst)
+		if inst.trap != nil {
+			o.fetchStop = true
+			return
+		}
+		cpu.predictor().predict(inst, o.inflight()...)
+		cpu.pc = cpu.trunc(inst.predicted)
+		if inst.predicted != inst.pc+4 {
+			return
+		}
+	}
+}
+
+// decodes and renames in program order,operands come from
+// register file or from reorder buffer entry producing them
+func (o *OutOfOrder) dispatch() {
+
+	cpu := o.cpu
+	for n := 0; n < o.Config.Width && len(o.fetchQueue) > 0; n++ {
+
+		inst := o.fetchQueue[0]
+		ch := make(chan *Instruction, 1)
+		cpu.decodeInst(inst, ch)
+		<-ch
+
+		serial := isSerialIns(inst)
+		waiting, memory := 0, 0
+		for _, e := range o.rob {
+			if !e.issued {
+				waiting++
+			}
+			if isMemIns(e.inst) {
+				memory++
+			}
+		}
+		switch {
+		case len(o.rob) != 0 && (serial || o.rob[0].serial):
+			o.Stats.Serial++
+			return
+		case len(o.rob) >= o.Config.RobSize:
+			o.Stats.RobFull++
+			return
+		case waiting >= o.Config.RsSize:
+			o.Stats.RsFull++
+			return
+		case isMemIns(inst) && memory >= o.Config.LsqSize:
+			o.Stats.LsqFull++
+			return
+		}
+
+		e := &oooEntry{inst: inst, serial: serial}
+		for i, r := range []uint32{inst.rs1_index, inst.rs2_index} {
+			p := o.rat[r]
+			if r == 0 || p == nil {
+				continue
+			}
+			if p.done {
+				e.setOperand(i, p.value())
+			} else {
+				e.src[i] = p
+			}
+		}
+		//stores and branches have no rd,serializing instructions
+		//commit before younger ones read registers
+		if !serial && inst.rd != 0 {
+			e.dest = inst.rd
+			o.rat[inst.rd] = e
+		}
+		o.rob = append(o.rob, e)
+		o.fetchQueue = o.fetchQueue[1:]
+	}
+}
+
+// oldest ready entries start execution,
+// serializing and device accesses only as oldest entry
+func (o *OutOfOrder) issue() {
+
+	issued := 0
+	for i, e := range o.rob {
+		if issued == o.Config.Width {
+			return
+		}
+		if e.issued || (e.serial && i != 0) || !e.ready() {
+			continue
+		}
+		for k, p := range e.src {
+			if p != nil {
+				e.setOperand(k, p.value())
+				e.src[k] = nil
+			}
+		}
+		if o.execute(e, i) {
+			e.issued = true
+			issued++
+		}
+	}
+}
+
+// runs instruction through execute and,except stores,memory stage.
+// returns false if load has to wait
+func (o *OutOfOrder) execute(e *oooEntry, pos int) bool {
+
+	cpu := o.cpu
+	inst := e.inst
+	ch := make(chan *Instruction, 1)
+	load := inst.opcode == 0b0000011
+
+	if load {
+		for _, older := range o.rob[:pos] {
+			if older.inst.opcode == 0b0100011 && !older.issued {
+				o.Stats.LoadWaits++
+				return false
+			}
+		}
+	}
+	if e.serial {
+		e.atHead = true
+		cpu.checkInterrupts(inst)
+	}
+	//only serializing entries execute off wrong path
+	cpu.speculative = !e.serial
+	cpu.executeInst(inst, ch)
+	<-ch
+	cpu.speculative = false
+	e.latency = 1
+	if isMulDiv(inst) {
+		e.latency = MUL_LATENCY
+		if inst.funct3 >= 4 {
+			e.latency = DIV_LATENCY
+		}
+	}
+	if inst.trap != nil || inst.memop == nil {
+		return true
+	}
+	e.latency += inst.memop.latency
+
+	if e.serial {
+		cpu.memOps(inst, ch)
+		<-ch
+		e.latency += inst.memop.accessLatency
+		if inst.vecop != nil {
+			e.latency = cpu.Vector.cycles(cpu.Vector.vl)
+		}
+		return true
+	}
+	if !load {
+		//store accesses memory at commit
+		return true
+	}
+
+	size := uint32(1) << (inst.funct3 & 0b11)
+	address := inst.memop.address
+	//device registers have read side effects,
+	//they are read only when load can't be squashed
+	if !cpu.cacheable(address, size) {
+		if pos != 0 {
+			return false
+		}
+		e.atHead = true
+		if cpu.checkInterrupts(inst); inst.trap != nil {
+			return true
+		}
+	}
+	e.latency += LOAD_LATENCY - 1
+	for k := pos - 1; k >= 0; k-- {
+		store := o.rob[k].inst
+		if store.opcode != 0b0100011 {
+			continue
+		}
+		if store.trap != nil {
+			o.Stats.LoadWaits++
+			return false
+		}
+		base := store.memop.address
+		width := uint32(1) << (store.funct3 & 0b11)
+		if address >= base+uint64(width) || base >= address+uint64(size) {
+			continue
+		}
+		//partial overlap waits for store to commit
+		if address < base || address+uint64(size) > base+uint64(width) {
+			o.Stats.LoadWaits++
+			return false
+		}
+		data := store.memop.data >> ((address - base) * 8)
+		data &= ^uint64(0) >> (64 - size*8)
+		if inst.memop.signed {
+			data = SignExtend64(data, uint8(size*8))
+		}
+		inst.wbop.data = cpu.trunc(data)
+		o.Stats.Forwarded++
+		return true
+	}
+	cpu.memOps(inst, ch)
+	<-ch
+	e.latency += inst.memop.accessLatency
+	return true
+}
+
+// counts down execution,finished branch on wrong path
+// squashes younger entries right away
+func (o *OutOfOrder) complete() {
+
+	for i, e := range o.rob {
+		if !e.issued || e.done {
+			continue
+		}
+		if e.latency > 1 {
+			e.latency--
+			continue
+		}
+		e.done = true
+		inst := e.inst
+		if inst.trap == nil && inst.redirect == nil && inst.predicted != inst.nextPc() {
+			r := &Redirect{Pc: inst.nextPc(), Priv: o.cpu.priv, Cause: REDIRECT_MISPREDICT}
+			o.flush(i+1, r)
+			return
+		}
+	}
+}
+
+// retires finished entries in program order,traps and
+// serializing redirects squash everything younger
+func (o *OutOfOrder) commit() {
+
+	cpu := o.cpu
+	if o.commitWait > 0 {
+		o.commitWait--
+		return
+	}
+	for n := 0; n < o.Config.Width && len(o.rob) > 0; n++ {
+
+		e := o.rob[0]
+		if !e.done {
+			return
+		}
+		inst := e.inst
+		if !e.atHead {
+			cpu.checkInterrupts(inst)
+		}
+		if inst.trap != nil {
+			o.flush(0, cpu.takeTrap(inst))
+			return
+		}
+		if inst.memop != nil && !e.serial {
+			access := ACCESS_LOAD
+			if inst.memop.optype == STORE {
+				access = ACCESS_STORE
+			}
+			cpu.commitTranslation(inst.vaddr, access, cpu.dataPrivilege(inst))
+		}
+		ch := make(chan *Instruction, 1)
+		if inst.memop != nil && inst.memop.optype == STORE && !e.serial {
+			cpu.memOps(inst, ch)
+			<-ch
+			o.commitWait = inst.memop.accessLatency
+		}
+		cpu.writeBack(inst, ch)
+		<-ch
+		o.rob = o.rob[1:]
+		if o.rat[e.dest] == e {
+			o.rat[e.dest] = nil
+		}
+		if inst.redirect != nil {
+			cpu.syncFetch(inst)
+			o.flush(0, inst.redirect)
+			return
+		}
+		//trains predictor in program order,
+		//mispredict was recovered when branch completed
+		cpu.predictor().resolve(inst)
+		if o.commitWait > 0 {
+			return
+		}
+	}
+}
+
+// squashes reorder buffer from keep on and front end,
+// fetch continues from redirect pc
+func (o *OutOfOrder) flush(keep int, r *Redirect) {
+
+	cpu := o.cpu
+	for _, e := range o.rob[keep:] {
+		cpu.squash(e.inst, r)
+	}
+	for _, inst := range o.fetchQueue {
+		cpu.squash(inst, r)
+	}
+	o.rob = o.rob[:keep]
+	o.fetchQueue = nil
+	o.rat = [32]*oooEntry{}
+	for _, e := range o.rob {
+		if e.dest != 0 {
+			o.rat[e.dest] = e
+		}
+	}
+
+	s := &cpu.Stats.Redirects
+	s.record(r.Cause)
+	s.Penalty += OOO_REDIRECT_PENALTY
+	o.fetchNext = nil
+	o.fetchWait = 0
+	o.fetchStop = false
+	cpu.priv = r.Priv
+	cpu.pc = r.Pc
+}
+
+// fetched instructions not committed,oldest first
+func (o *OutOfOrder) inflight() []*Instruction {
+	older := make([]*Instruction, 0, len(o.rob)+len(o.fetchQueue))
+	for _, e := range o.rob {
+		older = append(older, e.inst)
+	}
+	return append(older, o.fetchQueue...)
+}
+
+func (e *oooEntry) ready() bool {
+	for _, p := range e.src {
+		if p != nil && !p.done {
+			return false
+		}
+	}
+	return true
+}
+
+// result on common data bus,faulted producer has none
+// and its consumers are squashed with it
+func (e *oooEntry) value() uint64 {
+	if e.inst.wbop == nil {
+		return 0
+	}
+	return e.inst.wbop.data
+}
+
+func (e *oooEntry) setOperand(i int, val uint64) {
+	if i == 0 {
+		e.inst.rs1 = val
+	} else {
+		e.inst.rs2 = val
+	}
+}
+
+func isMulDiv(inst *Instruction) bool {
+	return (inst.opcode == 0b0110011 || inst.opcode == OP32) && inst.funct7 == 0x01
+}
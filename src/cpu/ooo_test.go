@@ -0,0 +1,141 @@
+package cpu
+
+import (
+	"testing"
+)
+
+// programs both timing models must agree on
+var crossCheckPrograms = map[string]map[uint32]uint32{
+	//load/store queue,forwarding and partial overlap
+	"lsq": {
+		0x00: 0x10000513, //addi a0, x0, 0x100
+		0x04: 0x123452b7, //lui t0, 0x12345
+		0x08: 0x67828293, //addi t0, t0, 0x678
+		0x0c: 0x00552023, //sw t0, 0(a0)
+		0x10: 0x00052303, //lw t1, 0(a0)
+		0x14: 0x00154383, //lbu t2, 1(a0)
+		0x18: 0x00651323, //sh t1, 6(a0)
+		0x1c: 0x00452e03, //lw t3, 4(a0)
+		0x20: 0x02628eb3, //mul t4, t0, t1
+		0x24: 0x026edf33, //divu t5, t4, t1
+		0x28: 0x01cf0fb3, //add t6, t5, t3
+		0x2c: 0x01f52423, //sw t6, 8(a0)
+		0x30: 0x00100613, //addi a2, x0, 1
+	},
+	//loop with load use and mispredicted exit
+	"loop": {
+		0x00:  0x10000513, //addi a0, x0, 0x100
+		0x04:  0x00800313, //addi t1, x0, 8
+		0x08:  0x00000593, //addi a1, x0, 0
+		0x0c:  0x00052283, //lw t0, 0(a0)
+		0x10:  0x005585b3, //add a1, a1, t0
+		0x14:  0x00450513, //addi a0, a0, 4
+		0x18:  0xfff30313, //addi t1, t1, -1
+		0x1c:  0xfe0318e3, //bne t1, x0, 0x0c
+		0x20:  0x00b52023, //sw a1, 0(a0)
+		0x100: 1, 0x104: 2, 0x108: 3, 0x10c: 4,
+		0x110: 5, 0x114: 6, 0x118: 7, 0x11c: 8,
+	},
+	//calls,returns and alternating branch
+	"calls": {
+		0x00: 0x02800513, //addi a0, x0, 40
+		0x04: 0x00000593, //addi a1, x0, 0
+		0x08: 0x00000693, //addi a3, x0, 0
+		0x0c: 0x024000ef, //jal ra, 0x30
+		0x10: 0x00157293, //andi t0, a0, 1
+		0x14: 0x00028463, //beq t0, x0, 0x1c
+		0x18: 0x00168693, //addi a3, a3, 1
+		0x1c: 0xfff50513, //addi a0, a0, -1
+		0x20: 0xfe0516e3, //bne a0, x0, 0x0c
+		0x24: 0x00100613, //addi a2, x0, 1
+		0x30: 0x00358593, //addi a1, a1, 3
+		0x34: 0x00008067, //jalr x0, 0(ra)
+	},
+	//m -> mret -> s -> sret -> u -> ecall delegated to s
+	"privilege": {
+		0x00:  0x10000293, //addi t0, x0, 0x100
+		0x04:  0x30529073, //csrrw x0, mtvec, t0
+		0x08:  0x20000293, //addi t0, x0, 0x200
+		0x0c:  0x10529073, //csrrw x0, stvec, t0
+		0x10:  0x10000293, //addi t0, x0, 0x100
+		0x14:  0x30229073, //csrrw x0, medeleg, t0
+		0x18:  0x04000293, //addi t0, x0, 0x40
+		0x1c:  0x34129073, //csrrw x0, mepc, t0
+		0x20:  0x000012b7, //lui t0, 0x1
+		0x24:  0x80028293, //addi t0, t0, -0x800
+		0x28:  0x3002a073, //csrrs x0, mstatus, t0
+		0x2c:  0x30200073, //mret
+		0x40:  0x08000293, //addi t0, x0, 0x80
+		0x44:  0x14129073, //csrrw x0, sepc, t0
+		0x48:  0x10200073, //sret
+		0x80:  0x00500813, //addi a6, x0, 5
+		0x84:  0x00000073, //ecall
+		0x100: 0x34202773, //csrrs a4, mcause, x0
+		0x104: 0x300027f3, //csrrs a5, mstatus, x0
+		0x108: 0x343028f3, //csrrs a7, mtval, x0
+		0x200: 0x142025f3, //csrrs a1, scause, x0
+		0x204: 0x14102673, //csrrs a2, sepc, x0
+		0x208: 0x10002373, //csrrs t1, sstatus, x0
+		0x20c: 0x300026f3, //csrrs a3, mstatus, x0
+	},
+}
+
+// runs program on in-order and out-of-order core
+func runBothCores(program map[uint32]uint32, cycles int) (*Cpu, *OutOfOrder) {
+
+	inorder := &Cpu{}
+	ooo := NewOutOfOrder(&Cpu{}, DEFAULT_OOO)
+	for _, core := range []Core{inorder, ooo} {
+		for address, v := range program {
+			core.Arch().Ram.SetLine(address, v)
+		}
+		for i := 0; i < cycles; i++ {
+			core.ClockCycle()
+		}
+	}
+	return inorder, ooo
+}
+
+func TestOutOfOrderCrossCheck(t *testing.T) {
+
+	for name, program := range crossCheckPrograms {
+		inorder, ooo := runBothCores(program, 2000)
+		if err := inorder.ArchDiff(ooo.Arch()); err != nil {
+			t.Errorf("\"TestOutOfOrderCrossCheck()\" %s FAILED, %v", name, err)
+		}
+	}
+
+	_, ooo := runBothCores(crossCheckPrograms["lsq"], 100)
+	c := ooo.Arch()
+	if c.regFile.GetRegVal(7) != 0x56 || c.regFile.GetRegVal(28) != 0x5678_0000 ||
+		ooo.Stats.Forwarded != 2 || ooo.Stats.LoadWaits == 0 {
+		t.Errorf("\"TestOutOfOrderCrossCheck()\" lsq FAILED, t2 -> %x t3 -> %x %+v",
+			c.regFile.GetRegVal(7), c.regFile.GetRegVal(28), ooo.Stats)
+	}
+
+}
+
+func TestOutOfOrderRom(t *testing.T) {
+
+	//rom never halts,so output it leaves in ram is compared
+	inorder := &Cpu{}
+	ooo := NewOutOfOrder(&Cpu{}, DEFAULT_OOO)
+	for _, core := range []Core{inorder, ooo} {
+		c := core.Arch()
+		c.LoadRom("test_roms/TestRomExecution_rom")
+		for i := 0; i < 2000000 && c.Ram.GetLine(30028) != 1000; i++ {
+			core.ClockCycle()
+		}
+	}
+	for a := uint32(30028); a <= 30048; a += 4 {
+		if x, y := inorder.Ram.GetLine(a), ooo.Arch().Ram.GetLine(a); x != y || ooo.Arch().Ram.GetLine(30028) != 1000 {
+			t.Errorf("\"TestOutOfOrderRom()\" FAILED, ram %d %d != %d", a, x, y)
+		}
+	}
+	//out-of-order core overlaps stack loads and stores of unoptimized code
+	if ooo.Arch().Stats.Cycles >= inorder.Stats.Cycles {
+		t.Errorf("\"TestOutOfOrderRom()\" FAILED, cycles %d vs %d", ooo.Arch().Stats.Cycles, inorder.Stats.Cycles)
+	}
+	t.Logf("in-order cpi %.2f out-of-order cpi %.2f %+v", inorder.Stats.CPI(), ooo.Arch().Stats.CPI(), ooo.Stats)
+
+}
@@ -0,0 +1,202 @@
+package cpu
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+var stageNames = map[Stage]string{IF: "IF", ID: "ID", IE: "IE", MEM: "MEM", WB: "WB"}
+
+var typeNames = map[InstructionType]string{R: "R", I: "I", S: "S", B: "B", U: "U", J: "J", V: "V"}
+
+func (s Stage) String() string {
+	return stageNames[s]
+}
+
+func (t InstructionType) String() string {
+	return typeNames[t]
+}
+
+// stage name of slot,split stage is numbered
+// like MEM1 and MEM2 and slot of several stages is IF-WB
+func (l *pipelineLayout) slotName(slot int) string {
+	slots := l.config.Slots
+	if n := len(slots[slot]); n > 1 {
+		return fmt.Sprintf("%s-%s", slots[slot][0], slots[slot][n-1])
+	}
+	stage := slots[slot][len(slots[slot])-1]
+	first, last := slot, slot
+	for first > 0 && len(slots[first-1]) == 1 && slots[first-1][0] == stage {
+		first--
+	}
+	for last < len(slots)-1 && len(slots[last+1]) == 1 && slots[last+1][0] == stage {
+		last++
+	}
+	if first == last {
+		return stage.String()
+	}
+	return fmt.Sprintf("%s%d", stage, slot-first+1)
+}
+
+// counts retired instruction by format,mnemonic
+// and slot its operands were forwarded from
+func (cpu *Cpu) countRetired(inst *Instruction) {
+
+	s := &cpu.Stats
+	s.Retired++
+	if s.Types == nil {
+		s.Types = map[string]uint64{}
+		s.Mnemonics = map[string]uint64{}
+		s.Forwarded = map[string]uint64{}
+	}
+	s.Types[inst.instype.String()]++
+	s.Mnemonics[Mnemonic(inst.romline)]++
+	for _, slot := range inst.forwarded {
+		if slot != 0 {
+			s.Forwarded[cpu.pipeline().slotName(slot)]++
+		}
+	}
+}
+
+// end of run statistics of all timing models of hart
+type PerfReport struct {
+	Cycles         uint64            `json:"cycles"`
+	Retired        uint64            `json:"retired"`
+	CPI            float64           `json:"cpi"`
+	IPC            float64           `json:"ipc"`
+	LoadUse        uint64            `json:"load_use_bubbles"`
+	ControlBubbles uint64            `json:"control_bubbles"`
+	Forwarded      map[string]uint64 `json:"forwarded"`
+	Types          map[string]uint64 `json:"types"`
+	Mnemonics      map[string]uint64 `json:"mnemonics"`
+	Redirects      RedirectStats     `json:"redirects"`
+	Branches       BranchStats       `json:"branches"`
+	Issue          IssueStats        `json:"issue"`
+	Bus            BusStats          `json:"bus"`
+	ICache         *CacheStats       `json:"icache,omitempty"`
+	DCache         *CacheStats       `json:"dcache,omitempty"`
+	L2             *CacheStats       `json:"l2,omitempty"`
+	OutOfOrder     *OooStats         `json:"ooo,omitempty"`
+}
+
+// counters of in-order pipeline
+func (cpu *Cpu) Report() PerfReport {
+
+	s := cpu.Stats
+	r := PerfReport{
+		Cycles:         s.Cycles,
+		Retired:        s.Retired,
+		CPI:            s.CPI(),
+		IPC:            s.IPC(),
+		LoadUse:        s.LoadUse,
+		ControlBubbles: s.Redirects.Penalty,
+		Forwarded:      map[string]uint64{},
+		Types:          map[string]uint64{},
+		Mnemonics:      map[string]uint64{},
+		Redirects:      s.Redirects,
+		Branches:       cpu.predictor().Stats,
+		Issue:          s.Issue,
+		Bus:            s.Bus,
+	}
+	for k, v := range s.Forwarded {
+		r.Forwarded[k] = v
+	}
+	for k, v := range s.Types {
+		r.Types[k] = v
+	}
+	for k, v := range s.Mnemonics {
+		r.Mnemonics[k] = v
+	}
+	if cpu.ICache != nil {
+		i := cpu.ICache.Stats
+		r.ICache = &i
+	}
+	if cpu.DCache != nil {
+		d := cpu.DCache.Stats
+		r.DCache = &d
+		if cpu.DCache.Next != nil {
+			l2 := cpu.DCache.Next.Stats
+			r.L2 = &l2
+		}
+	}
+	return r
+}
+
+// in-order counters of shared state and reorder buffer stalls
+func (o *OutOfOrder) Report() PerfReport {
+	r := o.cpu.Report()
+	s := o.Stats
+	r.OutOfOrder = &s
+	return r
+}
+
+func (r PerfReport) WriteJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(r)
+}
+
+type reportLine struct {
+	name string
+	val  any
+}
+
+// counters one per line,maps sorted by count
+func (r PerfReport) WriteText(w io.Writer) error {
+
+	lines := []reportLine{
+		{"cycles", r.Cycles},
+		{"retired", r.Retired},
+		{"cpi", fmt.Sprintf("%.3f", r.CPI)},
+		{"ipc", fmt.Sprintf("%.3f", r.IPC)},
+		{"load-use bubbles", r.LoadUse},
+		{"control bubbles", r.ControlBubbles},
+		{"redirects", fmt.Sprintf("%+v", r.Redirects)},
+		{"branches", fmt.Sprintf("%+v accuracy %.3f", r.Branches, r.Branches.Accuracy())},
+		{"issue", fmt.Sprintf("%+v", r.Issue)},
+		{"bus", fmt.Sprintf("%+v", r.Bus)},
+	}
+	caches := map[string]*CacheStats{"icache": r.ICache, "dcache": r.DCache, "l2": r.L2}
+	for _, name := range []string{"icache", "dcache", "l2"} {
+		if c := caches[name]; c != nil {
+			lines = append(lines, reportLine{name, fmt.Sprintf("%+v miss rate %.3f", *c, c.MissRate())})
+		}
+	}
+	if r.OutOfOrder != nil {
+		lines = append(lines, reportLine{"out-of-order", fmt.Sprintf("%+v", *r.OutOfOrder)})
+	}
+	for _, l := range lines {
+		if _, err := fmt.Fprintf(w, "%-18s %v\n", l.name, l.val); err != nil {
+			return err
+		}
+	}
+	counts := map[string]map[string]uint64{"forwarded from": r.Forwarded, "instruction types": r.Types, "mnemonics": r.Mnemonics}
+	for _, name := range []string{"forwarded from", "instruction types", "mnemonics"} {
+		if _, err := fmt.Fprintf(w, "%s\n", name); err != nil {
+			return err
+		}
+		for _, k := range sortedByCount(counts[name]) {
+			if _, err := fmt.Fprintf(w, "  %-16s %d\n", k, counts[name][k]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// keys of highest count first,ties by name
+func sortedByCount(counts map[string]uint64) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
@@ -0,0 +1,122 @@
+package cpu
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMnemonic(t *testing.T) {
+
+	words := map[uint32]string{
+		0x10000513: "addi",
+		0x005585b3: "add",
+		0x40b50533: "sub",
+		0x4015d593: "srai",
+		0x02628eb3: "mul",
+		0x026edf33: "divu",
+		0x00052283: "lw",
+		0x00b52023: "sw",
+		0xfe0318e3: "bne",
+		0x024000ef: "jal",
+		0x00008067: "jalr",
+		0x123452b7: "lui",
+		0x30529073: "csrrw",
+		0x30200073: "mret",
+		0x00000073: "ecall",
+		0x12000073: "sfence.vma",
+		0x0000100f: "fence.i",
+		0x100522af: "lr.w",
+		0x00b5302f: "amoadd.d",
+		0x0015059b: "addiw",
+		0x00000000: "unknown",
+	}
+	for word, name := range words {
+		if m := Mnemonic(word); m != name {
+			t.Errorf("\"TestMnemonic()\" FAILED, %08x -> %s not %s", word, m, name)
+		}
+	}
+
+	text := map[uint32]string{
+		0x10000513: "addi a0, zero, 256",
+		0x00b52023: "sw a1, 0(a0)",
+		0xfe0318e3: "bne t1, zero, -16",
+		0x4015d593: "srai a1, a1, 1",
+		0x30529073: "csrrw zero, 0x305, t0",
+		0x00b5302f: "amoadd.d zero, a1, (a0)",
+	}
+	for word, want := range text {
+		if d := Disassemble(word); d != want {
+			t.Errorf("\"TestMnemonic()\" FAILED, %08x -> %q not %q", word, d, want)
+		}
+	}
+
+}
+
+func TestPerfCounters(t *testing.T) {
+
+	//sum of array,load use in loop body
+	var program = []uint32{
+		0x10000513, //addi a0, x0, 0x100
+		0x00800313, //addi t1, x0, 8
+		0x00000593, //addi a1, x0, 0
+		0x00052283, //lw t0, 0(a0)
+		0x005585b3, //add a1, a1, t0
+		0x00450513, //addi a0, a0, 4
+		0xfff30313, //addi t1, t1, -1
+		0xfe0318e3, //bne t1, x0, 0x0c
+		0x00b52023, //sw a1, 0(a0)
+		0x00100613, //addi a2, x0, 1
+	}
+
+	run := func(core Core) PerfReport {
+		cpu := core.Arch()
+		for i, v := range program {
+			cpu.Ram.SetLine(uint32(i*4), v)
+		}
+		for i := uint32(0); i < 8; i++ {
+			cpu.Ram.SetLine(0x100+i*4, i+1)
+		}
+		for i := 0; i < 2000 && cpu.regFile.GetRegVal(12) == 0; i++ {
+			core.ClockCycle()
+		}
+		return core.Report()
+	}
+
+	r := run(&Cpu{})
+	//every loop iteration waits one cycle for load data
+	if r.Retired != 3+8*5+2 || r.LoadUse != 8 || r.Mnemonics["lw"] != 8 || r.Mnemonics["addi"] != 3+16+1 ||
+		r.Types["B"] != 8 || r.Types["S"] != 1 || r.Types["R"] != 8 {
+		t.Errorf("\"TestPerfCounters()\" FAILED, %+v", r)
+	}
+	//add takes load data from memory stage,loop counter goes
+	//from execute stage to bne
+	if r.Forwarded["MEM"] == 0 || r.Forwarded["IE"] == 0 || r.ControlBubbles != r.Redirects.Penalty {
+		t.Errorf("\"TestPerfCounters()\" forwarding FAILED, %+v", r.Forwarded)
+	}
+
+	deep := &Cpu{}
+	deep.SetPipeline(DEEP_8)
+	if r := run(deep); r.Forwarded["MEM3"] == 0 || r.Forwarded["MEM"] != 0 || r.LoadUse <= 8 {
+		t.Errorf("\"TestPerfCounters()\" 8-stage FAILED, load use %d %+v", r.LoadUse, r.Forwarded)
+	}
+
+	ooo := run(NewOutOfOrder(&Cpu{}, DEFAULT_OOO))
+	if ooo.Retired != r.Retired || ooo.OutOfOrder == nil || ooo.Mnemonics["sw"] != 1 {
+		t.Errorf("\"TestPerfCounters()\" out-of-order FAILED, %+v", ooo)
+	}
+
+	var out bytes.Buffer
+	r.WriteJSON(&out)
+	var decoded PerfReport
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || decoded.Cycles != r.Cycles || decoded.Mnemonics["bne"] != 8 {
+		t.Errorf("\"TestPerfCounters()\" json FAILED, %v %s", err, out.String())
+	}
+	out.Reset()
+	r.WriteText(&out)
+	if !strings.Contains(out.String(), "load-use bubbles   8\n") || !strings.Contains(out.String(), "  lw               8\n") {
+		t.Errorf("\"TestPerfCounters()\" text FAILED, %s", out.String())
+	}
+
+}
@@ -0,0 +1,233 @@
+package cpu
+
+import (
+	"fmt"
+)
+
+// pipeline layout,each slot performs its stages in one cycle.
+// stage repeated in consecutive slots is split,its work is done
+// in first slot and result is ready after last one
+type PipelineConfig struct {
+	Name      string
+	Slots     [][]Stage
+	Pipelined bool //false means next instruction is fetched after previous one left
+	Width     int  //instructions issued per cycle,zero means one
+}
+
+var (
+	SINGLE_CYCLE = PipelineConfig{Name: "single-cycle", Slots: [][]Stage{{IF, ID, IE, MEM, WB}}, Pipelined: true}
+	MULTI_CYCLE  = PipelineConfig{Name: "multi-cycle", Slots: [][]Stage{{IF}, {ID}, {IE}, {MEM}, {WB}}}
+	CLASSIC_5    = PipelineConfig{Name: "5-stage", Slots: [][]Stage{{IF}, {ID}, {IE}, {MEM}, {WB}}, Pipelined: true}
+	//IF1 IF2 ID EX MEM1 MEM2 WB
+	DEEP_7 = PipelineConfig{Name: "7-stage", Slots: [][]Stage{{IF}, {IF}, {ID}, {IE}, {MEM}, {MEM}, {WB}}, Pipelined: true}
+	//IF IS RF EX DF DS TC WB like mips r4000
+	DEEP_8 = PipelineConfig{Name: "8-stage", Slots: [][]Stage{{IF}, {IF}, {ID}, {IE}, {MEM}, {MEM}, {MEM}, {WB}}, Pipelined: true}
+	//in-order superscalar,two instructions per slot
+	DUAL_ISSUE = PipelineConfig{Name: "dual-issue", Slots: [][]Stage{{IF}, {ID}, {IE}, {MEM}, {WB}}, Pipelined: true, Width: 2}
+)
+
+var Pipelines = map[string]PipelineConfig{
+	SINGLE_CYCLE.Name: SINGLE_CYCLE,
+	MULTI_CYCLE.Name:  MULTI_CYCLE,
+	CLASSIC_5.Name:    CLASSIC_5,
+	DEEP_7.Name:       DEEP_7,
+	DEEP_8.Name:       DEEP_8,
+	DUAL_ISSUE.Name:   DUAL_ISSUE,
+}
+
+// slot indexes derived from config,
+// hazard detection and forwarding use them
+type pipelineLayout struct {
+	config  PipelineConfig
+	work    [][]Stage //stages whose work is done in slot
+	decode  int       //first slot with decoded instruction
+	execute int       //slot computing results and resolving branches
+	memory  int       //slot accessing memory
+	memDone int       //loaded data is ready after this slot
+	width   int       //lanes of each slot
+}
+
+// stages must appear once,in order and in consecutive slots
+func newPipelineLayout(config PipelineConfig) (*pipelineLayout, error) {
+
+	l := &pipelineLayout{config: config, work: make([][]Stage, len(config.Slots))}
+	first := map[Stage]int{}
+	last := map[Stage]int{}
+	next := IF
+	for i, slot := range config.Slots {
+		if len(slot) == 0 {
+			return nil, fmt.Errorf("pipeline %s: slot %d has no stage", config.Name, i)
+		}
+		for j, stage := range slot {
+			if _, seen := first[stage]; !seen {
+				if stage != next {
+					return nil, fmt.Errorf("pipeline %s: stage %d out of order in slot %d", config.Name, stage, i)
+				}
+				first[stage] = i
+				l.work[i] = append(l.work[i], stage)
+				next++
+			} else if last[stage] != i-1 || j != 0 || len(slot) != 1 {
+				return nil, fmt.Errorf("pipeline %s: split stage %d must fill consecutive slots", config.Name, stage)
+			}
+			last[stage] = i
+		}
+	}
+	if next != WB+1 {
+		return nil, fmt.Errorf("pipeline %s: missing stages", config.Name)
+	}
+	l.decode = first[ID]
+	l.execute = first[IE]
+	l.memory = first[MEM]
+	l.memDone = last[MEM]
+	l.width = max(config.Width, 1)
+	//pairing is checked on decoded bundle in front of execute
+	if l.width > 2 || (l.width > 1 && (!config.Pipelined || l.decode >= l.execute)) {
+		return nil, fmt.Errorf("pipeline %s: unsupported issue width %d", config.Name, config.Width)
+	}
+	return l, nil
+}
+
+// selects pipeline layout,only before first cycle
+func (cpu *Cpu) SetPipeline(config PipelineConfig) error {
+	l, err := newPipelineLayout(config)
+	if err != nil {
+		return err
+	}
+	cpu.layout = l
+	cpu.instStorage = make([][]*Instruction, len(config.Slots))
+	for i := range cpu.instStorage {
+		cpu.instStorage[i] = make([]*Instruction, l.width)
+	}
+	return nil
+}
+
+func (cpu *Cpu) pipeline() *pipelineLayout {
+	if cpu.layout == nil {
+		cpu.SetPipeline(CLASSIC_5)
+	}
+	return cpu.layout
+}
+
+func (cpu *Cpu) Pipeline() PipelineConfig {
+	return cpu.pipeline().config
+}
+
+// fetch cycles lost by redirect,slots in front of execute are squashed
+func (cpu *Cpu) RedirectPenalty() uint64 {
+	if !cpu.pipeline().config.Pipelined {
+		return 0
+	}
+	return uint64(cpu.pipeline().execute)
+}
+
+// performs work of slot stages on bundle coming from previous slot,
+// lanes go through each stage oldest first.
+// trapped instruction stops after execute where trap is taken
+func (cpu *Cpu) runSlot(stages []Stage, in []*Instruction, fetch bool, out chan []*Instruction) {
+
+	bundle := make([]*Instruction, cpu.pipeline().width)
+	copy(bundle, in)
+	//lanes behind older lane leaving fetch path are on wrong path,
+	//they pass without work and are squashed at end of cycle
+	wrongPath := len(bundle)
+	for _, stage := range stages {
+		if stage == IF {
+			if fetch {
+				ch := make(chan *Instruction, 1)
+				cpu.fetchInst(ch)
+				bundle[0] = <-ch
+				for lane := 1; lane < len(bundle); lane++ {
+					bundle[lane] = cpu.fetchSecond(bundle[lane-1])
+				}
+			}
+			continue
+		}
+		for lane, inst := range bundle {
+			if inst != nil && inst.trap != nil && stage > IE || lane >= wrongPath {
+				continue
+			}
+			ch := make(chan *Instruction, 1)
+			switch stage {
+			case ID:
+				cpu.decodeInst(inst, ch)
+			case IE:
+				cpu.checkInterrupts(inst)
+				cpu.executeInst(inst, ch)
+			case MEM:
+				cpu.memOps(inst, ch)
+			case WB:
+				cpu.writeBack(inst, ch)
+			}
+			bundle[lane] = <-ch
+			if stage == IE && bundle[lane].divert() {
+				wrongPath = lane + 1
+			}
+		}
+	}
+	out <- bundle
+}
+
+// moves bundles one slot forward,slots before hold keep
+// their bundles and hold slot gets bubble.
+// hold below zero advances whole pipeline and fetches
+func (cpu *Cpu) advance(hold int) {
+
+	l := cpu.pipeline()
+	latch := cpu.instStorage
+	fetch := cpu.canFetch()
+	outs := make([]chan []*Instruction, len(latch))
+	for i := len(latch) - 1; i > hold && i > 0; i-- {
+		outs[i] = make(chan []*Instruction)
+		go cpu.runSlot(l.work[i], latch[i-1], fetch, outs[i])
+	}
+	next := make([][]*Instruction, len(latch))
+	for i := len(latch) - 1; i > hold && i > 0; i-- {
+		next[i] = <-outs[i]
+	}
+	//fetch reads latches and shares l2 with memory stage,
+	//it runs after every other slot finished
+	if hold < 0 {
+		outs[0] = make(chan []*Instruction, 1)
+		cpu.runSlot(l.work[0], nil, fetch, outs[0])
+		next[0] = <-outs[0]
+	}
+	for i := len(latch) - 1; i > hold; i-- {
+		latch[i] = next[i]
+	}
+	if hold >= 0 {
+		latch[hold] = make([]*Instruction, l.width)
+	}
+}
+
+// true if slot 0 may fetch,multi-cycle core waits for empty pipeline
+func (cpu *Cpu) canFetch() bool {
+	if cpu.pipeline().config.Pipelined {
+		return true
+	}
+	for _, bundle := range cpu.instStorage[:len(cpu.instStorage)-1] {
+		if bundle[0] != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// instructions fetched but not executed,oldest first
+func (cpu *Cpu) inflight() []*Instruction {
+	front := cpu.instStorage[:cpu.pipeline().execute]
+	older := make([]*Instruction, 0, len(front)*cpu.pipeline().width)
+	for i := len(front) - 1; i >= 0; i-- {
+		older = append(older, front[i]...)
+	}
+	return older
+}
+
+// last instruction of bundle in program order
+func youngest(bundle []*Instruction) *Instruction {
+	for lane := len(bundle) - 1; lane > 0; lane-- {
+		if bundle[lane] != nil {
+			return bundle[lane]
+		}
+	}
+	return bundle[0]
+}
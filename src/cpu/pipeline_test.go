@@ -0,0 +1,140 @@
+package cpu
+
+import (
+	"testing"
+)
+
+func TestPipelineLayout(t *testing.T) {
+
+	for _, config := range Pipelines {
+		if _, err := newPipelineLayout(config); err != nil {
+			t.Errorf("\"TestPipelineLayout()\" FAILED, %v", err)
+		}
+	}
+	l, _ := newPipelineLayout(DEEP_8)
+	if l.decode != 2 || l.execute != 3 || l.memory != 4 || l.memDone != 6 || len(l.work[5]) != 0 {
+		t.Errorf("\"TestPipelineLayout()\" FAILED, 8-stage layout %+v", l)
+	}
+
+	invalid := []PipelineConfig{
+		{Name: "order", Slots: [][]Stage{{IF}, {IE}, {ID}, {MEM}, {WB}}},
+		{Name: "split", Slots: [][]Stage{{IF}, {ID}, {IF}, {IE}, {MEM}, {WB}}},
+		{Name: "missing", Slots: [][]Stage{{IF}, {ID}, {IE}, {MEM}}},
+		{Name: "empty", Slots: [][]Stage{{IF}, {}, {ID, IE, MEM, WB}}},
+		{Name: "wide", Slots: [][]Stage{{IF}, {ID}, {IE}, {MEM}, {WB}}, Width: 2},
+	}
+	for _, config := range invalid {
+		if _, err := newPipelineLayout(config); err == nil {
+			t.Errorf("\"TestPipelineLayout()\" FAILED, %s accepted", config.Name)
+		}
+	}
+
+}
+
+func TestPipelineConfigs(t *testing.T) {
+
+	//sum of array,load use in loop body
+	var program = []uint32{
+		0x10000513, //addi a0, x0, 0x100
+		0x00800313, //addi t1, x0, 8
+		0x00000593, //addi a1, x0, 0
+		0x00052283, //lw t0, 0(a0)
+		0x005585b3, //add a1, a1, t0
+		0x00450513, //addi a0, a0, 4
+		0xfff30313, //addi t1, t1, -1
+		0xfe0318e3, //bne t1, x0, 0x0c
+		0x00b52023, //sw a1, 0(a0)
+		0x00100613, //addi a2, x0, 1
+	}
+
+	run := func(config PipelineConfig) *Cpu {
+		cpu := Cpu{}
+		if err := cpu.SetPipeline(config); err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range program {
+			cpu.Ram.SetLine(uint32(i*4), v)
+		}
+		for i := uint32(0); i < 8; i++ {
+			cpu.Ram.SetLine(0x100+i*4, i+1)
+		}
+		for i := 0; i < 1000 && cpu.regFile.GetRegVal(12) == 0; i++ {
+			cpu.ClockCycle()
+		}
+		if cpu.regFile.GetRegVal(11) != 36 || cpu.Ram.GetLine(0x120) != 36 || cpu.Stats.Retired != 45 {
+			t.Errorf("\"TestPipelineConfigs()\" %s FAILED, a1 -> %d retired -> %d", config.Name,
+				cpu.regFile.GetRegVal(11), cpu.Stats.Retired)
+		}
+		t.Logf("%-12s cycles %4d cpi %.2f", config.Name, cpu.Stats.Cycles, cpu.Stats.CPI())
+		return &cpu
+	}
+
+	single := run(SINGLE_CYCLE)
+	multi := run(MULTI_CYCLE)
+	classic := run(CLASSIC_5)
+	deep7 := run(DEEP_7)
+	deep8 := run(DEEP_8)
+	dual := run(DUAL_ISSUE)
+
+	if single.Stats.Cycles != 45 || multi.Stats.Cycles != 5*45 {
+		t.Errorf("\"TestPipelineConfigs()\" FAILED, single cycle -> %d multi cycle -> %d",
+			single.Stats.Cycles, multi.Stats.Cycles)
+	}
+	//deeper pipeline pays more for load use and mispredict
+	if !(single.Stats.Cycles < classic.Stats.Cycles && classic.Stats.Cycles < deep7.Stats.Cycles &&
+		deep7.Stats.Cycles < deep8.Stats.Cycles && deep8.Stats.Cycles < multi.Stats.Cycles) {
+		t.Errorf("\"TestPipelineConfigs()\" FAILED, cycles %d %d %d", classic.Stats.Cycles,
+			deep7.Stats.Cycles, deep8.Stats.Cycles)
+	}
+	//loop pairs independent instructions,load use,two memory ops
+	//and dependent add keep it from ipc of 2.0
+	i := dual.Stats.Issue
+	t.Logf("dual issue ipc %.2f %+v", dual.Stats.IPC(), i)
+	if dual.Stats.Cycles >= classic.Stats.Cycles || i.Dual == 0 || i.Depend == 0 ||
+		i.Bundles != i.Dual+i.Leftover+i.Fetch+i.Serial+i.Memory+i.Branch+i.Depend {
+		t.Errorf("\"TestPipelineConfigs()\" dual issue FAILED, cycles %d vs %d, %+v",
+			dual.Stats.Cycles, classic.Stats.Cycles, i)
+	}
+	if deep7.RedirectPenalty() != 3 || multi.RedirectPenalty() != 0 || single.RedirectPenalty() != 0 {
+		t.Errorf("\"TestPipelineConfigs()\" FAILED, redirect penalty")
+	}
+
+}
+
+func TestDualIssuePairing(t *testing.T) {
+
+	var program = []uint32{
+		0x00100293, //addi t0, x0, 1
+		0x00200313, //addi t1, x0, 2
+		0x00300393, //addi t2, x0, 3
+		0x00400e13, //addi t3, x0, 4
+		0x006282b3, //add t0, t0, t1
+		0x005282b3, //add t0, t0, t0
+		0x10502023, //sw t0, 0x100(x0)
+		0x10002e83, //lw t4, 0x100(x0)
+		0x007e0e33, //add t3, t3, t2
+		0x01de0f33, //add t5, t3, t4
+		0x00100613, //addi a2, x0, 1
+	}
+
+	cpu := Cpu{}
+	if err := cpu.SetPipeline(DUAL_ISSUE); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range program {
+		cpu.Ram.SetLine(uint32(i*4), v)
+	}
+	for i := 0; i < 100 && cpu.regFile.GetRegVal(12) == 0; i++ {
+		cpu.ClockCycle()
+	}
+	i := cpu.Stats.Issue
+	if cpu.regFile.GetRegVal(30) != 13 || cpu.regFile.GetRegVal(5) != 6 {
+		t.Errorf("\"TestDualIssuePairing()\" FAILED, t0 -> %d t5 -> %d",
+			cpu.regFile.GetRegVal(5), cpu.regFile.GetRegVal(30))
+	}
+	//addi pairs,dependent adds,store and load split
+	if i.Dual != 2 || i.Depend != 2 || i.Memory != 1 || i.Leftover != 3 || i.Fetch != 1 {
+		t.Errorf("\"TestDualIssuePairing()\" FAILED, %+v", i)
+	}
+
+}
@@ -0,0 +1,165 @@
+package cpu
+
+const (
+	CSR_PMPCFG0  = 0x3A0
+	CSR_PMPADDR0 = 0x3B0
+	PMP_ENTRIES  = 16
+)
+
+// pmpcfg byte fields
+const (
+	PMP_R = 1 << 0
+	PMP_W = 1 << 1
+	PMP_X = 1 << 2
+	PMP_A = 0b11 << 3
+	PMP_L = 1 << 7
+)
+
+// address matching modes in PMP_A
+const (
+	PMP_OFF   = 0
+	PMP_TOR   = 1
+	PMP_NA4   = 2
+	PMP_NAPOT = 3
+)
+
+type pmpRegs struct {
+	cfg  [PMP_ENTRIES]uint8
+	addr [PMP_ENTRIES]uint64 //physical address bits 2 and up
+}
+
+func (p *pmpRegs) mode(i int) uint8 {
+	return p.cfg[i] & PMP_A >> 3
+}
+
+func (p *pmpRegs) locked(i int) bool {
+	return p.cfg[i]&PMP_L != 0
+}
+
+// byte range [lo,hi) covered by entry,false when entry is off
+func (p *pmpRegs) bounds(i int) (uint64, uint64, bool) {
+
+	addr := p.addr[i]
+	switch p.mode(i) {
+	case PMP_TOR:
+		var lo uint64
+		if i > 0 {
+			lo = p.addr[i-1] << 2
+		}
+		return lo, addr << 2, true
+	case PMP_NA4:
+		return addr << 2, addr<<2 + 4, true
+	case PMP_NAPOT:
+		//trailing ones of pmpaddr encode size
+		ones := uint64(0)
+		for addr>>ones&1 == 1 {
+			ones++
+		}
+		lo := addr &^ (1<<ones - 1) << 2
+		return lo, lo + 1<<(ones+3), true
+	}
+	return 0, 0, false
+}
+
+// lowest matching entry decides,access that only partially
+// matches it fails. m mode is checked only against locked entries.
+// s and u access matching no entry fails,even while every entry is off
+func (cpu *Cpu) pmpAllowed(pa uint64, size uint32, access AccessType, priv Privilege) bool {
+
+	p := &cpu.pmp
+	end := pa + uint64(size)
+	for i := 0; i < PMP_ENTRIES; i++ {
+		lo, hi, ok := p.bounds(i)
+		if !ok {
+			continue
+		}
+		if end <= lo || pa >= hi {
+			continue
+		}
+		if pa < lo || end > hi {
+			return false
+		}
+		if priv == PRIV_M && !p.locked(i) {
+			return true
+		}
+		perm := [...]uint8{PMP_X, PMP_R, PMP_W}[access]
+		return p.cfg[i]&perm != 0
+	}
+	return priv == PRIV_M
+}
+
+// pmpcfg registers pack XLEN/8 entries,rv64 only has even ones
+func (cpu *Cpu) registerPmpCsrs() {
+
+	p := &cpu.pmp
+	per := int(cpu.xlen() / 8)
+	for n := 0; n < PMP_ENTRIES/4; n++ {
+		if per == 8 && n%2 == 1 {
+			continue
+		}
+		first := n * 4
+		cpu.csrs[CSR_PMPCFG0+uint32(n)] = &Csr{
+			Read: func() uint64 {
+				var val uint64
+				for i := 0; i < per; i++ {
+					val |= uint64(p.cfg[first+i]) << (8 * i)
+				}
+				return val
+			},
+			Write: func(val uint64) {
+				for i := 0; i < per; i++ {
+					if p.locked(first + i) {
+						continue
+					}
+					p.cfg[first+i] = pmpCfg(uint8(val >> (8 * i)))
+				}
+			},
+		}
+	}
+
+	addrMask := cpu.pmpAddrMask()
+	for n := 0; n < PMP_ENTRIES; n++ {
+		i := n
+		cpu.csrs[CSR_PMPADDR0+uint32(i)] = &Csr{
+			Read: func() uint64 { return p.addr[i] },
+			Write: func(val uint64) {
+				//locked entry also freezes base of locked TOR above it
+				if p.locked(i) || (i+1 < PMP_ENTRIES && p.locked(i+1) && p.mode(i+1) == PMP_TOR) {
+					return
+				}
+				p.addr[i] = val & addrMask
+			},
+		}
+	}
+}
+
+// legal value of written pmpcfg byte
+func pmpCfg(cfg uint8) uint8 {
+	cfg &^= 0x60
+	//R=0 W=1 is reserved
+	if cfg&PMP_R == 0 {
+		cfg &^= PMP_W
+	}
+	return cfg
+}
+
+// rv32 holds address bits 33:2,rv64 bits 55:2
+func (cpu *Cpu) pmpAddrMask() uint64 {
+	if cpu.xlen() == 64 {
+		return uint64(1)<<54 - 1
+	}
+	return uint64(1)<<32 - 1
+}
+
+// boot state of entry set by machine profile,
+// fields are masked like csr writes
+func (cpu *Cpu) SetPmp(i int, cfg uint8, addr uint64) {
+	cpu.pmp.cfg[i] = pmpCfg(cfg)
+	cpu.pmp.addr[i] = addr & cpu.pmpAddrMask()
+}
+
+// rwx napot entry over whole address space in last entry,
+// what firmware programs before it drops to s or u mode
+func (cpu *Cpu) PmpAllowAll() {
+	cpu.SetPmp(PMP_ENTRIES-1, PMP_NAPOT<<3|PMP_R|PMP_W|PMP_X, ^uint64(0))
+}
@@ -0,0 +1,142 @@
+package cpu
+
+import (
+	"testing"
+)
+
+func TestPmpMatching(t *testing.T) {
+
+	cpu := Cpu{}
+	csrs := cpu.csrFile()
+	//no active entry,only m mode has access
+	if cpu.pmpAllowed(0x1000, 4, ACCESS_STORE, PRIV_U) || cpu.pmpAllowed(0x1000, 4, ACCESS_LOAD, PRIV_S) ||
+		!cpu.pmpAllowed(0x1000, 4, ACCESS_STORE, PRIV_M) {
+		t.Errorf("\"TestPmpMatching()\" FAILED, reset state allows s or u access")
+	}
+
+	//NAPOT 0x2000-0x2fff RX,NA4 0x3000 RW,TOR 0x3000-0x3fff R
+	csrs[CSR_PMPADDR0].Write(0x2000>>2 | 0x1FF)
+	csrs[CSR_PMPADDR0+1].Write(0x3000 >> 2)
+	csrs[CSR_PMPADDR0+2].Write(0x4000 >> 2)
+	csrs[CSR_PMPCFG0].Write((PMP_TOR<<3|PMP_R)<<16 | (PMP_NA4<<3|PMP_R|PMP_W)<<8 | PMP_NAPOT<<3 | PMP_R | PMP_X)
+
+	cases := []struct {
+		pa      uint64
+		size    uint32
+		access  AccessType
+		priv    Privilege
+		allowed bool
+	}{
+		{0x2000, 4, ACCESS_FETCH, PRIV_U, true},
+		{0x2ffc, 4, ACCESS_LOAD, PRIV_U, true},
+		{0x2ffc, 8, ACCESS_LOAD, PRIV_U, false}, //partial match
+		{0x2000, 4, ACCESS_STORE, PRIV_U, false},
+		{0x3000, 4, ACCESS_STORE, PRIV_S, true},
+		{0x3004, 4, ACCESS_STORE, PRIV_S, false},
+		{0x3ffc, 4, ACCESS_LOAD, PRIV_S, true},
+		{0x4000, 4, ACCESS_LOAD, PRIV_U, false}, //no match
+		{0x4000, 4, ACCESS_STORE, PRIV_M, true},
+		{0x2000, 4, ACCESS_STORE, PRIV_M, true}, //unlocked
+	}
+	for _, c := range cases {
+		if cpu.pmpAllowed(c.pa, c.size, c.access, c.priv) != c.allowed {
+			t.Errorf("\"TestPmpMatching()\" FAILED, %+v", c)
+		}
+	}
+
+	//W without R is reserved,locked TOR freezes address below it
+	csrs[CSR_PMPADDR0+3].Write(0x4000 >> 2)
+	csrs[CSR_PMPADDR0+4].Write(0x5000 >> 2)
+	csrs[CSR_PMPCFG0+1].Write(PMP_L | PMP_TOR<<3 | PMP_W)
+	csrs[CSR_PMPADDR0+3].Write(0)
+	csrs[CSR_PMPADDR0+4].Write(0)
+	csrs[CSR_PMPCFG0+1].Write(0)
+	if csrs[CSR_PMPCFG0+1].Read() != PMP_L|PMP_TOR<<3 || csrs[CSR_PMPADDR0+3].Read() != 0x4000>>2 ||
+		csrs[CSR_PMPADDR0+4].Read() != 0x5000>>2 {
+		t.Errorf("\"TestPmpMatching()\" lock FAILED, pmpcfg1 -> %x pmpaddr3 -> %x",
+			csrs[CSR_PMPCFG0+1].Read(), csrs[CSR_PMPADDR0+3].Read())
+	}
+	if cpu.pmpAllowed(0x4000, 4, ACCESS_LOAD, PRIV_M) {
+		t.Errorf("\"TestPmpMatching()\" FAILED, locked TOR entry allows m mode load")
+	}
+
+}
+
+func TestPmpExecution(t *testing.T) {
+
+	var machine = []uint32{
+		0x10000293, //addi t0, x0, 0x100
+		0x30529073, //csrrw x0, mtvec, t0
+		0x000012b7, //lui t0, 0x1
+		0x9ff28293, //addi t0, t0, -0x601
+		0x3b029073, //csrrw x0, pmpaddr0, t0
+		0x000012b7, //lui t0, 0x1
+		0xc0028293, //addi t0, t0, -0x400
+		0x3b129073, //csrrw x0, pmpaddr1, t0
+		0x000012b7, //lui t0, 0x1
+		0x3b229073, //csrrw x0, pmpaddr2, t0
+		0x000912b7, //lui t0, 0x91
+		0x31d28293, //addi t0, t0, 0x31d
+		0x3a029073, //csrrw x0, pmpcfg0, t0
+		0x000022b7, //lui t0, 0x2
+		0x34129073, //csrrw x0, mepc, t0
+		0x30200073, //mret
+	}
+	//user store fault,then lock 0x800 read only for m mode too
+	var handler = []uint32{
+		0x34202773, //csrrs a4, mcause, x0
+		0x343027f3, //csrrs a5, mtval, x0
+		0x18000293, //addi t0, x0, 0x180
+		0x30529073, //csrrw x0, mtvec, t0
+		0x20000293, //addi t0, x0, 0x200
+		0x3b329073, //csrrw x0, pmpaddr3, t0
+		0x910002b7, //lui t0, 0x91000
+		0x3a02a073, //csrrs x0, pmpcfg0, t0
+		0x40000313, //addi t1, x0, 0x400
+		0x00630333, //add t1, t1, t1
+		0x00032983, //lw s3, 0(t1)
+		0x00b32023, //sw a1, 0(t1)
+	}
+	var locked = []uint32{
+		0x34202873, //csrrs a6, mcause, x0
+		0x343028f3, //csrrs a7, mtval, x0
+		0x3a001073, //csrrw x0, pmpcfg0, x0
+		0x3b301073, //csrrw x0, pmpaddr3, x0
+		0x3a002973, //csrrs s2, pmpcfg0, x0
+		0x3b302a73, //csrrs s4, pmpaddr3, x0
+	}
+	var user = []uint32{
+		0x00003537, //lui a0, 0x3
+		0x00500593, //addi a1, x0, 5
+		0x00b52023, //sw a1, 0(a0)
+		0x00452603, //lw a2, 4(a0)
+		0x00b52223, //sw a1, 4(a0)
+	}
+
+	cpu := Cpu{}
+	for base, program := range map[uint32][]uint32{0: machine, 0x100: handler, 0x180: locked, 0x2000: user} {
+		for i, v := range program {
+			cpu.Ram.SetLine(base+uint32(i*4), v)
+		}
+	}
+	cpu.Ram.SetLine(0x800, 0x1234)
+	cpu.Ram.SetLine(0x3004, 77)
+	for i := 0; i < 1000; i++ {
+		cpu.ClockCycle()
+	}
+
+	if cpu.Ram.GetLine(0x3000) != 5 || cpu.regFile.GetRegVal(12) != 77 || cpu.Ram.GetLine(0x3004) != 77 ||
+		cpu.regFile.GetRegVal(14) != CAUSE_STORE_ACCESS || cpu.regFile.GetRegVal(15) != 0x3004 {
+		t.Errorf("\"TestPmpExecution()\" user FAILED, a2 -> %d mcause -> %d mtval -> %x",
+			cpu.regFile.GetRegVal(12), cpu.regFile.GetRegVal(14), cpu.regFile.GetRegVal(15))
+	}
+	//locked entry applies to m mode and ignores further writes
+	if cpu.regFile.GetRegVal(19) != 0x1234 || cpu.Ram.GetLine(0x800) != 0x1234 ||
+		cpu.regFile.GetRegVal(16) != CAUSE_STORE_ACCESS || cpu.regFile.GetRegVal(17) != 0x800 ||
+		cpu.regFile.GetRegVal(18) != 0x9100_0000 || cpu.regFile.GetRegVal(20) != 0x200 {
+		t.Errorf("\"TestPmpExecution()\" lock FAILED, s3 -> %x mcause -> %d mtval -> %x pmpcfg0 -> %x pmpaddr3 -> %x",
+			cpu.regFile.GetRegVal(19), cpu.regFile.GetRegVal(16), cpu.regFile.GetRegVal(17),
+			cpu.regFile.GetRegVal(18), cpu.regFile.GetRegVal(20))
+	}
+
+}
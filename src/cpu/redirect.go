@@ -0,0 +1,116 @@
+package cpu
+
+type RedirectCause uint8
+
+const (
+	REDIRECT_MISPREDICT RedirectCause = iota
+	REDIRECT_TRAP
+	REDIRECT_SERIAL //xRET,FENCE.I and SFENCE.VMA refetch what follows them
+)
+
+// front end redirect,execute stage computes it and
+// it is applied at end of cycle after all stages latched
+type Redirect struct {
+	Pc    uint64
+	Priv  Privilege //privilege of instructions fetched from Pc
+	Cause RedirectCause
+}
+
+type RedirectStats struct {
+	Mispredicts uint64
+	Traps       uint64
+	Serial      uint64
+	Squashed    uint64 //wrong path instructions removed in front of execute
+	Penalty     uint64 //fetch cycles lost,see RedirectPenalty
+}
+
+// resolves bundle that just left execute oldest first,
+// redirect of older lane squashes younger lanes with front end
+func (cpu *Cpu) resolveBundle() {
+
+	bundle := cpu.instStorage[cpu.pipeline().execute]
+	for lane, inst := range bundle {
+		if inst == nil {
+			continue
+		}
+		r := cpu.resolveExecuted(inst)
+		if r == nil {
+			continue
+		}
+		if inst.trap != nil {
+			bundle[lane] = nil
+		}
+		for i := lane + 1; i < len(bundle); i++ {
+			cpu.squash(bundle[i], r)
+			bundle[i] = nil
+		}
+		cpu.redirectFront(r)
+		return
+	}
+}
+
+func (s *RedirectStats) record(cause RedirectCause) {
+	switch cause {
+	case REDIRECT_MISPREDICT:
+		s.Mispredicts++
+	case REDIRECT_TRAP:
+		s.Traps++
+	case REDIRECT_SERIAL:
+		s.Serial++
+	}
+}
+
+// redirect requested by instruction that just left execute,
+// nil if fetch already follows correct path
+func (cpu *Cpu) resolveExecuted(inst *Instruction) *Redirect {
+
+	if inst.trap != nil {
+		return cpu.takeTrap(inst)
+	}
+	if inst.redirect != nil {
+		cpu.syncFetch(inst)
+		return inst.redirect
+	}
+	if cpu.predictor().resolve(inst) {
+		return &Redirect{Pc: inst.nextPc(), Priv: cpu.priv, Cause: REDIRECT_MISPREDICT}
+	}
+	return nil
+}
+
+// squash slots in front of execute and continue from redirect pc
+func (cpu *Cpu) redirectFront(r *Redirect) {
+
+	s := &cpu.Stats.Redirects
+	s.record(r.Cause)
+	s.Penalty += cpu.RedirectPenalty()
+	for _, bundle := range cpu.instStorage[:cpu.pipeline().execute] {
+		for lane, inst := range bundle {
+			cpu.squash(inst, r)
+			bundle[lane] = nil
+		}
+	}
+
+	cpu.interlock = false
+	cpu.fetchNext = nil
+	cpu.fetchWait = 0
+	cpu.priv = r.Priv
+	cpu.pc = r.Pc
+}
+
+// FENCE.I invalidates instruction cache when its redirect is applied,
+// stage goroutines are done then and fetch refills from memory
+func (cpu *Cpu) syncFetch(inst *Instruction) {
+	if inst.opcode == MISC_MEM && inst.funct3 == 0x1 && cpu.ICache != nil {
+		cpu.ICache.Invalidate()
+	}
+}
+
+func (cpu *Cpu) squash(inst *Instruction, r *Redirect) {
+	if inst == nil {
+		return
+	}
+	cpu.Stats.Redirects.Squashed++
+	if r.Cause == REDIRECT_MISPREDICT {
+		cpu.predictor().Stats.Squashed++
+	}
+}
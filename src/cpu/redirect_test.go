@@ -0,0 +1,60 @@
+package cpu
+
+import (
+	"testing"
+)
+
+func TestRedirectPenalty(t *testing.T) {
+
+	var straight = []uint32{
+		0x00100513, //addi a0, x0, 1
+		0x00000013, //nop
+		0x00200593, //addi a1, x0, 2
+	}
+	//jal is unknown to btb,so it is mispredicted
+	var jump = []uint32{
+		0x00100513, //addi a0, x0, 1
+		0x0080006f, //jal x0, 0xc
+		0x06300513, //addi a0, x0, 99
+		0x00200593, //addi a1, x0, 2
+	}
+	var fencei = []uint32{
+		0x00100513, //addi a0, x0, 1
+		0x0000100f, //fence.i
+		0x00200593, //addi a1, x0, 2
+		0x00000013, //nop
+	}
+
+	run := func(program []uint32) *Cpu {
+		cpu := Cpu{}
+		for i, v := range program {
+			cpu.Ram.SetLine(uint32(i*4), v)
+		}
+		for i := 0; i < 100 && cpu.regFile.GetRegVal(11) == 0; i++ {
+			cpu.ClockCycle()
+		}
+		if cpu.regFile.GetRegVal(10) != 1 || cpu.regFile.GetRegVal(11) != 2 {
+			t.Errorf("\"TestRedirectPenalty()\" FAILED, a0 -> %d a1 -> %d", cpu.regFile.GetRegVal(10), cpu.regFile.GetRegVal(11))
+		}
+		return &cpu
+	}
+
+	base := run(straight)
+	jumped := run(jump)
+	fenced := run(fencei)
+	if base.Stats.Redirects != (RedirectStats{}) {
+		t.Errorf("\"TestRedirectPenalty()\" FAILED, straight line code redirected %+v", base.Stats.Redirects)
+	}
+	r := jumped.Stats.Redirects
+	if jumped.Stats.Cycles-base.Stats.Cycles != jumped.RedirectPenalty() || r.Mispredicts != 1 ||
+		r.Squashed != 2 || r.Penalty != 2 {
+		t.Errorf("\"TestRedirectPenalty()\" mispredict FAILED, cycles %d vs %d, %+v",
+			jumped.Stats.Cycles, base.Stats.Cycles, r)
+	}
+	r = fenced.Stats.Redirects
+	if fenced.Stats.Cycles-base.Stats.Cycles != 2 || r.Serial != 1 || r.Squashed != 2 {
+		t.Errorf("\"TestRedirectPenalty()\" fence.i FAILED, cycles %d vs %d, %+v",
+			fenced.Stats.Cycles, base.Stats.Cycles, r)
+	}
+
+}
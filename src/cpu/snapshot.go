@@ -0,0 +1,623 @@
+package cpu
+
+import (
+	"Go_emu/src/ram"
+	"Go_emu/src/register"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// snapshot file starts with magic and format version,
+// loader rejects other versions
+const SNAPSHOT_VERSION = 2
+
+// longest slice or string loader accepts,guards against corrupt file
+const SNAPSHOT_MAX_LEN = 1 << 28
+
+var snapshotMagic = [8]byte{'G', 'O', 'E', 'M', 'U', 'S', 'N', 'P'}
+
+// part of machine whose state goes into snapshot,
+// same method saves and loads so field lists can't diverge
+type Snapper interface {
+	Snap(s *Snapshot)
+}
+
+// little endian stream of machine state.
+// while saving values are written from given pointers,
+// while loading they are read into them
+type Snapshot struct {
+	w      io.Writer
+	r      io.Reader
+	err    error
+	saved  map[*Instruction]uint32 //instruction held in two places is written once
+	loaded []*Instruction
+}
+
+func SaveSnapshot(w io.Writer, parts ...Snapper) error {
+
+	s := &Snapshot{w: w, saved: map[*Instruction]uint32{}}
+	magic := snapshotMagic
+	version := uint32(SNAPSHOT_VERSION)
+	s.Value(&magic)
+	s.Value(&version)
+	for _, p := range parts {
+		p.Snap(s)
+	}
+	return s.err
+}
+
+// parts must be built like saved ones,
+// hart configuration is restored from snapshot
+func LoadSnapshot(r io.Reader, parts ...Snapper) error {
+
+	s := &Snapshot{r: r}
+	var magic [8]byte
+	var version uint32
+	s.Value(&magic)
+	s.Value(&version)
+	switch {
+	case s.err != nil:
+		return s.err
+	case magic != snapshotMagic:
+		return fmt.Errorf("snapshot: bad magic %q", magic[:])
+	case version != SNAPSHOT_VERSION:
+		return fmt.Errorf("snapshot: version %d,supported version is %d", version, SNAPSHOT_VERSION)
+	}
+	for _, p := range parts {
+		p.Snap(s)
+	}
+	//rest of file belongs to parts not given
+	if s.err == nil {
+		if n, _ := r.Read(make([]byte, 1)); n != 0 {
+			s.Fail("data left after last part,saved machine had more parts")
+		}
+	}
+	return s.err
+}
+
+func (s *Snapshot) Loading() bool {
+	return s.r != nil
+}
+
+// first error,stream is not touched after it
+func (s *Snapshot) Err() error {
+	return s.err
+}
+
+func (s *Snapshot) Fail(format string, args ...any) {
+	if s.err == nil {
+		s.err = fmt.Errorf("snapshot: "+format, args...)
+	}
+}
+
+// fixed size value,integer of any kind,bool,array
+// or struct of exported fixed size fields
+func (s *Snapshot) Value(v any) {
+	if s.err != nil {
+		return
+	}
+	var err error
+	if s.Loading() {
+		err = binary.Read(s.r, binary.LittleEndian, v)
+	} else {
+		err = binary.Write(s.w, binary.LittleEndian, v)
+	}
+	if err != nil {
+		s.Fail("%v", err)
+	}
+}
+
+func (s *Snapshot) Int(v *int) {
+	x := int64(*v)
+	s.Value(&x)
+	*v = int(x)
+}
+
+// writes n,returns saved length while loading
+func (s *Snapshot) Len(n int) int {
+	x := uint64(n)
+	s.Value(&x)
+	if s.err != nil || x > SNAPSHOT_MAX_LEN {
+		s.Fail("length %d too large", x)
+		return 0
+	}
+	return int(x)
+}
+
+func (s *Snapshot) Bytes(v *[]byte) {
+	n := s.Len(len(*v))
+	if s.Loading() {
+		*v = make([]byte, n)
+	}
+	if n > 0 {
+		s.Value(*v)
+	}
+}
+
+func (s *Snapshot) Uint64s(v *[]uint64) {
+	n := s.Len(len(*v))
+	if s.Loading() {
+		*v = make([]uint64, n)
+	}
+	if n > 0 {
+		s.Value(*v)
+	}
+}
+
+func (s *Snapshot) String(v *string) {
+	b := []byte(*v)
+	s.Bytes(&b)
+	*v = string(b)
+}
+
+// name of next part,loading fails early when
+// snapshot was saved by differently built machine
+func (s *Snapshot) Section(name string) {
+	saved := name
+	s.String(&saved)
+	if s.err == nil && saved != name {
+		s.Fail("expected section %s,found %s", name, saved)
+	}
+}
+
+// counters of map,keys sorted so same state gives same bytes
+func (s *Snapshot) Counts(v *map[string]uint64) {
+	keys := make([]string, 0, len(*v))
+	for k := range *v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	n := s.Len(len(keys))
+	if s.Loading() {
+		keys = make([]string, n)
+		*v = nil
+		if n > 0 {
+			*v = make(map[string]uint64, n)
+		}
+	}
+	for _, k := range keys {
+		val := (*v)[k]
+		s.String(&k)
+		s.Value(&val)
+		if s.Loading() && s.err == nil {
+			(*v)[k] = val
+		}
+	}
+}
+
+// optional value behind pointer,returns false for nil.
+// loading always allocates,nothing is shared with old state
+func snapOptional[T any](s *Snapshot, p **T) bool {
+	present := *p != nil
+	s.Value(&present)
+	if s.Loading() {
+		*p = nil
+		if present && s.err == nil {
+			*p = new(T)
+		}
+	}
+	return present && s.err == nil
+}
+
+// instruction in flight,pointer is kept shared
+// so leftover still is half of its bundle after load
+func (s *Snapshot) inst(p **Instruction) {
+
+	if !s.Loading() {
+		id, ok := s.saved[*p]
+		if *p != nil && !ok {
+			id = uint32(len(s.saved) + 1)
+			s.saved[*p] = id
+		}
+		s.Value(&id)
+		if *p != nil && !ok {
+			(*p).snap(s)
+		}
+		return
+	}
+
+	var id uint32
+	s.Value(&id)
+	switch {
+	case s.err != nil || id == 0:
+		*p = nil
+	case int(id) <= len(s.loaded):
+		*p = s.loaded[id-1]
+	case int(id) == len(s.loaded)+1:
+		*p = &Instruction{}
+		s.loaded = append(s.loaded, *p)
+		(*p).snap(s)
+	default:
+		s.Fail("instruction %d referenced before it was saved", id)
+	}
+}
+
+func (inst *Instruction) snap(s *Snapshot) {
+
+	for _, v := range []any{&inst.instype, &inst.romline, &inst.funct7, &inst.rs2, &inst.rs1, &inst.rd,
+		&inst.funct3, &inst.opcode, &inst.imm, &inst.stage, &inst.rs2_index, &inst.rs1_index, &inst.pc,
+		&inst.priv, &inst.predicted, &inst.kind, &inst.taken, &inst.target, &inst.vaddr, &inst.history} {
+		s.Value(v)
+	}
+	s.Int(&inst.forwarded[0])
+	s.Int(&inst.forwarded[1])
+	if snapOptional(s, &inst.memop) {
+		m := inst.memop
+		for _, v := range []any{&m.optype, &m.data, &m.address, &m.data_mask, &m.latency,
+			&m.accessLatency, &m.amo, &m.signed} {
+			s.Value(v)
+		}
+	}
+	if snapOptional(s, &inst.wbop) {
+		inst.wbop.snap(s)
+	}
+	if snapOptional(s, &inst.csrWrite) {
+		inst.csrWrite.snap(s)
+	}
+	if snapOptional(s, &inst.vecop) {
+		op := inst.vecop
+		for _, v := range []any{&op.vd, &op.vs1, &op.vs2, &op.funct6, &op.masked, &op.load, &op.store,
+			&op.eew, &op.stride, &op.vl, &op.started, &op.busy} {
+			s.Value(v)
+		}
+		s.Uint64s(&op.paddr)
+	}
+	if snapOptional(s, &inst.trap) {
+		s.Value(&inst.trap.cause)
+		s.Value(&inst.trap.tval)
+	}
+	if snapOptional(s, &inst.redirect) {
+		s.Value(inst.redirect)
+	}
+}
+
+func (w *Wbops) snap(s *Snapshot) {
+	s.Value(&w.data)
+	s.Value(&w.dest)
+}
+
+// whole hart,pipeline configuration,caches,tlbs and predictor
+// are rebuilt when loading. csrs registered by board and
+// attached trace,commit log and lockstep are kept
+func (cpu *Cpu) Snap(s *Snapshot) {
+
+	s.Section("hart")
+	s.Value(&cpu.Xlen)
+	embedded := cpu.regFile.Size() == 16
+	s.Value(&embedded)
+	if s.Loading() {
+		cpu.regFile = register.RegisterFile{}
+		cpu.regFile.SetEmbedded(embedded)
+	}
+	for i := uint32(1); i < 32; i++ {
+		v := cpu.regFile.GetRegVal(i)
+		s.Value(&v)
+		cpu.regFile.SetRegVal(i, v)
+	}
+	s.Value(&cpu.pc)
+	s.Value(&cpu.priv)
+	s.Value(&cpu.powered)
+
+	cpu.snapPipeline(s)
+	if s.err != nil {
+		return
+	}
+
+	s.Section("memory")
+	size := cpu.Ram.Size()
+	s.Value(&size)
+	if s.Loading() && size != cpu.Ram.Size() {
+		if size > SNAPSHOT_MAX_LEN {
+			s.Fail("ram of %d bytes too large", size)
+			return
+		}
+		cpu.Ram = ram.NewRam(uint32(size))
+	}
+	lines := make([]uint32, size/4)
+	for i := range lines {
+		lines[i] = cpu.Ram.GetLine(uint32(i * 4))
+	}
+	s.Value(lines)
+	for i, v := range lines {
+		cpu.Ram.SetLine(uint32(i*4), v)
+	}
+
+	s.Section("csrs")
+	t := &cpu.traps
+	for _, r := range []*uint64{&t.mstatus, &t.medeleg, &t.mideleg, &t.mie, &t.mip, &t.mtvec, &t.mscratch,
+		&t.mepc, &t.mcause, &t.mtval, &t.mseccfg, &t.mcounten, &t.menvcfg, &t.scounten, &t.senvcfg,
+		&t.stvec, &t.sscratch, &t.sepc, &t.scause, &t.stval, &t.satp} {
+		s.Value(r)
+	}
+	s.Value(&cpu.pmp.cfg)
+	s.Value(&cpu.pmp.addr)
+	s.Value(&cpu.reserved.valid)
+	s.Value(&cpu.reserved.address)
+	state, _ := cpu.entropy().pcg.MarshalBinary()
+	s.Bytes(&state)
+	if s.Loading() && s.err == nil {
+		cpu.Entropy = NewEntropySource(0)
+		if err := cpu.Entropy.pcg.UnmarshalBinary(state); err != nil {
+			s.Fail("entropy source: %v", err)
+		}
+	}
+	if snapOptional(s, &cpu.Vector) {
+		v := cpu.Vector
+		for _, f := range []any{&v.VLEN, &v.ELEN, &v.ExecCycles, &v.Lanes, &v.Stats, &v.vl, &v.vtype} {
+			s.Value(f)
+		}
+		s.Bytes(&v.regs)
+	}
+
+	s.Section("memory system")
+	snapTlb(s, &cpu.ITlb)
+	snapTlb(s, &cpu.DTlb)
+	cpu.snapCaches(s)
+	s.Value(&cpu.RamWait)
+	s.Value(&cpu.SinglePort)
+	s.Value(&cpu.portFree)
+	s.Value(&cpu.fetchWait)
+	s.inst(&cpu.fetchNext)
+	s.Value(&cpu.memWait)
+	snapPredictor(s, &cpu.Predictor)
+
+	s.Section("statistics")
+	st := &cpu.Stats
+	for _, v := range []any{&st.Cycles, &st.Retired, &st.Redirects, &st.Issue, &st.Bus, &st.LoadUse} {
+		s.Value(v)
+	}
+	s.Counts(&st.Forwarded)
+	s.Counts(&st.Types)
+	s.Counts(&st.Mnemonics)
+}
+
+// layout and slot latches with their bundles
+func (cpu *Cpu) snapPipeline(s *Snapshot) {
+
+	s.Section("pipeline")
+	config := cpu.pipeline().config
+	s.String(&config.Name)
+	slots := make([][]Stage, s.Len(len(config.Slots)))
+	for i := range slots {
+		if !s.Loading() {
+			slots[i] = config.Slots[i]
+		}
+		stages := make([]Stage, s.Len(len(slots[i])))
+		copy(stages, slots[i])
+		for j := range stages {
+			s.Value(&stages[j])
+		}
+		slots[i] = stages
+	}
+	config.Slots = slots
+	s.Value(&config.Pipelined)
+	s.Int(&config.Width)
+	if s.Loading() && s.err == nil {
+		if err := cpu.SetPipeline(config); err != nil {
+			s.Fail("%v", err)
+			return
+		}
+	}
+	for _, bundle := range cpu.instStorage {
+		for lane := range bundle {
+			s.inst(&bundle[lane])
+		}
+	}
+	s.inst(&cpu.leftover)
+	s.Value(&cpu.interlock)
+	s.Value(&cpu.loadUse)
+}
+
+func snapTlb(s *Snapshot, p **Tlb) {
+
+	if !snapOptional(s, p) {
+		return
+	}
+	tlb := *p
+	s.Value(&tlb.HitLatency)
+	s.Value(&tlb.MissLatency)
+	s.Value(&tlb.Stats)
+	if n := s.Len(len(tlb.entries)); s.Loading() {
+		tlb.entries = make([]*tlbEntry, n)
+	}
+	for i := range tlb.entries {
+		if e := &tlb.entries[i]; snapOptional(s, e) {
+			for _, v := range []any{&(*e).vpn, &(*e).asid, &(*e).super, &(*e).ppn, &(*e).pte, &(*e).pteAddr} {
+				s.Value(v)
+			}
+		}
+	}
+	s.Int(&tlb.next)
+}
+
+// l1 caches and unified l2 behind both,as SetCaches builds them
+func (cpu *Cpu) snapCaches(s *Snapshot) {
+
+	var l2 *Cache
+	if cpu.ICache != nil {
+		l2 = cpu.ICache.Next
+	} else if cpu.DCache != nil {
+		l2 = cpu.DCache.Next
+	}
+	for _, c := range []*Cache{cpu.ICache, cpu.DCache} {
+		if !s.Loading() && c != nil && (c.Next != l2 || (l2 != nil && l2.Next != nil)) {
+			s.Fail("only caches built by SetCaches can be saved")
+			return
+		}
+	}
+	for _, c := range []**Cache{&cpu.ICache, &cpu.DCache, &l2} {
+		if !snapOptional(s, c) {
+			continue
+		}
+		cache := *c
+		s.Value(&cache.Config)
+		s.Value(&cache.Stats)
+		s.Value(&cache.time)
+		s.Value(&cache.seed)
+		if n := s.Len(len(cache.sets)); s.Loading() {
+			cache.sets = make([][]cacheLine, n)
+		}
+		for i := range cache.sets {
+			if n := s.Len(len(cache.sets[i])); s.Loading() {
+				cache.sets[i] = make([]cacheLine, n)
+			}
+			for j := range cache.sets[i] {
+				l := &cache.sets[i][j]
+				for _, v := range []any{&l.valid, &l.dirty, &l.tag, &l.used, &l.filled} {
+					s.Value(v)
+				}
+			}
+		}
+	}
+	if s.Loading() {
+		for _, c := range []*Cache{cpu.ICache, cpu.DCache} {
+			if c != nil {
+				c.Next = l2
+			}
+		}
+	}
+}
+
+// direction predictor is saved by name,
+// only predictors of this package are known
+func snapPredictor(s *Snapshot, p **BranchPredictor) {
+
+	if !snapOptional(s, p) {
+		return
+	}
+	bp := *p
+	name := ""
+	if bp.Direction != nil {
+		name = bp.Direction.Name()
+	}
+	s.String(&name)
+	if s.Loading() {
+		switch name {
+		case "":
+			bp.Direction = nil
+		case StaticNotTaken{}.Name():
+			bp.Direction = StaticNotTaken{}
+		case Btfn{}.Name():
+			bp.Direction = Btfn{}
+		case (&Bimodal{}).Name():
+			bp.Direction = &Bimodal{}
+		case (&Gshare{}).Name():
+			bp.Direction = &Gshare{}
+		default:
+			s.Fail("unknown direction predictor %s", name)
+			return
+		}
+	}
+	switch d := bp.Direction.(type) {
+	case *Bimodal:
+		s.Bytes(&d.counters)
+	case *Gshare:
+		s.Bytes(&d.counters)
+		s.Value(&d.history)
+		s.Value(&d.historyBits)
+	case StaticNotTaken, Btfn, nil:
+	default:
+		s.Fail("unknown direction predictor %s", name)
+		return
+	}
+
+	if snapOptional(s, &bp.Btb) {
+		if n := s.Len(len(bp.Btb.entries)); s.Loading() {
+			bp.Btb.entries = make([]btbEntry, n)
+		}
+		for i := range bp.Btb.entries {
+			e := &bp.Btb.entries[i]
+			for _, v := range []any{&e.valid, &e.pc, &e.target, &e.kind} {
+				s.Value(v)
+			}
+		}
+	}
+	if snapOptional(s, &bp.Ras) {
+		s.Int(&bp.Ras.Depth)
+		s.Uint64s(&bp.Ras.stack)
+	}
+	s.Value(&bp.Stats)
+}
+
+// reorder buffer entries are numbered,committed producers
+// that waiting operands still point to are saved too
+func (o *OutOfOrder) Snap(s *Snapshot) {
+
+	o.cpu.Snap(s)
+	s.Section("out-of-order")
+	for _, v := range []*int{&o.Config.Width, &o.Config.RobSize, &o.Config.RsSize, &o.Config.LsqSize} {
+		s.Int(v)
+	}
+	s.Value(&o.Stats)
+
+	var entries []*oooEntry
+	ids := map[*oooEntry]int{}
+	add := func(e *oooEntry) {
+		if e != nil && ids[e] == 0 {
+			entries = append(entries, e)
+			ids[e] = len(entries)
+		}
+	}
+	for _, e := range o.rob {
+		add(e)
+	}
+	for _, e := range o.rob {
+		add(e.src[0])
+		add(e.src[1])
+	}
+	for _, e := range o.rat {
+		add(e)
+	}
+	n := s.Len(len(entries))
+	robLen := s.Len(len(o.rob))
+	if s.Loading() {
+		if robLen > n {
+			s.Fail("reorder buffer of %d entries out of %d", robLen, n)
+			return
+		}
+		entries = make([]*oooEntry, n)
+		for i := range entries {
+			entries[i] = &oooEntry{}
+		}
+		o.rob = append([]*oooEntry(nil), entries[:robLen]...)
+	}
+	ref := func(p **oooEntry) {
+		id := ids[*p]
+		s.Int(&id)
+		if s.Loading() {
+			switch {
+			case id < 0 || id > len(entries):
+				s.Fail("reorder buffer entry %d out of %d", id, len(entries))
+			case id == 0:
+				*p = nil
+			default:
+				*p = entries[id-1]
+			}
+		}
+	}
+	for _, e := range entries {
+		s.inst(&e.inst)
+		s.Value(&e.dest)
+		ref(&e.src[0])
+		ref(&e.src[1])
+		for _, v := range []any{&e.serial, &e.atHead, &e.issued, &e.done, &e.latency} {
+			s.Value(v)
+		}
+	}
+	for i := range o.rat {
+		ref(&o.rat[i])
+	}
+
+	if n := s.Len(len(o.fetchQueue)); s.Loading() {
+		o.fetchQueue = make([]*Instruction, n)
+	}
+	for i := range o.fetchQueue {
+		s.inst(&o.fetchQueue[i])
+	}
+	s.inst(&o.fetchNext)
+	s.Value(&o.fetchWait)
+	s.Value(&o.fetchStop)
+	s.Value(&o.commitWait)
+}
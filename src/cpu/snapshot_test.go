@@ -0,0 +1,85 @@
+package cpu
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+
+	newCores := map[string]func() Core{
+		"ooo": func() Core { return NewOutOfOrder(&Cpu{}, DEFAULT_OOO) },
+		"caches": func() Core {
+			cpu := &Cpu{SinglePort: true, RamWait: WaitStates{Read: 1, Write: 2}}
+			l2 := DEFAULT_L2
+			cpu.SetCaches(DEFAULT_L1I, DEFAULT_L1D, &l2)
+			cpu.Predictor = NewBranchPredictor(NewGshare(64, 6), 16, 4)
+			return cpu
+		},
+	}
+	for name, config := range Pipelines {
+		newCores[name] = func() Core {
+			cpu := &Cpu{}
+			cpu.SetPipeline(config)
+			return cpu
+		}
+	}
+
+	for name, newCore := range newCores {
+		c := newCore()
+		c.Arch().LoadRom("test_roms/TestRomExecution_rom")
+		for i := 0; i < 3000; i++ {
+			c.ClockCycle()
+		}
+		checkpoint := bytes.Buffer{}
+		if err := SaveSnapshot(&checkpoint, c); err != nil {
+			t.Fatalf("\"TestSnapshot()\" %s FAILED, %v", name, err)
+		}
+		for i := 0; i < 3000; i++ {
+			c.ClockCycle()
+		}
+		want := bytes.Buffer{}
+		SaveSnapshot(&want, c)
+
+		//configuration comes from snapshot
+		var restored Core = &Cpu{}
+		if _, ok := c.(*OutOfOrder); ok {
+			restored = NewOutOfOrder(&Cpu{}, OooConfig{})
+		}
+		if err := LoadSnapshot(bytes.NewReader(checkpoint.Bytes()), restored); err != nil {
+			t.Fatalf("\"TestSnapshot()\" %s FAILED, %v", name, err)
+		}
+		for i := 0; i < 3000; i++ {
+			restored.ClockCycle()
+		}
+		got := bytes.Buffer{}
+		SaveSnapshot(&got, restored)
+		if !bytes.Equal(got.Bytes(), want.Bytes()) || restored.Arch().Stats.Retired < 1000 {
+			t.Errorf("\"TestSnapshot()\" %s FAILED, resumed run differs %v retired %d",
+				name, c.Arch().ArchDiff(restored.Arch()), restored.Arch().Stats.Retired)
+		}
+	}
+
+	inorder, ooo := bytes.Buffer{}, bytes.Buffer{}
+	SaveSnapshot(&inorder, &Cpu{})
+	SaveSnapshot(&ooo, NewOutOfOrder(&Cpu{}, DEFAULT_OOO))
+	old := append([]byte(nil), inorder.Bytes()...)
+	old[8] = SNAPSHOT_VERSION + 1
+	for _, c := range []struct {
+		data []byte
+		core Core
+		err  string
+	}{
+		{old, &Cpu{}, fmt.Sprintf("version %d", SNAPSHOT_VERSION+1)},
+		{[]byte("GOEMU"), &Cpu{}, "EOF"},
+		{inorder.Bytes(), NewOutOfOrder(&Cpu{}, DEFAULT_OOO), "EOF"},
+		{ooo.Bytes(), &Cpu{}, "data left"},
+	} {
+		if err := LoadSnapshot(bytes.NewReader(c.data), c.core); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("\"TestSnapshot()\" FAILED, expected error %s got %v", c.err, err)
+		}
+	}
+
+}
@@ -0,0 +1,490 @@
+package cpu
+
+import (
+	"fmt"
+)
+
+// privilege levels,encoded like mstatus.MPP
+type Privilege uint8
+
+const (
+	PRIV_U Privilege = 0
+	PRIV_S Privilege = 1
+	PRIV_M Privilege = 3
+)
+
+// exception causes (xcause with interrupt bit clear)
+const (
+	CAUSE_FETCH_ACCESS     = 1
+	CAUSE_ILLEGAL_INST     = 2
+	CAUSE_BREAKPOINT       = 3
+	CAUSE_LOAD_ACCESS      = 5
+	CAUSE_STORE_ACCESS     = 7
+	CAUSE_ECALL_U          = 8
+	CAUSE_ECALL_S          = 9
+	CAUSE_ECALL_M          = 11
+	CAUSE_FETCH_PAGE_FAULT = 12
+	CAUSE_LOAD_PAGE_FAULT  = 13
+	CAUSE_STORE_PAGE_FAULT = 15
+)
+
+// interrupt causes,also bit positions in mip/mie
+const (
+	IRQ_S_SOFT  = 1
+	IRQ_M_SOFT  = 3
+	IRQ_S_TIMER = 5
+	IRQ_M_TIMER = 7
+	IRQ_S_EXT   = 9
+	IRQ_M_EXT   = 11
+)
+
+const (
+	CSR_SSTATUS  = 0x100
+	CSR_SIE      = 0x104
+	CSR_STVEC    = 0x105
+	CSR_SSCRATCH = 0x140
+	CSR_SEPC     = 0x141
+	CSR_SCAUSE   = 0x142
+	CSR_STVAL    = 0x143
+	CSR_SIP      = 0x144
+	CSR_SENVCFG  = 0x10A
+	CSR_SCOUNTEN = 0x106
+	CSR_SATP     = 0x180
+	CSR_MSTATUS  = 0x300
+	CSR_MISA     = 0x301
+	CSR_MEDELEG  = 0x302
+	CSR_MIDELEG  = 0x303
+	CSR_MIE      = 0x304
+	CSR_MTVEC    = 0x305
+	CSR_MCOUNTEN = 0x306
+	CSR_MENVCFG  = 0x30A
+	CSR_MSCRATCH = 0x340
+	CSR_MEPC     = 0x341
+	CSR_MCAUSE   = 0x342
+	CSR_MTVAL    = 0x343
+	CSR_MIP      = 0x344
+	CSR_MSECCFG  = 0x747
+	CSR_MVENDOR  = 0xF11
+	CSR_MARCHID  = 0xF12
+	CSR_MIMPID   = 0xF13
+	CSR_MHARTID  = 0xF14
+)
+
+// mstatus fields
+const (
+	MSTATUS_SIE  = 1 << 1
+	MSTATUS_MIE  = 1 << 3
+	MSTATUS_SPIE = 1 << 5
+	MSTATUS_MPIE = 1 << 7
+	MSTATUS_SPP  = 1 << 8
+	MSTATUS_MPP  = 0b11 << 11
+	MSTATUS_MPRV = 1 << 17
+	MSTATUS_SUM  = 1 << 18
+	MSTATUS_MXR  = 1 << 19
+	MSTATUS_TVM  = 1 << 20
+	MSTATUS_TW   = 1 << 21
+	MSTATUS_TSR  = 1 << 22
+)
+
+// mstatus bits visible through sstatus
+const SSTATUS_MASK = MSTATUS_SIE | MSTATUS_SPIE | MSTATUS_SPP | MSTATUS_SUM | MSTATUS_MXR
+
+// mseccfg seed access enables for lower privileges
+const (
+	MSECCFG_USEED = 1 << 8
+	MSECCFG_SSEED = 1 << 9
+)
+
+// SYSTEM funct3 000 immediates
+const (
+	ECALL  = 0x000
+	EBREAK = 0x001
+	SRET   = 0x102
+	WFI    = 0x105
+	MRET   = 0x302
+)
+
+type Trap struct {
+	cause uint64
+	tval  uint64
+}
+
+// machine and supervisor trap state,
+// sstatus/sie/sip are views of m registers
+type trapRegs struct {
+	mstatus  uint64
+	medeleg  uint64
+	mideleg  uint64
+	mie      uint64
+	mip      uint64
+	mtvec    uint64
+	mscratch uint64
+	mepc     uint64
+	mcause   uint64
+	mtval    uint64
+	mseccfg  uint64
+	mcounten uint64
+	menvcfg  uint64
+	scounten uint64
+	senvcfg  uint64
+	stvec    uint64
+	sscratch uint64
+	sepc     uint64
+	scause   uint64
+	stval    uint64
+	satp     uint64
+}
+
+// exception raised in pipeline,handled when instruction leaves execute
+func (inst *Instruction) raise(cause uint64, tval uint64) {
+	inst.trap = &Trap{cause: cause, tval: tval}
+	inst.wbop = nil
+	inst.memop = nil
+}
+
+// hart resets into machine mode
+func (cpu *Cpu) powerOn() {
+	if !cpu.powered {
+		cpu.powered = true
+		cpu.priv = PRIV_M
+		//ram,tlbs and predictor are shared by stage goroutines
+		cpu.Ram.Size()
+		cpu.itlb()
+		cpu.dtlb()
+		cpu.predictor()
+		cpu.pipeline()
+	}
+}
+
+// current privilege level of fetched instructions
+func (cpu *Cpu) Privilege() Privilege {
+	cpu.powerOn()
+	return cpu.priv
+}
+
+// misa with MXL and single letter extensions
+func (cpu *Cpu) misa() uint64 {
+
+	ext := func(letter byte) uint64 { return 1 << (letter - 'A') }
+
+	misa := ext('M') | ext('A') | ext('S') | ext('U')
+	if cpu.regFile.Size() == 16 {
+		misa |= ext('E')
+	} else {
+		misa |= ext('I')
+	}
+	if cpu.Vector != nil {
+		misa |= ext('V')
+	}
+	if cpu.xlen() == 64 {
+		return misa | 2<<62
+	}
+	return misa | 1<<30
+}
+
+// riscv,isa string of hart as used in device tree,
+// single letter extensions in canonical order then z extensions
+func (cpu *Cpu) IsaString() string {
+
+	misa := cpu.misa()
+	isa := fmt.Sprintf("rv%d", cpu.xlen())
+	for _, letter := range "iemafdcv" {
+		if misa&(1<<(letter-'a')) != 0 {
+			isa += string(letter)
+		}
+	}
+	isa += "_zicsr_zifencei"
+	if cpu.xlen() == 32 {
+		isa += "_zkne_zknd_zknh_zkr"
+	}
+	return isa
+}
+
+// switches register file to rv32e/rv64e, only x0-x15 exist
+func (cpu *Cpu) SetEmbedded(embedded bool) {
+	cpu.regFile.SetEmbedded(embedded)
+}
+
+// sets or clears interrupt pending bit driven by platform device
+func (cpu *Cpu) SetInterruptPending(irq uint32, pending bool) {
+	if pending {
+		cpu.traps.mip |= 1 << irq
+	} else {
+		cpu.traps.mip &^= 1 << irq
+	}
+}
+
+// mip as seen by software
+func (cpu *Cpu) InterruptsPending() uint64 {
+	return cpu.traps.mip
+}
+
+func (cpu *Cpu) registerTrapCsrs() {
+
+	reg := func(address uint32, field *uint64, mask uint64) {
+		cpu.csrs[address] = &Csr{
+			Read:  func() uint64 { return *field },
+			Write: func(val uint64) { *field = *field&^mask | val&mask },
+		}
+	}
+	//view of masked bits of m register
+	view := func(address uint32, field *uint64, mask func() uint64, writable uint64) {
+		cpu.csrs[address] = &Csr{
+			Read: func() uint64 { return *field & mask() },
+			Write: func(val uint64) {
+				m := mask() & writable
+				*field = *field&^m | val&m
+			},
+		}
+	}
+	t := &cpu.traps
+	sip := uint64(1<<IRQ_S_SOFT | 1<<IRQ_S_TIMER | 1<<IRQ_S_EXT)
+	mstatus := uint64(SSTATUS_MASK | MSTATUS_MIE | MSTATUS_MPIE | MSTATUS_MPP |
+		MSTATUS_MPRV | MSTATUS_TVM | MSTATUS_TW | MSTATUS_TSR)
+
+	reg(CSR_MSTATUS, &t.mstatus, mstatus)
+	//MPP is WARL,reserved hypervisor mode keeps previous value
+	writeMstatus := cpu.csrs[CSR_MSTATUS].Write
+	cpu.csrs[CSR_MSTATUS].Write = func(val uint64) {
+		if val&MSTATUS_MPP>>11 == 2 {
+			val = val&^MSTATUS_MPP | t.mstatus&MSTATUS_MPP
+		}
+		writeMstatus(val)
+	}
+	//ecall from m mode is never delegated
+	reg(CSR_MEDELEG, &t.medeleg, 0xFFFF&^(1<<CAUSE_ECALL_M))
+	reg(CSR_MIDELEG, &t.mideleg, sip)
+	reg(CSR_MIE, &t.mie, 0xAAA)
+	//machine interrupts are pending only while device drives them
+	reg(CSR_MIP, &t.mip, sip)
+	reg(CSR_MTVEC, &t.mtvec, ^uint64(0b10))
+	reg(CSR_MSCRATCH, &t.mscratch, ^uint64(0))
+	reg(CSR_MEPC, &t.mepc, ^uint64(0b1))
+	reg(CSR_MCAUSE, &t.mcause, ^uint64(0))
+	reg(CSR_MTVAL, &t.mtval, ^uint64(0))
+	reg(CSR_MSECCFG, &t.mseccfg, MSECCFG_USEED|MSECCFG_SSEED)
+	//counter enables and envcfg only hold values for firmware
+	reg(CSR_MCOUNTEN, &t.mcounten, 0xFFFF_FFFF)
+	reg(CSR_MENVCFG, &t.menvcfg, 0)
+	reg(CSR_SCOUNTEN, &t.scounten, 0xFFFF_FFFF)
+	reg(CSR_SENVCFG, &t.senvcfg, 0)
+
+	view(CSR_SSTATUS, &t.mstatus, func() uint64 { return SSTATUS_MASK }, ^uint64(0))
+	view(CSR_SIE, &t.mie, func() uint64 { return t.mideleg }, ^uint64(0))
+	view(CSR_SIP, &t.mip, func() uint64 { return t.mideleg }, 1<<IRQ_S_SOFT)
+	reg(CSR_STVEC, &t.stvec, ^uint64(0b10))
+	reg(CSR_SSCRATCH, &t.sscratch, ^uint64(0))
+	reg(CSR_SEPC, &t.sepc, ^uint64(0b1))
+	reg(CSR_SCAUSE, &t.scause, ^uint64(0))
+	reg(CSR_STVAL, &t.stval, ^uint64(0))
+
+	//only bare and sv32 modes are accepted,others leave satp unchanged
+	cpu.csrs[CSR_SATP] = &Csr{
+		Read: func() uint64 { return t.satp },
+		Write: func(val uint64) {
+			if cpu.xlen() == 32 || val>>60 == 0 {
+				t.satp = val
+			}
+		},
+	}
+
+	//misa is WARL,extensions cannot be switched at runtime
+	cpu.csrs[CSR_MISA] = &Csr{
+		Read:  cpu.misa,
+		Write: func(uint64) {},
+	}
+	for _, id := range []uint32{CSR_MVENDOR, CSR_MARCHID, CSR_MIMPID, CSR_MHARTID} {
+		cpu.csrs[id] = &Csr{
+			Read: func() uint64 { return 0 },
+		}
+	}
+}
+
+// csr address encodes lowest privilege and read only bits,
+// some registers are further restricted by mstatus/mseccfg
+func (cpu *Cpu) csrAllowed(address uint32, priv Privilege, write bool) bool {
+
+	if Privilege(address>>8&0b11) > priv {
+		return false
+	}
+	if write && address>>10 == 0b11 {
+		return false
+	}
+	switch address {
+	case CSR_SATP:
+		return priv == PRIV_M || cpu.traps.mstatus&MSTATUS_TVM == 0
+	case CSR_SEED:
+		switch priv {
+		case PRIV_U:
+			return cpu.traps.mseccfg&MSECCFG_USEED != 0
+		case PRIV_S:
+			return cpu.traps.mseccfg&MSECCFG_SSEED != 0
+		}
+	}
+	return true
+}
+
+// ECALL,EBREAK,SRET,MRET,WFI,SFENCE.VMA
+func (cpu *Cpu) systemInst(inst *Instruction) {
+
+	if inst.imm>>5 == SFENCE_VMA && inst.rd == 0 {
+		cpu.sfenceVma(inst)
+		return
+	}
+	t := &cpu.traps
+	switch inst.imm {
+	case ECALL:
+		inst.raise(CAUSE_ECALL_U+uint64(inst.priv), 0)
+	case EBREAK:
+		inst.raise(CAUSE_BREAKPOINT, inst.pc)
+	case MRET:
+		if inst.priv != PRIV_M {
+			inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline))
+			return
+		}
+		//MIE <- MPIE, MPIE <- 1, privilege <- MPP, MPP <- U
+		priv := Privilege(t.mstatus & MSTATUS_MPP >> 11)
+		t.mstatus &^= MSTATUS_MIE
+		if t.mstatus&MSTATUS_MPIE != 0 {
+			t.mstatus |= MSTATUS_MIE
+		}
+		t.mstatus |= MSTATUS_MPIE
+		t.mstatus &^= MSTATUS_MPP
+		if priv != PRIV_M {
+			t.mstatus &^= MSTATUS_MPRV
+		}
+		inst.redirect = &Redirect{Pc: t.mepc, Priv: priv, Cause: REDIRECT_SERIAL}
+	case SRET:
+		if inst.priv == PRIV_U || (inst.priv == PRIV_S && t.mstatus&MSTATUS_TSR != 0) {
+			inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline))
+			return
+		}
+		//SIE <- SPIE, SPIE <- 1, privilege <- SPP, SPP <- U
+		priv := Privilege(t.mstatus & MSTATUS_SPP >> 8)
+		t.mstatus &^= MSTATUS_SIE
+		if t.mstatus&MSTATUS_SPIE != 0 {
+			t.mstatus |= MSTATUS_SIE
+		}
+		t.mstatus |= MSTATUS_SPIE
+		t.mstatus &^= MSTATUS_SPP | MSTATUS_MPRV
+		inst.redirect = &Redirect{Pc: t.sepc, Priv: priv, Cause: REDIRECT_SERIAL}
+	case WFI:
+		//no low power state,pending interrupt is taken on next instruction
+		if inst.priv == PRIV_U || (inst.priv == PRIV_S && t.mstatus&MSTATUS_TW != 0) {
+			inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline))
+		}
+	default:
+		inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline))
+	}
+}
+
+// highest priority interrupt that can be taken at privilege,
+// returns false if none
+func (cpu *Cpu) pendingInterrupt(priv Privilege) (uint64, bool) {
+
+	t := &cpu.traps
+	pending := t.mip & t.mie
+	if pending == 0 {
+		return 0, false
+	}
+	mEnabled := priv < PRIV_M || t.mstatus&MSTATUS_MIE != 0
+	sEnabled := priv < PRIV_S || (priv == PRIV_S && t.mstatus&MSTATUS_SIE != 0)
+
+	for _, irq := range []uint64{IRQ_M_EXT, IRQ_M_SOFT, IRQ_M_TIMER, IRQ_S_EXT, IRQ_S_SOFT, IRQ_S_TIMER} {
+		if pending&(1<<irq) == 0 {
+			continue
+		}
+		if t.mideleg&(1<<irq) == 0 && mEnabled || t.mideleg&(1<<irq) != 0 && sEnabled {
+			return irq, true
+		}
+	}
+	return 0, false
+}
+
+// interrupt is taken on instruction about to execute,
+// it does not execute and its pc is saved as xepc
+func (cpu *Cpu) checkInterrupts(inst *Instruction) {
+
+	if inst == nil || inst.trap != nil {
+		return
+	}
+	if irq, ok := cpu.pendingInterrupt(inst.priv); ok {
+		inst.trap = &Trap{cause: 1<<(cpu.xlen()-1) | irq}
+	}
+}
+
+// enter trap handler for instruction that just left execute stage,
+// returns redirect of front end to handler.
+// trap goes to s mode if delegated and raised below m mode
+func (cpu *Cpu) takeTrap(inst *Instruction) *Redirect {
+
+	t := &cpu.traps
+	interrupt := inst.trap.cause>>(cpu.xlen()-1) != 0
+	code := inst.trap.cause &^ (1 << (cpu.xlen() - 1))
+	deleg := t.medeleg
+	if interrupt {
+		deleg = t.mideleg
+	}
+
+	var tvec uint64
+	var priv Privilege
+	if inst.priv <= PRIV_S && deleg&(1<<code) != 0 {
+		t.sepc = inst.pc
+		t.scause = inst.trap.cause
+		t.stval = inst.trap.tval
+		//SPIE <- SIE, SIE <- 0, SPP <- privilege
+		t.mstatus &^= MSTATUS_SPIE | MSTATUS_SPP
+		if t.mstatus&MSTATUS_SIE != 0 {
+			t.mstatus |= MSTATUS_SPIE
+		}
+		t.mstatus &^= MSTATUS_SIE
+		t.mstatus |= uint64(inst.priv) << 8
+		priv = PRIV_S
+		tvec = t.stvec
+	} else {
+		t.mepc = inst.pc
+		t.mcause = inst.trap.cause
+		t.mtval = inst.trap.tval
+		//MPIE <- MIE, MIE <- 0, MPP <- privilege
+		t.mstatus &^= MSTATUS_MPIE | MSTATUS_MPP
+		if t.mstatus&MSTATUS_MIE != 0 {
+			t.mstatus |= MSTATUS_MPIE
+		}
+		t.mstatus &^= MSTATUS_MIE
+		t.mstatus |= uint64(inst.priv) << 11
+		priv = PRIV_M
+		tvec = t.mtvec
+	}
+
+	pc := tvec &^ 0b11
+	//vectored mode only for interrupts
+	if tvec&0b1 != 0 && interrupt {
+		pc += 4 * code
+	}
+	return &Redirect{Pc: pc, Priv: priv, Cause: REDIRECT_TRAP}
+}
+
+// illegal use of x16-x31 in embedded mode
+func (cpu *Cpu) checkRegisters(inst *Instruction) {
+
+	if cpu.regFile.Size() == 32 {
+		return
+	}
+	used := []uint32{inst.rs1_index, inst.rs2_index, inst.rd}
+	//csrrwi,csrrsi,csrrci hold immediate in rs1 field
+	if inst.opcode == SYSTEM && inst.funct3 >= 5 {
+		used = used[1:]
+	}
+	//vector register numbers are not x registers,
+	//only vset* and vmv.x.s have scalar destination
+	if inst.instype == V && !(inst.opcode == OPV && (inst.funct3 == OPCFG ||
+		(inst.funct3 == OPMVV && inst.vecop.funct6 == 0b010000))) {
+		used = used[:2]
+	}
+	for _, r := range used {
+		if !cpu.regFile.Exists(r) {
+			inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline))
+			return
+		}
+	}
+}
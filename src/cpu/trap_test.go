@@ -0,0 +1,264 @@
+package cpu
+
+import (
+	"testing"
+)
+
+func TestEmbeddedIllegalRegister(t *testing.T) {
+
+	var program = map[uint32]uint32{
+		0x00: 0x04000293, //addi t0, x0, 0x40
+		0x04: 0x30529073, //csrrw x0, mtvec, t0
+		0x08: 0x301024f3, //csrrs s1, misa, x0
+		0x0c: 0x00100813, //addi a6, x0, 1
+		0x10: 0x06300513, //addi a0, x0, 99
+		0x40: 0x34202373, //csrrs t1, mcause, x0
+		0x44: 0x343023f3, //csrrs t2, mtval, x0
+		0x48: 0x34102473, //csrrs s0, mepc, x0
+		0x4c: 0x00440413, //addi s0, s0, 4
+		0x50: 0x34141073, //csrrw x0, mepc, s0
+		0x54: 0x30200073, //mret
+	}
+
+	cpu := Cpu{}
+	cpu.SetEmbedded(true)
+	for address, v := range program {
+		cpu.Ram.SetLine(address, v)
+	}
+	for i := 0; i < 1000; i++ {
+		cpu.ClockCycle()
+	}
+
+	//rv32e misa -> MXL 1 with E,M and A
+	if cpu.regFile.GetRegVal(9) != 0x4014_1011 {
+		t.Errorf("\"TestEmbeddedIllegalRegister()\" FAILED, misa -> %x", cpu.regFile.GetRegVal(9))
+	}
+	if cpu.regFile.GetRegVal(6) != CAUSE_ILLEGAL_INST ||
+		cpu.regFile.GetRegVal(7) != 0x00100813 ||
+		cpu.regFile.GetRegVal(8) != 0x10 ||
+		cpu.regFile.GetRegVal(16) != 0 ||
+		cpu.regFile.GetRegVal(10) != 99 {
+		t.Errorf("\"TestEmbeddedIllegalRegister()\" FAILED, mcause -> %d mtval -> %x mepc -> %x a0 -> %d",
+			cpu.regFile.GetRegVal(6), cpu.regFile.GetRegVal(7), cpu.regFile.GetRegVal(8), cpu.regFile.GetRegVal(10))
+	}
+
+	//same program on rv32i never traps
+	cpu = Cpu{}
+	for address, v := range program {
+		cpu.Ram.SetLine(address, v)
+	}
+	for i := 0; i < 1000; i++ {
+		cpu.ClockCycle()
+	}
+	if cpu.regFile.GetRegVal(9) != 0x4014_1101 || cpu.regFile.GetRegVal(16) != 1 || cpu.regFile.GetRegVal(6) != 0 {
+		t.Errorf("\"TestEmbeddedIllegalRegister()\" FAILED, rv32i misa -> %x a6 -> %d", cpu.regFile.GetRegVal(9), cpu.regFile.GetRegVal(16))
+	}
+
+}
+
+func TestEmbeddedCsrImmediate(t *testing.T) {
+
+	var program = []uint32{
+		0x340fd073, //csrrwi x0, mscratch, 31
+		0x340024f3, //csrrs s1, mscratch, x0
+	}
+
+	//uimm in rs1 field is not register number
+	cpu := Cpu{}
+	cpu.SetEmbedded(true)
+	for i, v := range program {
+		cpu.Ram.SetLine(uint32(i*4), v)
+	}
+	for i := 0; i < 20; i++ {
+		cpu.ClockCycle()
+	}
+	if cpu.regFile.GetRegVal(9) != 31 || cpu.traps.mcause != 0 {
+		t.Errorf("\"TestEmbeddedCsrImmediate()\" FAILED, mscratch -> %d mcause -> %d", cpu.regFile.GetRegVal(9), cpu.traps.mcause)
+	}
+
+}
+
+func TestPrivilegeTransitions(t *testing.T) {
+
+	var program = map[uint32]uint32{
+		0x00:  0x10000293, //addi t0, x0, 0x100
+		0x04:  0x30529073, //csrrw x0, mtvec, t0
+		0x08:  0x20000293, //addi t0, x0, 0x200
+		0x0c:  0x10529073, //csrrw x0, stvec, t0
+		0x10:  0x10000293, //addi t0, x0, 0x100
+		0x14:  0x30229073, //csrrw x0, medeleg, t0
+		0x18:  0x04000293, //addi t0, x0, 0x40
+		0x1c:  0x34129073, //csrrw x0, mepc, t0
+		0x20:  0x000012b7, //lui t0, 0x1
+		0x24:  0x80028293, //addi t0, t0, -0x800
+		0x28:  0x3002a073, //csrrs x0, mstatus, t0
+		0x2c:  0x30200073, //mret
+		0x40:  0x08000293, //addi t0, x0, 0x80
+		0x44:  0x14129073, //csrrw x0, sepc, t0
+		0x48:  0x10200073, //sret
+		0x80:  0x00500813, //addi a6, x0, 5
+		0x84:  0x00000073, //ecall
+		0x100: 0x34202773, //csrrs a4, mcause, x0
+		0x104: 0x300027f3, //csrrs a5, mstatus, x0
+		0x108: 0x343028f3, //csrrs a7, mtval, x0
+		0x200: 0x142025f3, //csrrs a1, scause, x0
+		0x204: 0x14102673, //csrrs a2, sepc, x0
+		0x208: 0x10002373, //csrrs t1, sstatus, x0
+		0x20c: 0x300026f3, //csrrs a3, mstatus, x0
+	}
+
+	cpu := Cpu{}
+	cpu.PmpAllowAll()
+	for address, v := range program {
+		cpu.Ram.SetLine(address, v)
+	}
+	for i := 0; i < 1000; i++ {
+		cpu.ClockCycle()
+	}
+
+	//m -> mret -> s -> sret -> u -> ecall delegated to s
+	if cpu.regFile.GetRegVal(16) != 5 || cpu.regFile.GetRegVal(11) != CAUSE_ECALL_U ||
+		cpu.regFile.GetRegVal(12) != 0x84 || cpu.regFile.GetRegVal(6)&MSTATUS_SPP != 0 {
+		t.Errorf("\"TestPrivilegeTransitions()\" FAILED, a6 -> %d scause -> %d sepc -> %x sstatus -> %x",
+			cpu.regFile.GetRegVal(16), cpu.regFile.GetRegVal(11), cpu.regFile.GetRegVal(12), cpu.regFile.GetRegVal(6))
+	}
+	//mstatus from s mode is illegal and goes to m with MPP = S
+	if cpu.regFile.GetRegVal(13) != 0 || cpu.regFile.GetRegVal(14) != CAUSE_ILLEGAL_INST ||
+		cpu.regFile.GetRegVal(15)&MSTATUS_MPP>>11 != uint64(PRIV_S) ||
+		cpu.regFile.GetRegVal(17) != 0x300026f3 || cpu.Privilege() != PRIV_M {
+		t.Errorf("\"TestPrivilegeTransitions()\" FAILED, mcause -> %d mstatus -> %x mtval -> %x",
+			cpu.regFile.GetRegVal(14), cpu.regFile.GetRegVal(15), cpu.regFile.GetRegVal(17))
+	}
+
+	//firmware that never programs pmp can't run s mode code
+	cpu = Cpu{}
+	for address, v := range program {
+		cpu.Ram.SetLine(address, v)
+	}
+	for i := 0; i < 1000; i++ {
+		cpu.ClockCycle()
+	}
+	if cpu.regFile.GetRegVal(14) != CAUSE_FETCH_ACCESS || cpu.regFile.GetRegVal(17) != 0x40 || cpu.regFile.GetRegVal(16) != 0 {
+		t.Errorf("\"TestPrivilegeTransitions()\" without pmp FAILED, mcause -> %d mtval -> %x",
+			cpu.regFile.GetRegVal(14), cpu.regFile.GetRegVal(17))
+	}
+
+}
+
+func TestMstatusMpp(t *testing.T) {
+
+	var program = []uint32{
+		0x000012b7, //lui t0, 0x1
+		0x80028293, //addi t0, t0, -0x800
+		0x30029073, //csrrw x0, mstatus, t0
+		0x30002573, //csrrs a0, mstatus, x0
+		0x00129313, //slli t1, t0, 1
+		0x30031073, //csrrw x0, mstatus, t1
+		0x300025f3, //csrrs a1, mstatus, x0
+		0x0062e3b3, //or t2, t0, t1
+		0x30039073, //csrrw x0, mstatus, t2
+		0x30002673, //csrrs a2, mstatus, x0
+	}
+
+	cpu := Cpu{}
+	for i, v := range program {
+		cpu.Ram.SetLine(uint32(i*4), v)
+	}
+	for i := 0; i < 100; i++ {
+		cpu.ClockCycle()
+	}
+
+	//MPP = 2 is reserved,write keeps S
+	mpp := func(reg uint32) uint64 { return cpu.regFile.GetRegVal(reg) & MSTATUS_MPP >> 11 }
+	if mpp(10) != uint64(PRIV_S) || mpp(11) != uint64(PRIV_S) || mpp(12) != uint64(PRIV_M) {
+		t.Errorf("\"TestMstatusMpp()\" FAILED, mpp -> %d %d %d", mpp(10), mpp(11), mpp(12))
+	}
+
+}
+
+func TestSupervisorInterrupt(t *testing.T) {
+
+	var program = map[uint32]uint32{
+		0x00:  0x20000293, //addi t0, x0, 0x200
+		0x04:  0x10529073, //csrrw x0, stvec, t0
+		0x08:  0x00200293, //addi t0, x0, 2
+		0x0c:  0x30329073, //csrrw x0, mideleg, t0
+		0x10:  0x30429073, //csrrw x0, mie, t0
+		0x14:  0x34429073, //csrrw x0, mip, t0
+		0x18:  0x08000293, //addi t0, x0, 0x80
+		0x1c:  0x34129073, //csrrw x0, mepc, t0
+		0x20:  0x30200073, //mret
+		0x80:  0x00500813, //addi a6, x0, 5
+		0x200: 0x142025f3, //csrrs a1, scause, x0
+		0x204: 0x14102673, //csrrs a2, sepc, x0
+		0x208: 0x144026f3, //csrrs a3, sip, x0
+	}
+
+	cpu := Cpu{}
+	cpu.PmpAllowAll()
+	for address, v := range program {
+		cpu.Ram.SetLine(address, v)
+	}
+	for i := 0; i < 1000; i++ {
+		cpu.ClockCycle()
+	}
+
+	//pending ssip waits in m mode and is taken on first u mode instruction
+	if cpu.regFile.GetRegVal(16) != 0 || cpu.regFile.GetRegVal(11) != 1<<31|IRQ_S_SOFT ||
+		cpu.regFile.GetRegVal(12) != 0x80 || cpu.regFile.GetRegVal(13) != 1<<IRQ_S_SOFT ||
+		cpu.Privilege() != PRIV_S {
+		t.Errorf("\"TestSupervisorInterrupt()\" FAILED, a6 -> %d scause -> %x sepc -> %x sip -> %x",
+			cpu.regFile.GetRegVal(16), cpu.regFile.GetRegVal(11), cpu.regFile.GetRegVal(12), cpu.regFile.GetRegVal(13))
+	}
+
+}
+
+func TestReservedMemOp(t *testing.T) {
+
+	var program = map[uint32]uint32{
+		0x00: 0x04000293, //addi t0, x0, 0x40
+		0x04: 0x30529073, //csrrw x0, mtvec, t0
+		0x08: 0x20000913, //addi s2, x0, 0x200
+		0x0c: 0xfff00313, //addi t1, x0, -1
+		0x10: iType(0x100, 0, 7, 15, 0b0000011),
+		0x14: sType(0x100, 6, 0, 4),
+		0x18: sType(0x100, 6, 0, 5),
+		0x1c: sType(0x100, 6, 0, 6),
+		0x20: sType(0x100, 6, 0, 7),
+		0x24: 0x0000006f, //jal x0, 0
+		0x40: 0x343023f3, //csrrs t2, mtval, x0
+		0x44: 0x00792023, //sw t2, 0(s2)
+		0x48: 0x00490913, //addi s2, s2, 4
+		0x4c: 0x34102473, //csrrs s0, mepc, x0
+		0x50: 0x00440413, //addi s0, s0, 4
+		0x54: 0x34141073, //csrrw x0, mepc, s0
+		0x58: 0x30200073, //mret
+	}
+
+	//load funct3 7 and store funct3 4-7 are reserved
+	for _, name := range fuzzCores() {
+		var core Core = &Cpu{}
+		if name == "ooo" {
+			core = NewOutOfOrder(&Cpu{}, DEFAULT_OOO)
+		} else {
+			core.Arch().SetPipeline(Pipelines[name])
+		}
+		cpu := core.Arch()
+		for address, v := range program {
+			cpu.Ram.SetLine(address, v)
+		}
+		l, _ := NewLockstep(cpu)
+		for i := 0; i < 500; i++ {
+			core.ClockCycle()
+		}
+		for i := uint32(0); i < 5; i++ {
+			if got := cpu.Ram.GetLine(0x200 + i*4); got != program[0x10+i*4] {
+				t.Errorf("\"TestReservedMemOp()\" FAILED on %s, trap %d mtval -> %08x", name, i, got)
+			}
+		}
+		if cpu.Ram.GetLine(0x100) != 0 || cpu.regFile.GetRegVal(15) != 0 || l.Err() != nil {
+			t.Errorf("\"TestReservedMemOp()\" FAILED on %s, memory -> %#x a5 -> %#x %v", name, cpu.Ram.GetLine(0x100), cpu.regFile.GetRegVal(15), l.Err())
+		}
+	}
+
+}
@@ -0,0 +1,270 @@
+package cpu
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+const (
+	TRAVEL_INTERVAL = 1000   //cycles between snapshots,stepping back replays at most this many
+	TRAVEL_HISTORY  = 100000 //cycles kept,older records and snapshots are dropped
+)
+
+type RegisterWrite struct {
+	Index uint32
+	Old   uint64
+	New   uint64
+}
+
+// store reaching memory or device,old value of device
+// register is not read because reads have side effects
+type MemoryWrite struct {
+	Address uint64 //physical
+	Size    uint32
+	Old     uint64
+	New     uint64
+	Pc      uint64 //storing instruction
+	Device  bool
+	Walker  bool //page table A/D update,Pc is not set
+}
+
+// instruction latched in pipeline slot at end of cycle
+type SlotPc struct {
+	Slot int
+	Pc   uint64
+}
+
+// changes made by one cycle,old values undo them
+type CycleRecord struct {
+	Cycle    uint64 //Stats.Cycles after cycle
+	Regs     []RegisterWrite
+	Writes   []MemoryWrite
+	Retired  []uint64 //pc of retired instructions
+	Pipeline []SlotPc //in-order pipeline only
+}
+
+type travelPoint struct {
+	cycle uint64
+	data  []byte
+}
+
+// reverse execution of core. every cycle is recorded,
+// going back restores latest snapshot before target cycle
+// and replays forward to it,so whole core state is exact
+type TimeTravel struct {
+	Interval  uint64
+	History   uint64
+	core      Core
+	snapshots []travelPoint //oldest first
+	records   []CycleRecord //consecutive cycles after oldest snapshot
+	pending   CycleRecord
+	err       error //snapshot failure,recording stopped at it
+}
+
+// starts recording at current cycle.
+// logs written while running can't be rewound,so trace,commit log,
+// lockstep and recording or replaying input log must not be attached
+func NewTimeTravel(core Core) (*TimeTravel, error) {
+
+	cpu := core.Arch()
+	if cpu.Trace != nil || cpu.CommitLog != nil || cpu.Lockstep != nil || cpu.Input != nil && !cpu.Input.rewindable() {
+		return nil, fmt.Errorf("time travel can't rewind attached trace,commit log,lockstep or input log")
+	}
+	t := &TimeTravel{Interval: TRAVEL_INTERVAL, History: TRAVEL_HISTORY, core: core}
+	if err := t.snapshot(); err != nil {
+		return nil, err
+	}
+	cpu.Travel = t
+	return t, nil
+}
+
+// snapshot failure that stopped recording,nil while recording
+func (t *TimeTravel) Err() error {
+	return t.err
+}
+
+func (t *TimeTravel) Cycle() uint64 {
+	return t.core.Arch().Stats.Cycles
+}
+
+// earliest cycle Seek can go back to
+func (t *TimeTravel) Oldest() uint64 {
+	return t.snapshots[0].cycle
+}
+
+func (t *TimeTravel) snapshot() error {
+	buf := bytes.Buffer{}
+	if err := SaveSnapshot(&buf, t.core); err != nil {
+		return err
+	}
+	t.snapshots = append(t.snapshots, travelPoint{cycle: t.Cycle(), data: buf.Bytes()})
+	return nil
+}
+
+func (t *TimeTravel) registerWrite(cpu *Cpu, w *Wbops) {
+	if w.dest != 0 {
+		t.pending.Regs = append(t.pending.Regs, RegisterWrite{Index: w.dest, Old: cpu.regFile.GetRegVal(w.dest), New: w.data})
+	}
+}
+
+func (t *TimeTravel) retire(inst *Instruction) {
+	t.pending.Retired = append(t.pending.Retired, inst.pc)
+}
+
+func (t *TimeTravel) write(cpu *Cpu, inst *Instruction, pa uint64, size uint32, val uint64) {
+	w := MemoryWrite{Address: pa, Size: size, New: val & (^uint64(0) >> (64 - size*8)), Walker: inst == nil}
+	if inst != nil {
+		w.Pc = inst.pc
+	}
+	if cpu.cacheable(pa, size) {
+		w.Old = cpu.readPhys(pa, size)
+	} else {
+		w.Device = true
+	}
+	t.pending.Writes = append(t.pending.Writes, w)
+}
+
+// closes record of cycle,snapshot every Interval cycles
+func (t *TimeTravel) endCycle(cpu *Cpu) {
+
+	r := t.pending
+	t.pending = CycleRecord{}
+	r.Cycle = cpu.Stats.Cycles
+	for slot, bundle := range cpu.instStorage {
+		for _, inst := range bundle {
+			if inst != nil {
+				r.Pipeline = append(r.Pipeline, SlotPc{Slot: slot, Pc: inst.pc})
+			}
+		}
+	}
+	t.records = append(t.records, r)
+
+	if r.Cycle%max(t.Interval, 1) != 0 {
+		return
+	}
+	//core was saved once by NewTimeTravel,it saves again.
+	//without snapshot older cycles can't be replayed,recording stops
+	if err := t.snapshot(); err != nil {
+		t.err = fmt.Errorf("time travel stopped at cycle %d: %v", r.Cycle, err)
+		cpu.Travel = nil
+		return
+	}
+	for len(t.snapshots) > 1 && t.snapshots[1].cycle+t.History <= r.Cycle {
+		t.snapshots = t.snapshots[1:]
+	}
+	t.trim()
+}
+
+// drops records of cycles before oldest snapshot and after current one
+func (t *TimeTravel) trim() {
+	first := sort.Search(len(t.records), func(i int) bool { return t.records[i].Cycle > t.Oldest() })
+	last := sort.Search(len(t.records), func(i int) bool { return t.records[i].Cycle > t.Cycle() })
+	t.records = append([]CycleRecord(nil), t.records[first:last]...)
+}
+
+// record of cycle,nil if it is not kept
+func (t *TimeTravel) Record(cycle uint64) *CycleRecord {
+	if len(t.records) == 0 || cycle < t.records[0].Cycle || cycle > t.records[len(t.records)-1].Cycle {
+		return nil
+	}
+	return &t.records[cycle-t.records[0].Cycle]
+}
+
+// moves core to state after given cycle,
+// forward by running and back by snapshot and replay
+func (t *TimeTravel) Seek(cycle uint64) error {
+
+	if t.err != nil {
+		return t.err
+	}
+	cpu := t.core.Arch()
+	for cpu.Stats.Cycles < cycle {
+		before := cpu.Stats.Cycles
+		t.core.ClockCycle()
+		if cpu.Stats.Cycles == before {
+			return fmt.Errorf("core stopped at cycle %d", before)
+		}
+	}
+	if cycle == cpu.Stats.Cycles {
+		return nil
+	}
+
+	i := sort.Search(len(t.snapshots), func(i int) bool { return t.snapshots[i].cycle > cycle }) - 1
+	if i < 0 {
+		return fmt.Errorf("cycle %d is before oldest snapshot at %d", cycle, t.Oldest())
+	}
+	if err := LoadSnapshot(bytes.NewReader(t.snapshots[i].data), t.core); err != nil {
+		return err
+	}
+	//replayed cycles are recorded already
+	cpu.Travel = nil
+	for cpu.Stats.Cycles < cycle {
+		t.core.ClockCycle()
+	}
+	cpu.Travel = t
+	t.snapshots = t.snapshots[:i+1]
+	t.trim()
+	return nil
+}
+
+// back one cycle
+func (t *TimeTravel) ReverseStep() error {
+	if t.Cycle() == t.Oldest() {
+		return fmt.Errorf("cycle %d is oldest recorded", t.Cycle())
+	}
+	return t.Seek(t.Cycle() - 1)
+}
+
+// goes back to state before latest cycle whose record matches,
+// so next step runs it again. false if none matched
+func (t *TimeTravel) ReverseContinue(match func(r *CycleRecord) bool) (bool, error) {
+	for i := len(t.records) - 1; i >= 0; i-- {
+		if r := &t.records[i]; match(r) {
+			return true, t.Seek(r.Cycle - 1)
+		}
+	}
+	return false, nil
+}
+
+// breakpoint matching cycle that retired instruction at pc
+func RetiredAt(pc uint64) func(r *CycleRecord) bool {
+	return func(r *CycleRecord) bool {
+		for _, p := range r.Retired {
+			if p == pc {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (w MemoryWrite) overlaps(address uint64, size uint32) bool {
+	return w.Address < address+uint64(size) && address < w.Address+uint64(w.Size)
+}
+
+// watchpoint matching cycle that wrote any byte of address range
+func WroteTo(address uint64, size uint32) func(r *CycleRecord) bool {
+	return func(r *CycleRecord) bool {
+		for _, w := range r.Writes {
+			if w.overlaps(address, size) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// most recent store to any byte of address range up to current cycle,
+// with cycle it was made in. false if no kept record has one
+func (t *TimeTravel) LastWrite(address uint64, size uint32) (MemoryWrite, uint64, bool) {
+	for i := len(t.records) - 1; i >= 0; i-- {
+		r := &t.records[i]
+		for j := len(r.Writes) - 1; j >= 0; j-- {
+			if r.Writes[j].overlaps(address, size) {
+				return r.Writes[j], r.Cycle, true
+			}
+		}
+	}
+	return MemoryWrite{}, 0, false
+}
@@ -0,0 +1,109 @@
+package cpu
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTimeTravel(t *testing.T) {
+
+	for _, core := range []Core{&Cpu{}, NewOutOfOrder(&Cpu{}, DEFAULT_OOO)} {
+		for address, v := range crossCheckPrograms["loop"] {
+			core.Arch().Ram.SetLine(address, v)
+		}
+		travel, err := NewTimeTravel(core)
+		if err != nil {
+			t.Fatal(err)
+		}
+		travel.Interval = 16
+		for i := 0; i < 100; i++ {
+			core.ClockCycle()
+		}
+		end := bytes.Buffer{}
+		SaveSnapshot(&end, core)
+
+		//sum of loop is stored at 0x120 by sw at 0x20
+		w, cycle, ok := travel.LastWrite(0x120, 4)
+		if !ok || w.Pc != 0x20 || w.New != 36 || w.Old != 0 || w.Device || core.Arch().Ram.GetLine(0x120) != 36 {
+			t.Fatalf("\"TestTimeTravel()\" FAILED, last write %+v at cycle %d", w, cycle)
+		}
+
+		//state before store,next cycle stores again
+		if found, err := travel.ReverseContinue(WroteTo(0x120, 1)); !found || err != nil || travel.Cycle() != cycle-1 {
+			t.Fatalf("\"TestTimeTravel()\" FAILED, reverse continue to %d stopped at %d %v", cycle-1, travel.Cycle(), err)
+		}
+		if core.Arch().Ram.GetLine(0x120) != 0 {
+			t.Errorf("\"TestTimeTravel()\" FAILED, store not undone")
+		}
+		for i := 0; i < 5; i++ {
+			if err := travel.ReverseStep(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if travel.Cycle() != cycle-6 || travel.Record(cycle) != nil || travel.Record(cycle-6) == nil {
+			t.Errorf("\"TestTimeTravel()\" FAILED, at cycle %d after reverse step", travel.Cycle())
+		}
+
+		//loop branch retired every iteration,each one is found going back
+		retired, first := 0, uint64(0)
+		for c := travel.Cycle(); travel.Record(c) != nil; c-- {
+			if RetiredAt(0x1c)(travel.Record(c)) {
+				retired, first = retired+1, c
+			}
+		}
+		iterations := 0
+		for {
+			found, err := travel.ReverseContinue(RetiredAt(0x1c))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !found {
+				break
+			}
+			iterations++
+		}
+		if retired == 0 || iterations != retired || travel.Cycle() != first-1 {
+			t.Errorf("\"TestTimeTravel()\" FAILED, branch retired %d times,stopped at %d", iterations, travel.Cycle())
+		}
+
+		//running forward again ends in same state
+		if err := travel.Seek(100); err != nil {
+			t.Fatal(err)
+		}
+		again := bytes.Buffer{}
+		SaveSnapshot(&again, core)
+		if !bytes.Equal(end.Bytes(), again.Bytes()) {
+			t.Errorf("\"TestTimeTravel()\" FAILED, replay differs from first run")
+		}
+		if err := travel.Seek(0); err != nil || travel.Cycle() != 0 || travel.ReverseStep() == nil {
+			t.Errorf("\"TestTimeTravel()\" FAILED, seek to start %v", err)
+		}
+	}
+
+	cpu := &Cpu{}
+	cpu.CommitLog = NewCommitLog(&bytes.Buffer{})
+	if _, err := NewTimeTravel(cpu); err == nil {
+		t.Errorf("\"TestTimeTravel()\" FAILED, commit log can't be rewound")
+	}
+
+	//hart that can't be saved any more stops recording
+	cpu = &Cpu{}
+	for address, v := range crossCheckPrograms["loop"] {
+		cpu.Ram.SetLine(address, v)
+	}
+	travel, _ := NewTimeTravel(cpu)
+	travel.Interval = 16
+	l2 := DEFAULT_L2
+	cpu.SetCaches(DEFAULT_L1I, DEFAULT_L1D, &l2)
+	cpu.ICache.Next = nil
+	for i := 0; i < 40; i++ {
+		cpu.ClockCycle()
+	}
+	if travel.Err() == nil || cpu.Travel != nil || travel.Record(16) == nil || travel.Record(17) != nil {
+		t.Errorf("\"TestTimeTravel()\" FAILED, unsaveable hart recorded %v", travel.Err())
+	}
+	if err := travel.ReverseStep(); err != travel.Err() {
+		t.Errorf("\"TestTimeTravel()\" FAILED, reverse step after failure %v", err)
+	}
+
+}
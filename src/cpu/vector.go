@@ -0,0 +1,701 @@
+package cpu
+
+import (
+	"Go_emu/src/register"
+	"fmt"
+	"math/bits"
+	"slices"
+)
+
+const (
+	OPV    = 0b1010111 //vector arithmetic and configuration
+	LOADV  = 0b0000111 //vector loads (shares LOAD-FP major opcode)
+	STOREV = 0b0100111 //vector stores (shares STORE-FP major opcode)
+)
+
+// vector funct3 categories of OP-V
+const (
+	OPIVV = 0b000
+	OPMVV = 0b010
+	OPIVI = 0b011
+	OPIVX = 0b100
+	OPMVX = 0b110
+	OPCFG  = 0b111
+	vmUnit = 0b00
+	vmStr  = 0b10
+)
+
+type VectorStats struct {
+	Instructions uint64 //vector instructions executed
+	BusyCycles   uint64 //extra cycles execute stage was held by vector instructions
+	Elements     uint64 //active elements processed
+	Loads        uint64
+	Stores       uint64
+}
+
+// rvv 1.0 subset, VLEN and ELEN are in bits
+type VectorUnit struct {
+	VLEN       uint32
+	ELEN       uint32
+	ExecCycles uint32 //cycles vector instruction occupies execute stage
+	Lanes      uint32 //if not zero,each extra Lanes elements cost one more cycle
+	Stats      VectorStats
+
+	regs  []byte //v0-v31,VLEN/8 bytes each
+	vl    uint32
+	vtype uint32
+}
+
+type Vecops struct {
+	vd      uint32
+	vs1     uint32
+	vs2     uint32
+	funct6  uint32
+	masked  bool
+	load    bool
+	store   bool
+	eew     uint32 //element width in bytes for memory ops
+	stride  uint64
+	vl      uint32
+	started bool
+	busy    uint32
+	paddr   []uint64 //translated element addresses
+}
+
+func NewVectorUnit(vlen uint32, elen uint32) (*VectorUnit, error) {
+
+	if elen != 32 && elen != 64 {
+		return nil, fmt.Errorf("unsupported ELEN %d", elen)
+	}
+	if vlen < elen || vlen > 65536 || bits.OnesCount32(vlen) != 1 {
+		return nil, fmt.Errorf("VLEN %d must be power of two between ELEN and 65536", vlen)
+	}
+	return &VectorUnit{
+		VLEN:       vlen,
+		ELEN:       elen,
+		ExecCycles: 1,
+		regs:       make([]byte, 32*vlen/8),
+		vtype:      1 << 31, //vill until first vsetvl
+	}, nil
+}
+
+func (v *VectorUnit) Vl() uint32 {
+	return v.vl
+}
+
+func (v *VectorUnit) Vtype() uint32 {
+	return v.vtype
+}
+
+func (v *VectorUnit) Vlenb() uint32 {
+	return v.VLEN / 8
+}
+
+// selected element width in bytes
+func (v *VectorUnit) sew() uint32 {
+	return 1 << SubBits(v.vtype, 3, 5)
+}
+
+func (v *VectorUnit) vill() bool {
+	return v.vtype>>31 == 1
+}
+
+func (v *VectorUnit) vlmax(vtype uint32) uint32 {
+
+	sew := uint32(8) << SubBits(vtype, 3, 5)
+	lmul := SubBits(vtype, 0, 2)
+	if sew > v.ELEN || lmul == 0b100 || vtype>>8 != 0 {
+		return 0
+	}
+	elems := v.VLEN / sew
+	if lmul < 4 {
+		return elems << lmul
+	}
+	//fractional lmul
+	return elems >> (8 - lmul)
+}
+
+// vsetvl family,returns new vl
+func (v *VectorUnit) setvl(avl uint64, vtype uint32, keep bool, max bool) uint32 {
+
+	vlmax := v.vlmax(vtype)
+	if vlmax == 0 {
+		v.vtype = 1 << 31
+		v.vl = 0
+		return 0
+	}
+	v.vtype = vtype
+	switch {
+	case max:
+		v.vl = vlmax
+	case keep:
+		if v.vl > vlmax {
+			v.vl = vlmax
+		}
+	default:
+		v.vl = uint32(min(avl, uint64(vlmax)))
+	}
+	return v.vl
+}
+
+func (v *VectorUnit) GetElement(reg uint32, idx uint32, width uint32) uint64 {
+
+	off := reg*v.Vlenb() + idx*width
+	var val uint64
+	for b := uint32(0); b < width && int(off+b) < len(v.regs); b++ {
+		val |= uint64(v.regs[off+b]) << (8 * b)
+	}
+	return val
+}
+
+func (v *VectorUnit) SetElement(reg uint32, idx uint32, width uint32, val uint64) {
+
+	off := reg*v.Vlenb() + idx*width
+	for b := uint32(0); b < width && int(off+b) < len(v.regs); b++ {
+		v.regs[off+b] = byte(val >> (8 * b))
+	}
+}
+
+func (v *VectorUnit) maskBit(reg uint32, idx uint32) bool {
+	return v.regs[reg*v.Vlenb()+idx/8]>>(idx%8)&1 == 1
+}
+
+func (v *VectorUnit) setMaskBit(reg uint32, idx uint32, set bool) {
+
+	off := reg*v.Vlenb() + idx/8
+	if set {
+		v.regs[off] |= 1 << (idx % 8)
+	} else {
+		v.regs[off] &^= 1 << (idx % 8)
+	}
+}
+
+// is element active under v0.t
+func (v *VectorUnit) active(op *Vecops, idx uint32) bool {
+	return !op.masked || v.maskBit(0, idx)
+}
+
+// execute stage occupancy for instruction
+func (v *VectorUnit) cycles(vl uint32) uint32 {
+
+	c := v.ExecCycles
+	if c == 0 {
+		c = 1
+	}
+	if v.Lanes != 0 && vl > v.Lanes {
+		c += (vl - 1) / v.Lanes
+	}
+	return c
+}
+
+func sextWidth(val uint64, width uint32) int64 {
+	shift := 64 - 8*width
+	return int64(val<<shift) >> shift
+}
+
+func (inst *Instruction) extractVectorOperands(romline uint32, regFile *register.RegisterFile) {
+
+	inst.rd = SubBits(romline, 7, 11)
+	inst.funct3 = SubBits(romline, 12, 14)
+	inst.funct7 = SubBits(romline, 25, 31)
+	inst.imm = uint64(SubBits(romline, 20, 31))
+	inst.vecop = &Vecops{
+		vd:     inst.rd,
+		vs1:    SubBits(romline, 15, 19),
+		vs2:    SubBits(romline, 20, 24),
+		funct6: SubBits(romline, 26, 31),
+		masked: SubBits(romline, 25, 25) == 0,
+	}
+
+	scalarRs1 := false
+	scalarRs2 := false
+	switch inst.opcode {
+	case OPV:
+		switch inst.funct3 {
+		case OPIVX, OPMVX:
+			scalarRs1 = true
+		case OPCFG:
+			//vsetvli,vsetvl use rs1 as avl,vsetivli has it as immediate
+			scalarRs1 = SubBits(romline, 30, 31) != 0b11
+			scalarRs2 = SubBits(romline, 31, 31) == 1 && SubBits(romline, 30, 30) == 0
+		}
+	case LOADV, STOREV:
+		inst.vecop.load = inst.opcode == LOADV
+		inst.vecop.store = inst.opcode == STOREV
+		scalarRs1 = true
+		scalarRs2 = SubBits(romline, 26, 27) == vmStr
+	}
+
+	if scalarRs1 {
+		inst.rs1_index = inst.vecop.vs1
+		inst.rs1 = regFile.GetRegVal(inst.rs1_index)
+	}
+	if scalarRs2 {
+		inst.rs2_index = inst.vecop.vs2
+		inst.rs2 = regFile.GetRegVal(inst.rs2_index)
+	}
+}
+
+// only vector flavours of LOAD-FP/STORE-FP are decoded
+func isVectorMemWidth(funct3 uint32) bool {
+	return funct3 == 0b000 || funct3 == 0b101 || funct3 == 0b110 || funct3 == 0b111
+}
+
+func vectorMemWidth(funct3 uint32) uint32 {
+	switch funct3 {
+	case 0b101:
+		return 2
+	case 0b110:
+		return 4
+	case 0b111:
+		return 8
+	}
+	return 1
+}
+
+// execute stage part of vector instruction
+func (cpu *Cpu) executeVector(inst *Instruction) {
+
+	v := cpu.Vector
+	op := inst.vecop
+	v.Stats.Instructions++
+
+	if inst.opcode == OPV && inst.funct3 == OPCFG {
+		var vl uint32
+		rs1 := SubBits(inst.romline, 15, 19)
+		switch {
+		//vsetivli
+		case SubBits(inst.romline, 30, 31) == 0b11:
+			vl = v.setvl(uint64(rs1), SubBits(inst.romline, 20, 29), false, false)
+		//vsetvl
+		case SubBits(inst.romline, 31, 31) == 1:
+			//vtype with any reserved bit set is vill
+			vl = v.setvl(inst.rs1, uint32(min(inst.rs2, 0x100)), rs1 == 0 && inst.rd == 0, rs1 == 0 && inst.rd != 0)
+		//vsetvli
+		default:
+			vl = v.setvl(inst.rs1, SubBits(inst.romline, 20, 30), rs1 == 0 && inst.rd == 0, rs1 == 0 && inst.rd != 0)
+		}
+		inst.wbop = &Wbops{
+			dest: inst.rd,
+			data: uint64(vl),
+		}
+		return
+	}
+
+	if v.vill() || !v.legal(inst) {
+		inst.raise(CAUSE_ILLEGAL_INST, uint64(inst.romline))
+		return
+	}
+	op.vl = v.vl
+	//scalar operand sign extended from XLEN
+	x := uint64(cpu.signed(inst.rs1))
+
+	switch inst.opcode {
+	case LOADV, STOREV:
+		//element transfer happens in memory stage
+		op.eew = vectorMemWidth(inst.funct3)
+		if SubBits(inst.romline, 26, 27) == vmStr {
+			op.stride = inst.rs2
+		} else {
+			op.stride = uint64(op.eew)
+		}
+		inst.memop = &Memops{
+			address: inst.rs1,
+		}
+		if op.load {
+			inst.memop.optype = LOAD
+		} else {
+			inst.memop.optype = STORE
+		}
+	case OPV:
+		switch inst.funct3 {
+		case OPIVV, OPIVI, OPIVX:
+			v.integerOp(inst, x)
+		case OPMVV, OPMVX:
+			v.multiplyReduceOp(inst, x)
+		}
+	}
+}
+
+// implemented OPIVV,OPIVX,OPIVI funct6 and formats each one exists in
+var vectorIntegerFormats = map[uint32][]uint32{
+	0b000000: {OPIVV, OPIVX, OPIVI}, //vadd
+	0b000010: {OPIVV, OPIVX},        //vsub
+	0b000011: {OPIVX, OPIVI},        //vrsub
+	0b000100: {OPIVV, OPIVX},        //vminu
+	0b000101: {OPIVV, OPIVX},        //vmin
+	0b000110: {OPIVV, OPIVX},        //vmaxu
+	0b000111: {OPIVV, OPIVX},        //vmax
+	0b001001: {OPIVV, OPIVX, OPIVI}, //vand
+	0b001010: {OPIVV, OPIVX, OPIVI}, //vor
+	0b001011: {OPIVV, OPIVX, OPIVI}, //vxor
+	0b010111: {OPIVV, OPIVX, OPIVI}, //vmerge,vmv.v
+	0b011000: {OPIVV, OPIVX, OPIVI}, //vmseq
+	0b011001: {OPIVV, OPIVX, OPIVI}, //vmsne
+	0b011010: {OPIVV, OPIVX},        //vmsltu
+	0b011011: {OPIVV, OPIVX},        //vmslt
+	0b011100: {OPIVV, OPIVX, OPIVI}, //vmsleu
+	0b011101: {OPIVV, OPIVX, OPIVI}, //vmsle
+	0b011110: {OPIVX, OPIVI},        //vmsgtu
+	0b011111: {OPIVX, OPIVI},        //vmsgt
+	0b100101: {OPIVV, OPIVX, OPIVI}, //vsll
+	0b101000: {OPIVV, OPIVX, OPIVI}, //vsrl
+	0b101001: {OPIVV, OPIVX, OPIVI}, //vsra
+}
+
+// register group size in eighths of register,
+// lmul of vtype scaled by eew/sew for memory ops
+func (v *VectorUnit) emul8(eew uint32) uint32 {
+	lmul := SubBits(v.vtype, 0, 2)
+	lmul8 := uint32(8) << lmul
+	if lmul >= 4 {
+		lmul8 = 8 >> (8 - lmul)
+	}
+	return lmul8 * eew / v.sew()
+}
+
+// implemented encoding with register groups aligned
+// to their size,anything else is illegal instruction
+func (v *VectorUnit) legal(inst *Instruction) bool {
+
+	op := inst.vecop
+	aligned := func(emul8 uint32, regs ...uint32) bool {
+		for _, r := range regs {
+			if emul8 > 8 && r%(emul8/8) != 0 {
+				return false
+			}
+		}
+		return true
+	}
+	group := v.emul8(v.sew())
+
+	switch inst.opcode {
+	case LOADV, STOREV:
+		//unit stride and strided only,indexed,segment,whole register
+		//and mask transfers are not implemented
+		mop := SubBits(inst.romline, 26, 27)
+		if SubBits(inst.romline, 28, 31) != 0 || (mop != vmUnit && mop != vmStr) || (mop == vmUnit && op.vs2 != 0) {
+			return false
+		}
+		eew := vectorMemWidth(inst.funct3)
+		emul8 := v.emul8(eew)
+		return eew*8 <= v.ELEN && emul8 >= 1 && emul8 <= 64 && aligned(emul8, op.vd)
+	}
+	switch inst.funct3 {
+	case OPIVV, OPIVX, OPIVI:
+		formats := vectorIntegerFormats[op.funct6]
+		if !slices.Contains(formats, inst.funct3) || !aligned(group, op.vs2) {
+			return false
+		}
+		if inst.funct3 == OPIVV && !aligned(group, op.vs1) {
+			return false
+		}
+		//compares write one mask register
+		return op.funct6>>3 == 0b011 || aligned(group, op.vd)
+	case OPMVV, OPMVX:
+		switch {
+		//vmv.x.s,vmv.s.x
+		case op.funct6 == 0b010000:
+			return inst.funct3 == OPMVX || op.vs1 == 0
+		//reductions,scalar vd and vs1
+		case op.funct6 <= 0b000111:
+			return inst.funct3 == OPMVV && aligned(group, op.vs2)
+		//mask logical
+		case op.funct6>>3 == 0b011:
+			return inst.funct3 == OPMVV
+		//vmul
+		case op.funct6 == 0b100101:
+			return aligned(group, op.vd, op.vs2) && (inst.funct3 == OPMVX || aligned(group, op.vs1))
+		}
+	}
+	return false
+}
+
+// OPIVV,OPIVX,OPIVI
+func (v *VectorUnit) integerOp(inst *Instruction, x uint64) {
+
+	op := inst.vecop
+	sew := v.sew()
+	shmask := uint64(8*sew - 1)
+
+	src1 := func(i uint32) uint64 {
+		switch inst.funct3 {
+		case OPIVV:
+			return v.GetElement(op.vs1, i, sew)
+		case OPIVX:
+			return x
+		}
+		//simm5
+		return uint64(int64(int32(SignExtend(op.vs1, 5))))
+	}
+
+	for i := uint32(0); i < op.vl; i++ {
+
+		//vmerge uses mask as selector instead of disabling element
+		if op.funct6 != 0b010111 && !v.active(op, i) {
+			continue
+		}
+		a := v.GetElement(op.vs2, i, sew)
+		b := src1(i)
+		sa := sextWidth(a, sew)
+		sb := sextWidth(b, sew)
+		ua := uint64(a) & (^uint64(0) >> (64 - 8*sew))
+		ub := uint64(b) & (^uint64(0) >> (64 - 8*sew))
+		v.Stats.Elements++
+
+		var res uint64
+		cmp := -1
+		switch op.funct6 {
+		//vadd
+		case 0b000000:
+			res = a + b
+		//vsub
+		case 0b000010:
+			res = a - b
+		//vrsub
+		case 0b000011:
+			res = b - a
+		//vminu
+		case 0b000100:
+			res = min(ua, ub)
+		//vmin
+		case 0b000101:
+			res = uint64(min(sa, sb))
+		//vmaxu
+		case 0b000110:
+			res = max(ua, ub)
+		//vmax
+		case 0b000111:
+			res = uint64(max(sa, sb))
+		//vand
+		case 0b001001:
+			res = a & b
+		//vor
+		case 0b001010:
+			res = a | b
+		//vxor
+		case 0b001011:
+			res = a ^ b
+		//vmerge,vmv.v
+		case 0b010111:
+			res = b
+			if op.masked && !v.maskBit(0, i) {
+				res = a
+			}
+		//vmseq
+		case 0b011000:
+			cmp = b2i(ua == ub)
+		//vmsne
+		case 0b011001:
+			cmp = b2i(ua != ub)
+		//vmsltu
+		case 0b011010:
+			cmp = b2i(ua < ub)
+		//vmslt
+		case 0b011011:
+			cmp = b2i(sa < sb)
+		//vmsleu
+		case 0b011100:
+			cmp = b2i(ua <= ub)
+		//vmsle
+		case 0b011101:
+			cmp = b2i(sa <= sb)
+		//vmsgtu
+		case 0b011110:
+			cmp = b2i(ua > ub)
+		//vmsgt
+		case 0b011111:
+			cmp = b2i(sa > sb)
+		//vsll
+		case 0b100101:
+			res = a << (b & shmask)
+		//vsrl
+		case 0b101000:
+			res = ua >> (b & shmask)
+		//vsra
+		case 0b101001:
+			res = uint64(sa >> (b & shmask))
+		}
+		if cmp >= 0 {
+			v.setMaskBit(op.vd, i, cmp == 1)
+		} else {
+			v.SetElement(op.vd, i, sew, res)
+		}
+	}
+}
+
+// OPMVV,OPMVX
+func (v *VectorUnit) multiplyReduceOp(inst *Instruction, x uint64) {
+
+	op := inst.vecop
+	sew := v.sew()
+
+	switch {
+	//vmv.x.s
+	case op.funct6 == 0b010000 && inst.funct3 == OPMVV && op.vs1 == 0:
+		inst.wbop = &Wbops{
+			dest: inst.rd,
+			data: uint64(sextWidth(v.GetElement(op.vs2, 0, sew), sew)),
+		}
+		return
+	//vmv.s.x
+	case op.funct6 == 0b010000 && inst.funct3 == OPMVX:
+		if op.vl > 0 {
+			v.SetElement(op.vd, 0, sew, x)
+		}
+		return
+	//reductions
+	case op.funct6 <= 0b000111 && inst.funct3 == OPMVV:
+		v.reduce(op, sew)
+		return
+	//mask logical
+	case op.funct6 >= 0b011000 && op.funct6 <= 0b011111 && inst.funct3 == OPMVV:
+		v.maskLogical(op)
+		return
+	}
+
+	//vmul,other encodings were rejected by legal
+	for i := uint32(0); i < op.vl; i++ {
+		if !v.active(op, i) {
+			continue
+		}
+		b := x
+		if inst.funct3 == OPMVV {
+			b = v.GetElement(op.vs1, i, sew)
+		}
+		v.SetElement(op.vd, i, sew, v.GetElement(op.vs2, i, sew)*b)
+		v.Stats.Elements++
+	}
+}
+
+func (v *VectorUnit) reduce(op *Vecops, sew uint32) {
+
+	if op.vl == 0 {
+		return
+	}
+	acc := v.GetElement(op.vs1, 0, sew)
+	for i := uint32(0); i < op.vl; i++ {
+		if !v.active(op, i) {
+			continue
+		}
+		e := v.GetElement(op.vs2, i, sew)
+		v.Stats.Elements++
+		switch op.funct6 {
+		//vredsum
+		case 0b000000:
+			acc += e
+		//vredand
+		case 0b000001:
+			acc &= e
+		//vredor
+		case 0b000010:
+			acc |= e
+		//vredxor
+		case 0b000011:
+			acc ^= e
+		//vredminu
+		case 0b000100:
+			acc = min(acc, e)
+		//vredmin
+		case 0b000101:
+			acc = uint64(min(sextWidth(acc, sew), sextWidth(e, sew)))
+		//vredmaxu
+		case 0b000110:
+			acc = max(acc, e)
+		//vredmax
+		case 0b000111:
+			acc = uint64(max(sextWidth(acc, sew), sextWidth(e, sew)))
+		}
+	}
+	v.SetElement(op.vd, 0, sew, acc)
+}
+
+// vmand.mm and friends,always unmasked
+func (v *VectorUnit) maskLogical(op *Vecops) {
+
+	for i := uint32(0); i < op.vl; i++ {
+		a := v.maskBit(op.vs2, i)
+		b := v.maskBit(op.vs1, i)
+		var res bool
+		switch op.funct6 {
+		//vmandn
+		case 0b011000:
+			res = a && !b
+		//vmand
+		case 0b011001:
+			res = a && b
+		//vmor
+		case 0b011010:
+			res = a || b
+		//vmxor
+		case 0b011011:
+			res = a != b
+		//vmorn
+		case 0b011100:
+			res = a || !b
+		//vmnand
+		case 0b011101:
+			res = !(a && b)
+		//vmnor
+		case 0b011110:
+			res = !(a || b)
+		//vmxnor
+		case 0b011111:
+			res = a == b
+		}
+		v.setMaskBit(op.vd, i, res)
+	}
+}
+
+// memory stage part of vector load/store
+func (cpu *Cpu) vectorMemOps(inst *Instruction) {
+
+	v := cpu.Vector
+	op := inst.vecop
+	for i := uint32(0); i < op.vl; i++ {
+		if !v.active(op, i) {
+			continue
+		}
+		address := op.paddr[i]
+		if op.load {
+			v.SetElement(op.vd, i, op.eew, cpu.readPhys(address, op.eew))
+			v.Stats.Loads++
+		} else {
+			cpu.writePhys(inst, address, op.eew, v.GetElement(op.vd, i, op.eew))
+			v.Stats.Stores++
+		}
+		v.Stats.Elements++
+	}
+}
+
+// vector instruction in execute holds it for its occupancy
+// returns true while execute stage is still busy
+func (cpu *Cpu) vectorBusy() bool {
+
+	l := cpu.pipeline()
+	if cpu.Vector == nil || l.execute == 0 {
+		return false
+	}
+	inst := cpu.instStorage[l.execute-1][0]
+	if inst == nil || inst.vecop == nil {
+		return false
+	}
+	op := inst.vecop
+	if !op.started {
+		op.started = true
+		op.busy = cpu.Vector.cycles(cpu.Vector.vl)
+	}
+	if op.busy > 1 {
+		op.busy--
+		cpu.Vector.Stats.BusyCycles++
+		return true
+	}
+	return false
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
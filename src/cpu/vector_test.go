@@ -0,0 +1,203 @@
+package cpu
+
+import (
+	"testing"
+)
+
+func TestVectorSetvl(t *testing.T) {
+
+	v, err := NewVectorUnit(128, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//e32,m2 -> VLMAX 8
+	if vl := v.setvl(20, 0b010_001, false, false); vl != 8 {
+		t.Errorf("\"TestVectorSetvl()\" FAILED, expected vl -> 8, got -> %d", vl)
+	}
+	//e8,mf2 -> VLMAX 8
+	if vl := v.setvl(3, 0b000_111, false, false); vl != 3 {
+		t.Errorf("\"TestVectorSetvl()\" FAILED, expected vl -> 3, got -> %d", vl)
+	}
+	//e16,m1 keep vl
+	if vl := v.setvl(0, 0b001_000, true, false); vl != 3 {
+		t.Errorf("\"TestVectorSetvl()\" FAILED, expected vl -> 3, got -> %d", vl)
+	}
+	//e64 is wider than ELEN,must set vill
+	if vl := v.setvl(4, 0b011_000, false, false); vl != 0 || !v.vill() {
+		t.Errorf("\"TestVectorSetvl()\" FAILED, expected vill, got vl -> %d vtype -> %x", vl, v.Vtype())
+	}
+
+	if _, err := NewVectorUnit(96, 32); err == nil {
+		t.Errorf("\"TestVectorSetvl()\" FAILED, VLEN 96 must be rejected")
+	}
+
+}
+
+func TestVectorRomExecution(t *testing.T) {
+
+	var program = []uint32{
+		0x40000513, //addi a0, x0, 0x400
+		0x60000613, //addi a2, x0, 0x600
+		0x06400693, //addi a3, x0, 100
+		0x00800313, //addi t1, x0, 8
+		0xc10472d7, //vsetivli t0, 8, e32, m1
+		0x02056087, //vle32.v v1, (a0)
+		0x0212b157, //vadd.vi v2, v1, 5
+		0x42006257, //vmv.s.x v4, x0
+		0x022221d7, //vredsum.vs v3, v2, v4
+		0x423025d7, //vmv.x.s a1, v3
+		0x02066127, //vse32.v v2, (a2)
+		0x7e123057, //vmsgt.vi v0, v1, 4
+		0x0016c2d7, //vadd.vx v5, v1, a3, v0.t
+		0x02060713, //addi a4, a2, 32
+		0x020762a7, //vse32.v v5, (a4)
+		0x0a656307, //vlse32.v v6, (a0), t1
+		0x04060793, //addi a5, a2, 64
+		0xc1027057, //vsetivli x0, 4, e32, m1
+		0x0207e327, //vse32.v v6, (a5)
+	}
+
+	vector, err := NewVectorUnit(256, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vector.ExecCycles = 3
+	cpu := Cpu{
+		Vector: vector,
+	}
+	for i, v := range program {
+		cpu.Ram.SetLine(uint32(i*4), v)
+	}
+	for i := uint32(0); i < 8; i++ {
+		cpu.Ram.SetLine(0x400+i*4, i+1)
+	}
+	for i := 0; i < 1000; i++ {
+		cpu.ClockCycle()
+	}
+
+	if cpu.regFile.GetRegVal(5) != 8 || cpu.regFile.GetRegVal(11) != 76 {
+		t.Errorf("\"TestVectorRomExecution()\" FAILED, t0 -> %d a1 -> %d", cpu.regFile.GetRegVal(5), cpu.regFile.GetRegVal(11))
+	}
+
+	var expected = []uint32{
+		6, 7, 8, 9, 10, 11, 12, 13,
+		0, 0, 0, 0, 105, 106, 107, 108,
+		1, 3, 5, 7, 0, 0, 0, 0,
+	}
+	for i, v := range expected {
+		if got := cpu.Ram.GetLine(0x600 + uint32(i*4)); got != v {
+			t.Errorf("\"TestVectorRomExecution()\" FAILED at %x, expected -> %d, got -> %d", 0x600+i*4, v, got)
+			return
+		}
+	}
+
+	if vector.Stats.Instructions != 13 || vector.Stats.BusyCycles != 26 {
+		t.Errorf("\"TestVectorRomExecution()\" FAILED, stats -> %+v", vector.Stats)
+	}
+
+}
+
+func TestVectorIllegal(t *testing.T) {
+
+	var program = map[uint32]uint32{
+		0x00: 0x04000293, //addi t0, x0, 0x40
+		0x04: 0x30529073, //csrrw x0, mtvec, t0
+		0x08: 0x20000913, //addi s2, x0, 0x200
+		0x0c: 0xc1127057, //vsetivli x0, 4, e32, m2
+		0x10: 0x02430157, //vadd.vv v2, v4, v6
+		0x14: 0x024300d7, //vadd.vv v1, v4, v6
+		0x18: 0x02530157, //vadd.vv v2, v5, v6
+		0x1c: 0x82432157, //vdivu.vv v2, v4, v6
+		0x20: 0xc1827057, //vsetivli x0, 4, e64, m1
+		0x24: 0x02430157, //vadd.vv v2, v4, v6
+		0x28: 0x06300513, //addi a0, x0, 99
+		0x40: 0x34202373, //csrrs t1, mcause, x0
+		0x44: 0x343023f3, //csrrs t2, mtval, x0
+		0x48: 0x00792023, //sw t2, 0(s2)
+		0x4c: 0x00490913, //addi s2, s2, 4
+		0x50: 0x34102473, //csrrs s0, mepc, x0
+		0x54: 0x00440413, //addi s0, s0, 4
+		0x58: 0x34141073, //csrrw x0, mepc, s0
+		0x5c: 0x30200073, //mret
+	}
+
+	vector, err := NewVectorUnit(128, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := Cpu{Vector: vector}
+	for address, v := range program {
+		cpu.Ram.SetLine(address, v)
+	}
+	for i := 0; i < 1000; i++ {
+		cpu.ClockCycle()
+	}
+
+	//misaligned vd and vs2 of m2 group,unimplemented funct6,vill
+	trapped := []uint32{0x024300d7, 0x02530157, 0x82432157, 0x02430157, 0}
+	for i, v := range trapped {
+		if got := cpu.Ram.GetLine(0x200 + uint32(i*4)); got != v {
+			t.Errorf("\"TestVectorIllegal()\" FAILED, trap %d mtval -> %08x expected %08x", i, got, v)
+		}
+	}
+	if cpu.regFile.GetRegVal(6) != CAUSE_ILLEGAL_INST || cpu.regFile.GetRegVal(10) != 99 || !vector.vill() {
+		t.Errorf("\"TestVectorIllegal()\" FAILED, mcause -> %d a0 -> %d", cpu.regFile.GetRegVal(6), cpu.regFile.GetRegVal(10))
+	}
+
+}
+
+func TestVectorMemIllegal(t *testing.T) {
+
+	var program = map[uint32]uint32{
+		0x00: 0x04000293, //addi t0, x0, 0x40
+		0x04: 0x30529073, //csrrw x0, mtvec, t0
+		0x08: 0x20000913, //addi s2, x0, 0x200
+		0x0c: 0x30000593, //addi a1, x0, 0x300
+		0x10: 0xc1027057, //vsetivli x0, 4, e32, m1
+		0x14: 0x0645e107, //vluxei32.v v2, (a1), v4
+		0x18: 0x0e45e107, //vloxei32.v v2, (a1), v4
+		0x1c: 0x0645e127, //vsuxei32.v v2, (a1), v4
+		0x20: 0x2205e107, //vlseg2e32.v v2, (a1)
+		0x24: 0x0285e107, //vl1re32.v v2, (a1)
+		0x28: 0x02b58107, //vlm.v v2, (a1)
+		0x2c: 0x02b58127, //vsm.v v2, (a1)
+		0x30: 0x1205e107, //vle32.v with mew set
+		0x34: 0x0205e107, //vle32.v v2, (a1)
+		0x38: 0x0a05e107, //vlse32.v v2, (a1), x0
+		0x3c: 0x0000006f, //jal x0, 0
+		0x40: 0x343023f3, //csrrs t2, mtval, x0
+		0x44: 0x00792023, //sw t2, 0(s2)
+		0x48: 0x00490913, //addi s2, s2, 4
+		0x4c: 0x34102473, //csrrs s0, mepc, x0
+		0x50: 0x00440413, //addi s0, s0, 4
+		0x54: 0x34141073, //csrrw x0, mepc, s0
+		0x58: 0x30200073, //mret
+	}
+
+	vector, err := NewVectorUnit(128, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := Cpu{Vector: vector}
+	for address, v := range program {
+		cpu.Ram.SetLine(address, v)
+	}
+	cpu.Ram.SetLine(0x304, 0x11)
+	for i := 0; i < 1000; i++ {
+		cpu.ClockCycle()
+	}
+
+	//every encoding other than unit stride and strided traps
+	trapped := []uint32{0x0645e107, 0x0e45e107, 0x0645e127, 0x2205e107, 0x0285e107, 0x02b58107, 0x02b58127, 0x1205e107, 0}
+	for i, v := range trapped {
+		if got := cpu.Ram.GetLine(0x200 + uint32(i*4)); got != v {
+			t.Errorf("\"TestVectorMemIllegal()\" FAILED, trap %d mtval -> %08x expected %08x", i, got, v)
+		}
+	}
+	//zero stride load repeats first element
+	if vector.GetElement(2, 1, 4) != 0 || vector.Stats.Loads == 0 {
+		t.Errorf("\"TestVectorMemIllegal()\" FAILED, v2[1] -> %#x loads %d", vector.GetElement(2, 1, 4), vector.Stats.Loads)
+	}
+
+}
@@ -0,0 +1,168 @@
+package fdt
+
+import (
+	"encoding/binary"
+)
+
+// flattened device tree structure block tokens
+const (
+	FDT_MAGIC      = 0xd00dfeed
+	FDT_BEGIN_NODE = 0x1
+	FDT_END_NODE   = 0x2
+	FDT_PROP       = 0x3
+	FDT_END        = 0x9
+)
+
+const (
+	headerSize  = 40
+	version     = 17
+	lastVersion = 16
+)
+
+type property struct {
+	name  string
+	value []byte
+}
+
+// device tree node,properties keep insertion order
+type Node struct {
+	Name     string
+	props    []property
+	Children []*Node
+}
+
+func NewNode(name string) *Node {
+	return &Node{Name: name}
+}
+
+func (n *Node) AddChild(name string) *Node {
+	child := NewNode(name)
+	n.Children = append(n.Children, child)
+	return child
+}
+
+func (n *Node) Child(name string) *Node {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (n *Node) SetBytes(name string, value []byte) *Node {
+	for i := range n.props {
+		if n.props[i].name == name {
+			n.props[i].value = value
+			return n
+		}
+	}
+	n.props = append(n.props, property{name: name, value: value})
+	return n
+}
+
+// property without value,like interrupt-controller
+func (n *Node) SetEmpty(name string) *Node {
+	return n.SetBytes(name, []byte{})
+}
+
+// string list,each one null terminated
+func (n *Node) SetString(name string, values ...string) *Node {
+	var b []byte
+	for _, v := range values {
+		b = append(b, v...)
+		b = append(b, 0)
+	}
+	return n.SetBytes(name, b)
+}
+
+// big endian 32 bit cells
+func (n *Node) SetCells(name string, cells ...uint32) *Node {
+	b := make([]byte, 4*len(cells))
+	for i, c := range cells {
+		binary.BigEndian.PutUint32(b[i*4:], c)
+	}
+	return n.SetBytes(name, b)
+}
+
+// address or size of two cells
+func Cells64(val uint64) []uint32 {
+	return []uint32{uint32(val >> 32), uint32(val)}
+}
+
+func (n *Node) Property(name string) ([]byte, bool) {
+	for _, p := range n.props {
+		if p.name == name {
+			return p.value, true
+		}
+	}
+	return nil, false
+}
+
+type builder struct {
+	structs []byte
+	strings []byte
+	offsets map[string]uint32
+}
+
+func (b *builder) token(t uint32) {
+	b.structs = binary.BigEndian.AppendUint32(b.structs, t)
+}
+
+func (b *builder) pad() {
+	for len(b.structs)%4 != 0 {
+		b.structs = append(b.structs, 0)
+	}
+}
+
+// property names are deduplicated in strings block
+func (b *builder) nameOffset(name string) uint32 {
+	if off, ok := b.offsets[name]; ok {
+		return off
+	}
+	off := uint32(len(b.strings))
+	b.offsets[name] = off
+	b.strings = append(append(b.strings, name...), 0)
+	return off
+}
+
+func (b *builder) node(n *Node) {
+	b.token(FDT_BEGIN_NODE)
+	b.structs = append(append(b.structs, n.Name...), 0)
+	b.pad()
+	for _, p := range n.props {
+		b.token(FDT_PROP)
+		b.token(uint32(len(p.value)))
+		b.token(b.nameOffset(p.name))
+		b.structs = append(b.structs, p.value...)
+		b.pad()
+	}
+	for _, c := range n.Children {
+		b.node(c)
+	}
+	b.token(FDT_END_NODE)
+}
+
+// version 17 blob with empty memory reservation map
+func Blob(root *Node, bootCpu uint32) []byte {
+
+	b := &builder{offsets: map[string]uint32{}}
+	b.node(root)
+	b.token(FDT_END)
+
+	rsvmap := make([]byte, 16)
+	offRsv := uint32(headerSize)
+	offStruct := offRsv + uint32(len(rsvmap))
+	offStrings := offStruct + uint32(len(b.structs))
+	total := offStrings + uint32(len(b.strings))
+
+	blob := make([]byte, 0, total)
+	for _, v := range []uint32{FDT_MAGIC, total, offStruct, offStrings, offRsv, version, lastVersion,
+		bootCpu, uint32(len(b.strings)), uint32(len(b.structs))} {
+		blob = binary.BigEndian.AppendUint32(blob, v)
+	}
+	blob = append(blob, rsvmap...)
+	blob = append(blob, b.structs...)
+	blob = append(blob, b.strings...)
+	return blob
+}
@@ -0,0 +1,77 @@
+package fdt
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// walks structure block and returns property values by node path
+func parseBlob(blob []byte) map[string][]byte {
+
+	be := binary.BigEndian
+	offStruct, offStrings := be.Uint32(blob[8:]), be.Uint32(blob[12:])
+	props := map[string][]byte{}
+	path := []string{}
+	pos := offStruct
+	cstring := func(at uint32) string {
+		end := at
+		for blob[end] != 0 {
+			end++
+		}
+		return string(blob[at:end])
+	}
+	align := func(n uint32) uint32 { return (n + 3) &^ 3 }
+
+	for {
+		token := be.Uint32(blob[pos:])
+		pos += 4
+		switch token {
+		case FDT_BEGIN_NODE:
+			name := cstring(pos)
+			path = append(path, name)
+			pos = align(pos + uint32(len(name)) + 1)
+		case FDT_END_NODE:
+			path = path[:len(path)-1]
+		case FDT_PROP:
+			size, nameoff := be.Uint32(blob[pos:]), be.Uint32(blob[pos+4:])
+			key := ""
+			for _, p := range path[1:] {
+				key += "/" + p
+			}
+			props[key+":"+cstring(offStrings+nameoff)] = blob[pos+8 : pos+8+size]
+			pos = align(pos + 8 + size)
+		case FDT_END:
+			return props
+		}
+	}
+}
+
+func TestBlob(t *testing.T) {
+
+	root := NewNode("")
+	root.SetCells("#address-cells", 2)
+	cpus := root.AddChild("cpus")
+	cpus.AddChild("cpu@0").SetString("riscv,isa", "rv32ima")
+	mem := root.AddChild("memory@80000000")
+	mem.SetCells("reg", append(Cells64(0x80000000), Cells64(0x1000000)...)...)
+	mem.SetEmpty("dma-coherent")
+	mem.SetCells("#address-cells", 1)
+
+	blob := Blob(root, 0)
+	be := binary.BigEndian
+	if be.Uint32(blob) != FDT_MAGIC || be.Uint32(blob[4:]) != uint32(len(blob)) || be.Uint32(blob[20:]) != 17 {
+		t.Errorf("\"TestBlob()\" header FAILED, %x", blob[:40])
+		return
+	}
+
+	props := parseBlob(blob)
+	if string(props["/cpus/cpu@0:riscv,isa"]) != "rv32ima\x00" ||
+		be.Uint32(props[":#address-cells"]) != 2 ||
+		be.Uint32(props["/memory@80000000:#address-cells"]) != 1 ||
+		len(props["/memory@80000000:dma-coherent"]) != 0 ||
+		be.Uint64(props["/memory@80000000:reg"]) != 0x80000000 ||
+		be.Uint64(props["/memory@80000000:reg"][8:]) != 0x1000000 {
+		t.Errorf("\"TestBlob()\" FAILED, %q", props)
+	}
+
+}
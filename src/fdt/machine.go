@@ -0,0 +1,151 @@
+package fdt
+
+import (
+	"fmt"
+)
+
+// machine description from which device tree is generated
+type Machine struct {
+	Model      string
+	Compatible string
+	Timebase   uint32
+	Bootargs   string
+	Stdout     string //name of console device
+	Harts      []Hart
+	Memory     []Region
+	Devices    []Device
+	Nodes      []*Node //extra nodes under root
+}
+
+type Hart struct {
+	Isa string
+	Mmu string //mmu-type,empty when hart has no mmu
+}
+
+type Region struct {
+	Base uint64
+	Size uint64
+}
+
+// device on soc bus,interrupt wiring refers to other devices by name
+type Device struct {
+	Name       string //node name without unit address
+	Compatible []string
+	Reg        Region
+	Irqs       []uint32 //sources on interrupt parent
+	Parent     string   //interrupt parent device
+	HartIrqs   []uint32 //local interrupts wired to every hart
+	Controller bool     //interrupt controller with one cell specifier
+	Props      func(n *Node)
+}
+
+func (m *Machine) Device(name string) *Device {
+	for i := range m.Devices {
+		if m.Devices[i].Name == name {
+			return &m.Devices[i]
+		}
+	}
+	return nil
+}
+
+// harts' interrupt controllers come first,then devices in order
+func (m *Machine) Phandle(name string) uint32 {
+	for i := range m.Devices {
+		if m.Devices[i].Name == name {
+			return uint32(len(m.Harts) + i + 1)
+		}
+	}
+	return 0
+}
+
+func (m *Machine) intcPhandle(hart int) uint32 {
+	return uint32(hart + 1)
+}
+
+func reg(r Region) []uint32 {
+	return append(Cells64(r.Base), Cells64(r.Size)...)
+}
+
+// device tree with 2 cell addresses and sizes
+func (m *Machine) Tree() *Node {
+
+	root := NewNode("")
+	root.SetCells("#address-cells", 2)
+	root.SetCells("#size-cells", 2)
+	root.SetString("compatible", m.Compatible)
+	root.SetString("model", m.Model)
+
+	chosen := root.AddChild("chosen")
+	chosen.SetString("bootargs", m.Bootargs)
+	if dev := m.Device(m.Stdout); dev != nil {
+		chosen.SetString("stdout-path", fmt.Sprintf("/soc/%s@%x", dev.Name, dev.Reg.Base))
+	}
+
+	for _, r := range m.Memory {
+		memory := root.AddChild(fmt.Sprintf("memory@%x", r.Base))
+		memory.SetString("device_type", "memory")
+		memory.SetCells("reg", reg(r)...)
+	}
+
+	cpus := root.AddChild("cpus")
+	cpus.SetCells("#address-cells", 1)
+	cpus.SetCells("#size-cells", 0)
+	cpus.SetCells("timebase-frequency", m.Timebase)
+	for i, h := range m.Harts {
+		hart := cpus.AddChild(fmt.Sprintf("cpu@%d", i))
+		hart.SetString("device_type", "cpu")
+		hart.SetCells("reg", uint32(i))
+		hart.SetString("status", "okay")
+		hart.SetString("compatible", "riscv")
+		hart.SetString("riscv,isa", h.Isa)
+		if h.Mmu != "" {
+			hart.SetString("mmu-type", h.Mmu)
+		}
+		intc := hart.AddChild("interrupt-controller")
+		intc.SetCells("#interrupt-cells", 1)
+		intc.SetEmpty("interrupt-controller")
+		intc.SetString("compatible", "riscv,cpu-intc")
+		intc.SetCells("phandle", m.intcPhandle(i))
+	}
+
+	soc := root.AddChild("soc")
+	soc.SetCells("#address-cells", 2)
+	soc.SetCells("#size-cells", 2)
+	soc.SetString("compatible", "simple-bus")
+	soc.SetEmpty("ranges")
+
+	for _, d := range m.Devices {
+		node := soc.AddChild(fmt.Sprintf("%s@%x", d.Name, d.Reg.Base))
+		node.SetString("compatible", d.Compatible...)
+		node.SetCells("reg", reg(d.Reg)...)
+		if d.Controller {
+			node.SetCells("#address-cells", 0)
+			node.SetCells("#interrupt-cells", 1)
+			node.SetEmpty("interrupt-controller")
+		}
+		if len(d.HartIrqs) != 0 {
+			var cells []uint32
+			for i := range m.Harts {
+				for _, irq := range d.HartIrqs {
+					cells = append(cells, m.intcPhandle(i), irq)
+				}
+			}
+			node.SetCells("interrupts-extended", cells...)
+		}
+		if len(d.Irqs) != 0 {
+			node.SetCells("interrupts", d.Irqs...)
+			node.SetCells("interrupt-parent", m.Phandle(d.Parent))
+		}
+		if d.Props != nil {
+			d.Props(node)
+		}
+		node.SetCells("phandle", m.Phandle(d.Name))
+	}
+
+	root.Children = append(root.Children, m.Nodes...)
+	return root
+}
+
+func (m *Machine) Blob() []byte {
+	return Blob(m.Tree(), 0)
+}
@@ -0,0 +1,75 @@
+package fdt
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestMachineTree(t *testing.T) {
+
+	m := &Machine{
+		Model:      "test",
+		Compatible: "test",
+		Timebase:   1000,
+		Stdout:     "serial",
+		Harts:      []Hart{{Isa: "rv32ima"}, {Isa: "rv32ima", Mmu: "riscv,sv32"}},
+		Memory:     []Region{{Base: 0x80000000, Size: 0x100000}},
+		Devices: []Device{
+			{Name: "plic", Compatible: []string{"riscv,plic0"}, Reg: Region{Base: 0xc000000, Size: 0x1000},
+				HartIrqs: []uint32{11}, Controller: true},
+			{Name: "serial", Compatible: []string{"ns16550a"}, Reg: Region{Base: 0x10000000, Size: 0x100},
+				Irqs: []uint32{10}, Parent: "plic"},
+		},
+	}
+
+	be := binary.BigEndian
+	props := parseBlob(m.Blob())
+	cells := func(key string) []uint32 {
+		var c []uint32
+		for i := 0; i+4 <= len(props[key]); i += 4 {
+			c = append(c, be.Uint32(props[key][i:]))
+		}
+		return c
+	}
+	equal := func(a []uint32, b ...uint32) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	//hart intc phandles 1 and 2,devices follow
+	checks := []struct {
+		key  string
+		want []uint32
+	}{
+		{"/memory@80000000:reg", []uint32{0, 0x80000000, 0, 0x100000}},
+		{"/cpus:timebase-frequency", []uint32{1000}},
+		{"/cpus/cpu@1:reg", []uint32{1}},
+		{"/cpus/cpu@1/interrupt-controller:phandle", []uint32{2}},
+		{"/soc/plic@c000000:phandle", []uint32{3}},
+		{"/soc/plic@c000000:interrupts-extended", []uint32{1, 11, 2, 11}},
+		{"/soc/plic@c000000:#interrupt-cells", []uint32{1}},
+		{"/soc/serial@10000000:interrupt-parent", []uint32{3}},
+		{"/soc/serial@10000000:interrupts", []uint32{10}},
+		{"/soc/serial@10000000:reg", []uint32{0, 0x10000000, 0, 0x100}},
+	}
+	for _, c := range checks {
+		if !equal(cells(c.key), c.want...) {
+			t.Errorf("\"TestMachineTree()\" FAILED, %s expected -> %x got -> %x", c.key, c.want, cells(c.key))
+		}
+	}
+	if string(props["/chosen:stdout-path"]) != "/soc/serial@10000000\x00" ||
+		string(props["/cpus/cpu@1:mmu-type"]) != "riscv,sv32\x00" {
+		t.Errorf("\"TestMachineTree()\" FAILED, stdout-path -> %q", props["/chosen:stdout-path"])
+	}
+	if _, ok := props["/cpus/cpu@0:mmu-type"]; ok {
+		t.Errorf("\"TestMachineTree()\" FAILED, mmu-type on bare hart")
+	}
+
+}
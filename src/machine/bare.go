@@ -0,0 +1,79 @@
+package machine
+
+import (
+	"Go_emu/src/cpu"
+	"Go_emu/src/fdt"
+)
+
+// framebuffer of terminal display,one word per pixel
+const (
+	FRAMEBUFFER_BASE   = 30032
+	FRAMEBUFFER_WIDTH  = 64
+	FRAMEBUFFER_HEIGHT = 32
+	FRAMEBUFFER_SIZE   = FRAMEBUFFER_WIDTH * FRAMEBUFFER_HEIGHT * 4
+
+	BARE_KEYBOARD = FRAMEBUFFER_BASE + FRAMEBUFFER_SIZE //word after framebuffer,last key pressed,guest clears it
+
+	BARE_TIMEBASE = 1000000 //nominal,board has no platform timer
+)
+
+// original board,ram at address 0 and framebuffer inside ram.
+// device tree is placed at top of ram,a1 holds its address
+type Bare struct {
+	Cpu        *cpu.Cpu
+	Dtb        []byte
+	DtbAddress uint64
+}
+
+func NewBare(c *cpu.Cpu) *Bare {
+
+	b := &Bare{Cpu: c}
+	b.Dtb = b.Description().Blob()
+	b.DtbAddress = (c.Ram.Size() - uint64(len(b.Dtb))) &^ 0xFFF
+	for i, v := range b.Dtb {
+		c.Ram.Write(uint32(b.DtbAddress)+uint32(i), 1, uint64(v))
+	}
+
+	c.SetRegister(10, 0)
+	c.SetRegister(11, b.DtbAddress)
+	//no firmware programs pmp,rom may drop to s or u mode
+	c.PmpAllowAll()
+	return b
+}
+
+func (b *Bare) Description() *fdt.Machine {
+
+	fb := fdt.Device{
+		Name:       "framebuffer",
+		Compatible: []string{"simple-framebuffer"},
+		Reg:        fdt.Region{Base: FRAMEBUFFER_BASE, Size: FRAMEBUFFER_SIZE},
+		Props: func(n *fdt.Node) {
+			n.SetCells("width", FRAMEBUFFER_WIDTH)
+			n.SetCells("height", FRAMEBUFFER_HEIGHT)
+			n.SetCells("stride", FRAMEBUFFER_WIDTH*4)
+			n.SetString("format", "a8r8g8b8")
+		},
+	}
+	return &fdt.Machine{
+		Model:      "go-emu,bare",
+		Compatible: "go-emu,bare",
+		Timebase:   BARE_TIMEBASE,
+		Harts:      []fdt.Hart{{Isa: b.Cpu.IsaString()}},
+		Memory:     []fdt.Region{{Base: 0, Size: b.Cpu.Ram.Size()}},
+		Devices:    []fdt.Device{fb},
+	}
+}
+
+// host input goes through log,pushed "key" source
+// is written to keyboard word
+func (b *Bare) AttachInput(l *cpu.InputLog) {
+	l.Sink("key", b.Key)
+	b.Cpu.Input = l
+}
+
+// latest of pressed keys
+func (b *Bare) Key(data []byte) {
+	if len(data) > 0 {
+		b.Cpu.Ram.SetLine(BARE_KEYBOARD, uint32(data[len(data)-1]))
+	}
+}
@@ -0,0 +1,33 @@
+package machine
+
+import (
+	"Go_emu/src/cpu"
+	"Go_emu/src/ram"
+	"bytes"
+	"testing"
+)
+
+func TestBareDtb(t *testing.T) {
+
+	hart := &cpu.Cpu{}
+	board := NewBare(hart)
+
+	placed := make([]byte, len(board.Dtb))
+	for i := range placed {
+		placed[i] = byte(hart.Ram.Read(uint32(board.DtbAddress)+uint32(i), 1))
+	}
+	if !bytes.Equal(placed, board.Dtb) || hart.Register(11) != board.DtbAddress ||
+		board.DtbAddress&0xFFF != 0 || board.DtbAddress+uint64(len(board.Dtb)) > ram.DEFAULT_SIZE {
+		t.Errorf("\"TestBareDtb()\" FAILED, dtb at %x a1 -> %x", board.DtbAddress, hart.Register(11))
+	}
+	//dtb must not overlap framebuffer of display
+	if board.DtbAddress < FRAMEBUFFER_BASE+FRAMEBUFFER_SIZE {
+		t.Errorf("\"TestBareDtb()\" FAILED, dtb overlaps framebuffer")
+	}
+	for _, want := range []string{"rv32ima_zicsr_zifencei_zkne_zknd_zknh_zkr\x00", "simple-framebuffer\x00", "framebuffer@7550\x00"} {
+		if !bytes.Contains(board.Dtb, []byte(want)) {
+			t.Errorf("\"TestBareDtb()\" FAILED, %q missing", want)
+		}
+	}
+
+}
@@ -0,0 +1,131 @@
+package machine
+
+import (
+	"Go_emu/src/cpu"
+	"Go_emu/src/fdt"
+	"Go_emu/src/ram"
+)
+
+// memory mapped device,offsets are relative to region base
+type Device interface {
+	Read(offset uint64, size uint32) uint64
+	Write(offset uint64, size uint32, val uint64)
+}
+
+// device listed in generated device tree,
+// reg is filled from bus region
+type Describer interface {
+	Describe() fdt.Device
+}
+
+type region struct {
+	name string
+	base uint64
+	size uint64
+	dev  Device
+	exec bool //instruction fetch allowed
+	wait cpu.WaitStates
+}
+
+// physical address decoder of machine
+type SystemBus struct {
+	regions []region
+}
+
+func (bus *SystemBus) Map(name string, base uint64, size uint64, dev Device, exec bool) {
+	bus.regions = append(bus.regions, region{name: name, base: base, size: size, dev: dev, exec: exec})
+}
+
+// wait states of mapped region,false if name is not mapped
+func (bus *SystemBus) SetWaitStates(name string, wait cpu.WaitStates) bool {
+	for i := range bus.regions {
+		if bus.regions[i].name == name {
+			bus.regions[i].wait = wait
+			return true
+		}
+	}
+	return false
+}
+
+func (bus *SystemBus) WaitStates(address uint64) cpu.WaitStates {
+	if r := bus.find(address, 1); r != nil {
+		return r.wait
+	}
+	return cpu.WaitStates{}
+}
+
+func (bus *SystemBus) find(address uint64, size uint32) *region {
+	for i := range bus.regions {
+		r := &bus.regions[i]
+		if address >= r.base && address+uint64(size) <= r.base+r.size {
+			return r
+		}
+	}
+	return nil
+}
+
+func (bus *SystemBus) Mapped(address uint64, size uint32, access cpu.AccessType) bool {
+	r := bus.find(address, size)
+	return r != nil && (access != cpu.ACCESS_FETCH || r.exec)
+}
+
+func (bus *SystemBus) Read(address uint64, size uint32) uint64 {
+	if r := bus.find(address, size); r != nil {
+		return r.dev.Read(address-r.base, size)
+	}
+	return 0
+}
+
+func (bus *SystemBus) Write(address uint64, size uint32, val uint64) {
+	if r := bus.find(address, size); r != nil {
+		r.dev.Write(address-r.base, size, val)
+	}
+}
+
+// memory nodes from ram regions,device nodes from describers
+func (bus *SystemBus) Describe() ([]fdt.Region, []fdt.Device) {
+	var memory []fdt.Region
+	var devices []fdt.Device
+	for _, r := range bus.regions {
+		switch dev := r.dev.(type) {
+		case RamDevice:
+			memory = append(memory, fdt.Region{Base: r.base, Size: r.size})
+		case Describer:
+			d := dev.Describe()
+			d.Reg = fdt.Region{Base: r.base, Size: r.size}
+			devices = append(devices, d)
+		}
+	}
+	return memory, devices
+}
+
+// main memory as bus device
+type RamDevice struct {
+	Ram *ram.Ram
+}
+
+func (r RamDevice) Read(offset uint64, size uint32) uint64 {
+	return r.Ram.Read(uint32(offset), size)
+}
+
+func (r RamDevice) Write(offset uint64, size uint32, val uint64) {
+	r.Ram.Write(uint32(offset), size, val)
+}
+
+// size byte slice of 64 bit register at byte offset
+func readReg64(reg uint64, offset uint64, size uint32) uint64 {
+	val := reg >> (8 * (offset & 7))
+	if size < 8 {
+		val &= 1<<(8*size) - 1
+	}
+	return val
+}
+
+func writeReg64(reg uint64, offset uint64, size uint32, val uint64) uint64 {
+	mask := ^uint64(0)
+	if size < 8 {
+		mask = 1<<(8*size) - 1
+	}
+	shift := 8 * (offset & 7)
+	return reg&^(mask<<shift) | (val&mask)<<shift
+}
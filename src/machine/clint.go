@@ -0,0 +1,76 @@
+package machine
+
+import (
+	"Go_emu/src/cpu"
+	"Go_emu/src/fdt"
+)
+
+// core local interruptor register offsets for hart 0
+const (
+	CLINT_MSIP     = 0x0
+	CLINT_MTIMECMP = 0x4000
+	CLINT_MTIME    = 0xBFF8
+	CLINT_SIZE     = 0x10000
+)
+
+// machine timer and software interrupt,
+// mtime advances once per clock cycle.
+// lines reach hart at end of cycle,see Virt.ClockCycle
+type Clint struct {
+	cpu      *cpu.Cpu
+	Msip     uint32
+	Mtimecmp uint64
+	Mtime    uint64
+}
+
+func NewClint(c *cpu.Cpu) *Clint {
+	return &Clint{cpu: c, Mtimecmp: ^uint64(0)}
+}
+
+func (c *Clint) Read(offset uint64, size uint32) uint64 {
+	switch {
+	case offset == CLINT_MSIP:
+		return uint64(c.Msip)
+	case offset >= CLINT_MTIMECMP && offset < CLINT_MTIMECMP+8:
+		return readReg64(c.Mtimecmp, offset, size)
+	case offset >= CLINT_MTIME && offset < CLINT_MTIME+8:
+		return c.cpu.Observe("mtime", func() uint64 { return readReg64(c.Mtime, offset, size) })
+	}
+	return 0
+}
+
+func (c *Clint) Write(offset uint64, size uint32, val uint64) {
+	switch {
+	case offset == CLINT_MSIP:
+		c.Msip = uint32(val) & 1
+	case offset >= CLINT_MTIMECMP && offset < CLINT_MTIMECMP+8:
+		c.Mtimecmp = writeReg64(c.Mtimecmp, offset, size, val)
+	case offset >= CLINT_MTIME && offset < CLINT_MTIME+8:
+		c.Mtime = writeReg64(c.Mtime, offset, size, val)
+	}
+}
+
+func (c *Clint) Tick() {
+	c.Mtime++
+}
+
+// sets hart interrupt lines from registers
+func (c *Clint) drive() {
+	c.cpu.SetInterruptPending(cpu.IRQ_M_TIMER, c.Mtime >= c.Mtimecmp)
+	c.cpu.SetInterruptPending(cpu.IRQ_M_SOFT, c.Msip != 0)
+}
+
+func (c *Clint) Describe() fdt.Device {
+	return fdt.Device{
+		Name:       "clint",
+		Compatible: []string{"sifive,clint0", "riscv,clint0"},
+		HartIrqs:   []uint32{cpu.IRQ_M_SOFT, cpu.IRQ_M_TIMER},
+	}
+}
+
+func (c *Clint) Snap(s *cpu.Snapshot) {
+	s.Section("clint")
+	s.Value(&c.Msip)
+	s.Value(&c.Mtimecmp)
+	s.Value(&c.Mtime)
+}
@@ -0,0 +1,139 @@
+package machine
+
+import (
+	"Go_emu/src/cpu"
+	"Go_emu/src/fdt"
+)
+
+// platform level interrupt controller register offsets
+const (
+	PLIC_PRIORITY  = 0x0
+	PLIC_PENDING   = 0x1000
+	PLIC_ENABLE    = 0x2000
+	PLIC_CONTEXT   = 0x200000
+	PLIC_SIZE      = 0x600000
+	PLIC_SOURCES   = 32 //source 0 is reserved
+	PLIC_CONTEXTS  = 2  //hart 0 m mode and s mode
+	PLIC_ENABLE_SZ = 0x80
+	PLIC_CTX_SZ    = 0x1000
+)
+
+// level triggered sources,claimed source is not pending
+// again before completion. lines reach hart at end of cycle
+type Plic struct {
+	cpu       *cpu.Cpu
+	priority  [PLIC_SOURCES]uint32
+	level     uint32
+	pending   uint32
+	inflight  uint32
+	enable    [PLIC_CONTEXTS]uint32
+	threshold [PLIC_CONTEXTS]uint32
+}
+
+func NewPlic(c *cpu.Cpu) *Plic {
+	return &Plic{cpu: c}
+}
+
+// interrupt line of device
+func (p *Plic) SetLevel(source uint32, high bool) {
+	if high {
+		p.level |= 1 << source
+	} else {
+		p.level &^= 1 << source
+	}
+	p.update()
+}
+
+// highest priority pending enabled source above threshold,0 if none
+func (p *Plic) best(ctx int, threshold uint32) uint32 {
+	var id, prio uint32
+	for s := uint32(1); s < PLIC_SOURCES; s++ {
+		if p.pending&p.enable[ctx]&(1<<s) != 0 && p.priority[s] > threshold && p.priority[s] > prio {
+			id, prio = s, p.priority[s]
+		}
+	}
+	return id
+}
+
+func (p *Plic) update() {
+	p.pending |= p.level &^ p.inflight
+}
+
+// sets hart interrupt lines from pending sources
+func (p *Plic) drive() {
+	p.cpu.SetInterruptPending(cpu.IRQ_M_EXT, p.best(0, p.threshold[0]) != 0)
+	p.cpu.SetInterruptPending(cpu.IRQ_S_EXT, p.best(1, p.threshold[1]) != 0)
+}
+
+func (p *Plic) Read(offset uint64, size uint32) uint64 {
+	switch {
+	case offset < PLIC_SOURCES*4:
+		return uint64(p.priority[offset/4])
+	case offset == PLIC_PENDING:
+		return uint64(p.pending)
+	case offset >= PLIC_ENABLE && offset < PLIC_ENABLE+PLIC_CONTEXTS*PLIC_ENABLE_SZ:
+		if offset%PLIC_ENABLE_SZ == 0 {
+			return uint64(p.enable[(offset-PLIC_ENABLE)/PLIC_ENABLE_SZ])
+		}
+	case offset >= PLIC_CONTEXT && offset < PLIC_CONTEXT+PLIC_CONTEXTS*PLIC_CTX_SZ:
+		ctx := (offset - PLIC_CONTEXT) / PLIC_CTX_SZ
+		switch offset % PLIC_CTX_SZ {
+		case 0:
+			return uint64(p.threshold[ctx])
+		//claim
+		case 4:
+			id := p.best(int(ctx), 0)
+			if id != 0 {
+				p.pending &^= 1 << id
+				p.inflight |= 1 << id
+				p.update()
+			}
+			return uint64(id)
+		}
+	}
+	return 0
+}
+
+func (p *Plic) Write(offset uint64, size uint32, val uint64) {
+	switch {
+	case offset < PLIC_SOURCES*4:
+		if offset != 0 {
+			p.priority[offset/4] = uint32(val) & 7
+		}
+	case offset >= PLIC_ENABLE && offset < PLIC_ENABLE+PLIC_CONTEXTS*PLIC_ENABLE_SZ:
+		if offset%PLIC_ENABLE_SZ == 0 {
+			p.enable[(offset-PLIC_ENABLE)/PLIC_ENABLE_SZ] = uint32(val) &^ 1
+		}
+	case offset >= PLIC_CONTEXT && offset < PLIC_CONTEXT+PLIC_CONTEXTS*PLIC_CTX_SZ:
+		ctx := (offset - PLIC_CONTEXT) / PLIC_CTX_SZ
+		switch offset % PLIC_CTX_SZ {
+		case 0:
+			p.threshold[ctx] = uint32(val) & 7
+		//complete
+		case 4:
+			if uint32(val) < PLIC_SOURCES {
+				p.inflight &^= 1 << val
+			}
+		}
+	}
+	p.update()
+}
+
+func (p *Plic) Describe() fdt.Device {
+	return fdt.Device{
+		Name:       "plic",
+		Compatible: []string{"sifive,plic-1.0.0", "riscv,plic0"},
+		HartIrqs:   []uint32{cpu.IRQ_M_EXT, cpu.IRQ_S_EXT},
+		Controller: true,
+		Props: func(n *fdt.Node) {
+			n.SetCells("riscv,ndev", PLIC_SOURCES-1)
+		},
+	}
+}
+
+func (p *Plic) Snap(s *cpu.Snapshot) {
+	s.Section("plic")
+	for _, v := range []any{&p.priority, &p.level, &p.pending, &p.inflight, &p.enable, &p.threshold} {
+		s.Value(v)
+	}
+}
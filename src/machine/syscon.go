@@ -0,0 +1,53 @@
+package machine
+
+import (
+	"Go_emu/src/cpu"
+	"Go_emu/src/fdt"
+)
+
+// sifive test device values,fail carries exit code in upper half
+const (
+	SYSCON_FAIL     = 0x3333
+	SYSCON_POWEROFF = 0x5555
+	SYSCON_REBOOT   = 0x7777
+	SYSCON_SIZE     = 0x1000
+)
+
+// poweroff and reboot register used by syscon-poweroff/syscon-reboot
+type Syscon struct {
+	Halted   bool
+	Reboot   bool
+	ExitCode uint32
+}
+
+func (s *Syscon) Read(offset uint64, size uint32) uint64 {
+	return 0
+}
+
+func (s *Syscon) Write(offset uint64, size uint32, val uint64) {
+	if offset != 0 {
+		return
+	}
+	switch val & 0xFFFF {
+	case SYSCON_FAIL:
+		s.Halted = true
+		s.ExitCode = uint32(val >> 16)
+	case SYSCON_POWEROFF:
+		s.Halted = true
+		s.ExitCode = 0
+	case SYSCON_REBOOT:
+		s.Reboot = true
+	}
+}
+
+func (s *Syscon) Describe() fdt.Device {
+	return fdt.Device{
+		Name:       "test",
+		Compatible: []string{"sifive,test1", "sifive,test0", "syscon"},
+	}
+}
+
+func (s *Syscon) Snap(snap *cpu.Snapshot) {
+	snap.Section("syscon")
+	snap.Value(s)
+}
@@ -0,0 +1,174 @@
+package machine
+
+import (
+	"Go_emu/src/cpu"
+	"Go_emu/src/fdt"
+	"io"
+	"sync"
+)
+
+// ns16550a registers
+const (
+	UART_RBR = 0 //receive buffer,transmit holding,divisor latch low
+	UART_IER = 1
+	UART_IIR = 2 //interrupt identification,fifo control on write
+	UART_LCR = 3
+	UART_MCR = 4
+	UART_LSR = 5
+	UART_MSR = 6
+	UART_SCR = 7
+
+	UART_SIZE = 0x100
+)
+
+const (
+	UART_LCR_DLAB = 0x80
+	UART_LSR_DR   = 0x01
+	UART_LSR_THRE = 0x20
+	UART_LSR_TEMT = 0x40
+	UART_IER_RDI  = 0x01
+	UART_IER_THRI = 0x02
+)
+
+// 16550 compatible uart,transmit is immediate
+type Uart struct {
+	Out  io.Writer
+	mu   sync.Mutex
+	rx   []byte
+	ier  uint8
+	lcr  uint8
+	mcr  uint8
+	scr  uint8
+	fcr  uint8
+	dll  uint8
+	dlm  uint8
+	thre bool //transmitter empty interrupt waiting for IIR read
+}
+
+func NewUart(out io.Writer) *Uart {
+	return &Uart{Out: out}
+}
+
+// queues bytes for guest to receive
+func (u *Uart) Input(data []byte) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rx = append(u.rx, data...)
+}
+
+// interrupt line level
+func (u *Uart) Irq() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return (u.ier&UART_IER_RDI != 0 && len(u.rx) > 0) || (u.ier&UART_IER_THRI != 0 && u.thre)
+}
+
+func (u *Uart) Read(offset uint64, size uint32) uint64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	dlab := u.lcr&UART_LCR_DLAB != 0
+	switch offset {
+	case UART_RBR:
+		if dlab {
+			return uint64(u.dll)
+		}
+		if len(u.rx) == 0 {
+			return 0
+		}
+		b := u.rx[0]
+		u.rx = u.rx[1:]
+		return uint64(b)
+	case UART_IER:
+		if dlab {
+			return uint64(u.dlm)
+		}
+		return uint64(u.ier)
+	case UART_IIR:
+		iir := uint64(0x01)
+		switch {
+		case u.ier&UART_IER_RDI != 0 && len(u.rx) > 0:
+			iir = 0x04
+		case u.ier&UART_IER_THRI != 0 && u.thre:
+			iir = 0x02
+			u.thre = false
+		}
+		if u.fcr&1 != 0 {
+			iir |= 0xC0
+		}
+		return iir
+	case UART_LCR:
+		return uint64(u.lcr)
+	case UART_MCR:
+		return uint64(u.mcr)
+	case UART_LSR:
+		lsr := uint64(UART_LSR_THRE | UART_LSR_TEMT)
+		if len(u.rx) > 0 {
+			lsr |= UART_LSR_DR
+		}
+		return lsr
+	case UART_MSR:
+		//carrier detect,data set ready,clear to send
+		return 0xB0
+	case UART_SCR:
+		return uint64(u.scr)
+	}
+	return 0
+}
+
+func (u *Uart) Write(offset uint64, size uint32, val uint64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	dlab := u.lcr&UART_LCR_DLAB != 0
+	b := uint8(val)
+	switch offset {
+	case UART_RBR:
+		if dlab {
+			u.dll = b
+			return
+		}
+		if u.Out != nil {
+			u.Out.Write([]byte{b})
+		}
+		u.thre = true
+	case UART_IER:
+		if dlab {
+			u.dlm = b
+			return
+		}
+		u.ier = b & 0x0F
+		u.thre = u.ier&UART_IER_THRI != 0
+	case UART_IIR:
+		u.fcr = b
+		//receive fifo reset
+		if b&0x02 != 0 {
+			u.rx = nil
+		}
+	case UART_LCR:
+		u.lcr = b
+	case UART_MCR:
+		u.mcr = b
+	case UART_SCR:
+		u.scr = b
+	}
+}
+
+func (u *Uart) Describe() fdt.Device {
+	return fdt.Device{
+		Name:       "serial",
+		Compatible: []string{"ns16550a"},
+		Props: func(n *fdt.Node) {
+			n.SetCells("clock-frequency", 3686400)
+		},
+	}
+}
+
+// receive queue and registers,output writer is kept
+func (u *Uart) Snap(s *cpu.Snapshot) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	s.Section("uart")
+	s.Bytes(&u.rx)
+	for _, v := range []any{&u.ier, &u.lcr, &u.mcr, &u.scr, &u.fcr, &u.dll, &u.dlm, &u.thre} {
+		s.Value(v)
+	}
+}
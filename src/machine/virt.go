@@ -0,0 +1,174 @@
+package machine
+
+import (
+	"Go_emu/src/cpu"
+	"Go_emu/src/fdt"
+	"Go_emu/src/ram"
+	"debug/elf"
+	"fmt"
+	"io"
+)
+
+// qemu virt board memory map
+const (
+	VIRT_TEST_BASE  = 0x100000
+	VIRT_CLINT_BASE = 0x2000000
+	VIRT_PLIC_BASE  = 0xc000000
+	VIRT_UART0_BASE = 0x10000000
+	VIRT_DRAM_BASE  = 0x80000000
+
+	VIRT_UART0_IRQ = 10
+	VIRT_TIMEBASE  = 10000000
+)
+
+// hart on qemu virt compatible board,
+// boots from start of dram with a0 = hartid and a1 = dtb address
+type Virt struct {
+	Cpu        *cpu.Cpu
+	Core       cpu.Core //timing model clocked by board,hart itself unless replaced
+	Bus        *SystemBus
+	Clint      *Clint
+	Plic       *Plic
+	Uart       *Uart
+	Syscon     *Syscon
+	RamSize    uint32
+	Dtb        []byte
+	DtbAddress uint64
+}
+
+// fails when ram is too small for device tree
+func NewVirt(c *cpu.Cpu, ramSize uint32, console io.Writer) (*Virt, error) {
+
+	v := &Virt{
+		Cpu:     c,
+		Core:    c,
+		Bus:     &SystemBus{},
+		Clint:   NewClint(c),
+		Plic:    NewPlic(c),
+		Uart:    NewUart(console),
+		Syscon:  &Syscon{},
+		RamSize: ramSize,
+	}
+	c.Ram = ram.NewRam(ramSize)
+	c.Bus = v.Bus
+	v.Bus.Map("ram", VIRT_DRAM_BASE, uint64(ramSize), RamDevice{Ram: &c.Ram}, true)
+	v.Bus.Map("test", VIRT_TEST_BASE, SYSCON_SIZE, v.Syscon, false)
+	v.Bus.Map("clint", VIRT_CLINT_BASE, CLINT_SIZE, v.Clint, false)
+	v.Bus.Map("plic", VIRT_PLIC_BASE, PLIC_SIZE, v.Plic, false)
+	v.Bus.Map("uart", VIRT_UART0_BASE, UART_SIZE, v.Uart, false)
+
+	c.RegisterCsr(cpu.CSR_TIME, cpu.Csr{Read: func() uint64 { return v.Clint.Mtime }, Input: "time"})
+
+	//device tree at top of ram,page aligned
+	v.Dtb = v.Description().Blob()
+	v.DtbAddress = (VIRT_DRAM_BASE + uint64(ramSize) - uint64(len(v.Dtb))) &^ 0xFFF
+	if err := v.LoadImage(v.Dtb, v.DtbAddress); err != nil {
+		return nil, fmt.Errorf("device tree: %v", err)
+	}
+
+	c.SetPc(VIRT_DRAM_BASE)
+	c.SetRegister(10, 0)
+	c.SetRegister(11, v.DtbAddress)
+	return v, nil
+}
+
+// copies firmware or kernel image to physical address in dram
+func (v *Virt) LoadImage(data []byte, address uint64) error {
+	if address < VIRT_DRAM_BASE || address+uint64(len(data)) > VIRT_DRAM_BASE+uint64(v.RamSize) {
+		return fmt.Errorf("image of %d bytes at %#x is outside of ram", len(data), address)
+	}
+	for i, b := range data {
+		v.Cpu.Ram.Write(uint32(address-VIRT_DRAM_BASE)+uint32(i), 1, uint64(b))
+	}
+	return nil
+}
+
+// copies loadable segments of elf file to their physical
+// addresses and starts hart at entry point
+func (v *Virt) LoadElf(r io.ReaderAt) error {
+
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return err
+	}
+	if f.Machine != elf.EM_RISCV {
+		return fmt.Errorf("elf machine %v is not risc-v", f.Machine)
+	}
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_LOAD || p.Memsz == 0 {
+			continue
+		}
+		//bss part is zero filled
+		data := make([]byte, p.Memsz)
+		if _, err := p.ReadAt(data[:p.Filesz], 0); err != nil {
+			return err
+		}
+		if err := v.LoadImage(data, p.Paddr); err != nil {
+			return err
+		}
+	}
+	v.Cpu.SetPc(f.Entry)
+	return nil
+}
+
+// one hart cycle,then devices update interrupt lines.
+// mmio store runs in memory stage while execute stage reads mip,
+// so lines change only here between cycles
+func (v *Virt) ClockCycle() {
+	v.Core.ClockCycle()
+	v.Clint.Tick()
+	v.Plic.SetLevel(VIRT_UART0_IRQ, v.Uart.Irq())
+	v.Clint.drive()
+	v.Plic.drive()
+}
+
+// hart and device state,board must be built with same ram size
+func (v *Virt) Snap(s *cpu.Snapshot) {
+	v.Core.Snap(s)
+	for _, dev := range []cpu.Snapper{v.Clint, v.Plic, v.Uart, v.Syscon} {
+		dev.Snap(s)
+	}
+}
+
+// host input goes through log,uart receives pushed "uart" source
+func (v *Virt) AttachInput(l *cpu.InputLog) {
+	l.Sink("uart", v.Uart.Input)
+	v.Cpu.Input = l
+}
+
+func (v *Virt) Halted() bool {
+	return v.Syscon.Halted || v.Syscon.Reboot
+}
+
+// machine description of board,device tree source
+func (v *Virt) Description() *fdt.Machine {
+
+	desc := &fdt.Machine{
+		Model:      "riscv-virtio,qemu",
+		Compatible: "riscv-virtio",
+		Timebase:   VIRT_TIMEBASE,
+		Stdout:     "serial",
+		Harts:      []fdt.Hart{{Isa: v.Cpu.IsaString()}},
+	}
+	if v.Cpu.Xlen != 64 {
+		desc.Harts[0].Mmu = "riscv,sv32"
+	}
+	desc.Memory, desc.Devices = v.Bus.Describe()
+	serial := desc.Device("serial")
+	serial.Irqs = []uint32{VIRT_UART0_IRQ}
+	serial.Parent = "plic"
+
+	syscon := desc.Phandle("test")
+	for _, s := range []struct {
+		name  string
+		value uint32
+	}{{"poweroff", SYSCON_POWEROFF}, {"reboot", SYSCON_REBOOT}} {
+		node := fdt.NewNode(s.name)
+		node.SetString("compatible", "syscon-"+s.name)
+		node.SetCells("regmap", syscon)
+		node.SetCells("offset", 0)
+		node.SetCells("value", s.value)
+		desc.Nodes = append(desc.Nodes, node)
+	}
+	return desc
+}
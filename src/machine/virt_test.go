@@ -0,0 +1,341 @@
+package machine
+
+import (
+	"Go_emu/src/cpu"
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// prints OK,reads uart and powers off from timer interrupt handler
+func virtBootImage() []byte {
+
+	var program = []uint32{
+		0x00050a13, //addi s4, a0, 0
+		0x0005aa83, //lw s5, 0(a1)
+		0x100002b7, //lui t0, 0x10000
+		0x04f00313, //addi t1, x0, 0x4f
+		0x00628023, //sb t1, 0(t0)
+		0x04b00313, //addi t1, x0, 0x4b
+		0x00628023, //sb t1, 0(t0)
+		0x00a00313, //addi t1, x0, 0xa
+		0x00628023, //sb t1, 0(t0)
+		0x0052c903, //lbu s2, 5(t0)
+		0x0002c983, //lbu s3, 0(t0)
+		0x800003b7, //lui t2, 0x80000
+		0x10038393, //addi t2, t2, 0x100
+		0x30539073, //csrrw x0, mtvec, t2
+		0x0200ce37, //lui t3, 0x200c
+		0xff8e0e13, //addi t3, t3, -8
+		0x000e2383, //lw t2, 0(t3)
+		0x06438393, //addi t2, t2, 100
+		0x02004eb7, //lui t4, 0x2004
+		0x007ea023, //sw t2, 0(t4)
+		0x000ea223, //sw x0, 4(t4)
+		0x08000313, //addi t1, x0, 0x80
+		0x30432073, //csrrs x0, mie, t1
+		0x30046073, //csrrsi x0, mstatus, 8
+		0x0000006f, //jal x0, 0
+	}
+	//timer interrupt handler powers machine off
+	var handler = []uint32{
+		0x342024f3, //csrrs s1, mcause, x0
+		0xc0102b73, //csrrs s6, time, x0
+		0x001002b7, //lui t0, 0x100
+		0x00005337, //lui t1, 0x5
+		0x55530313, //addi t1, t1, 0x555
+		0x0062a023, //sw t1, 0(t0)
+		0x0000006f, //jal x0, 0
+	}
+
+	image := make([]byte, 0x100+len(handler)*4)
+	for i, v := range program {
+		binary.LittleEndian.PutUint32(image[i*4:], v)
+	}
+	for i, v := range handler {
+		binary.LittleEndian.PutUint32(image[0x100+i*4:], v)
+	}
+	return image
+}
+
+func newTestVirt(t *testing.T, hart *cpu.Cpu, console io.Writer) *Virt {
+
+	virt, err := NewVirt(hart, 1<<20, console)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return virt
+}
+
+func TestVirtBoot(t *testing.T) {
+
+	console := &bytes.Buffer{}
+	hart := &cpu.Cpu{}
+	virt := newTestVirt(t, hart, console)
+	if err := virt.LoadImage(virtBootImage(), VIRT_DRAM_BASE); err != nil {
+		t.Fatal(err)
+	}
+	virt.Uart.Input([]byte("x"))
+
+	cycles := 0
+	for ; cycles < 5000 && !virt.Halted(); cycles++ {
+		virt.ClockCycle()
+	}
+
+	if !virt.Halted() || virt.Syscon.ExitCode != 0 {
+		t.Errorf("\"TestVirtBoot()\" FAILED, not powered off after %d cycles", cycles)
+	}
+	//a0 hartid,a1 points at dtb
+	if hart.Register(20) != 0 || hart.Register(21) != 0xedfe0dd0 || virt.DtbAddress&0xFFF != 0 {
+		t.Errorf("\"TestVirtBoot()\" FAILED, hartid -> %d dtb magic -> %x", hart.Register(20), hart.Register(21))
+	}
+	if console.String() != "OK\n" || hart.Register(18)&UART_LSR_DR == 0 || hart.Register(19) != 'x' {
+		t.Errorf("\"TestVirtBoot()\" uart FAILED, console -> %q lsr -> %x rbr -> %x",
+			console.String(), hart.Register(18), hart.Register(19))
+	}
+	if hart.Register(9) != 1<<31|cpu.IRQ_M_TIMER || hart.Register(22) < 100 {
+		t.Errorf("\"TestVirtBoot()\" timer FAILED, mcause -> %x time -> %d", hart.Register(9), hart.Register(22))
+	}
+
+}
+
+func TestPlicClaim(t *testing.T) {
+
+	hart := &cpu.Cpu{}
+	virt := newTestVirt(t, hart, nil)
+	bus := virt.Bus
+	irqs := func() uint64 { return hart.InterruptsPending() }
+
+	bus.Write(VIRT_PLIC_BASE+VIRT_UART0_IRQ*4, 4, 3)
+	bus.Write(VIRT_PLIC_BASE+PLIC_ENABLE, 4, 1<<VIRT_UART0_IRQ)
+	//receive data interrupt of uart
+	bus.Write(VIRT_UART0_BASE+UART_IER, 1, UART_IER_RDI)
+	virt.Uart.Input([]byte("ab"))
+	virt.ClockCycle()
+	if irqs()&(1<<cpu.IRQ_M_EXT) == 0 || irqs()&(1<<cpu.IRQ_S_EXT) != 0 {
+		t.Errorf("\"TestPlicClaim()\" FAILED, mip -> %x", irqs())
+	}
+
+	//claimed source is not pending until completed
+	claim := VIRT_PLIC_BASE + PLIC_CONTEXT + 4
+	if id := bus.Read(uint64(claim), 4); id != VIRT_UART0_IRQ {
+		t.Errorf("\"TestPlicClaim()\" FAILED, claim -> %d", id)
+	}
+	//line drops at end of cycle
+	virt.ClockCycle()
+	if irqs()&(1<<cpu.IRQ_M_EXT) != 0 || bus.Read(uint64(claim), 4) != 0 {
+		t.Errorf("\"TestPlicClaim()\" FAILED, claimed source still pending")
+	}
+	bus.Write(uint64(claim), 4, VIRT_UART0_IRQ)
+	virt.ClockCycle()
+	if irqs()&(1<<cpu.IRQ_M_EXT) == 0 {
+		t.Errorf("\"TestPlicClaim()\" FAILED, level source not pending after completion")
+	}
+
+	//threshold masks priority 3
+	bus.Write(VIRT_PLIC_BASE+PLIC_CONTEXT, 4, 3)
+	virt.ClockCycle()
+	if irqs()&(1<<cpu.IRQ_M_EXT) != 0 {
+		t.Errorf("\"TestPlicClaim()\" FAILED, threshold ignored")
+	}
+
+}
+
+func TestBusWaitStates(t *testing.T) {
+
+	hart := &cpu.Cpu{}
+	virt := newTestVirt(t, hart, nil)
+	bus := virt.Bus
+	if !bus.SetWaitStates("uart", cpu.WaitStates{Read: 4, Write: 2}) || bus.SetWaitStates("flash", cpu.WaitStates{}) {
+		t.Errorf("\"TestBusWaitStates()\" FAILED, region lookup by name")
+	}
+	if w := bus.WaitStates(VIRT_UART0_BASE + UART_IER); w.Read != 4 || w.Write != 2 {
+		t.Errorf("\"TestBusWaitStates()\" FAILED, uart -> %+v", w)
+	}
+	if w := bus.WaitStates(VIRT_DRAM_BASE); w.Read != 0 || w.Write != 0 {
+		t.Errorf("\"TestBusWaitStates()\" FAILED, ram -> %+v", w)
+	}
+
+}
+
+func TestVirtSnapshot(t *testing.T) {
+
+	console := &bytes.Buffer{}
+	virt := newTestVirt(t, &cpu.Cpu{}, console)
+	virt.LoadImage(virtBootImage(), VIRT_DRAM_BASE)
+	virt.Uart.Input([]byte("xy"))
+	for i := 0; i < 60; i++ {
+		virt.ClockCycle()
+	}
+	//uart has one byte left,timer is armed
+	checkpoint := bytes.Buffer{}
+	if err := cpu.SaveSnapshot(&checkpoint, virt); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newTestVirt(t, &cpu.Cpu{}, console)
+	if err := cpu.LoadSnapshot(&checkpoint, restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Clint.Mtime != virt.Clint.Mtime || restored.Clint.Mtimecmp != virt.Clint.Mtimecmp {
+		t.Errorf("\"TestVirtSnapshot()\" FAILED, devices not restored %+v", restored.Clint)
+	}
+	cycles := 0
+	for ; cycles < 5000 && !restored.Halted(); cycles++ {
+		restored.ClockCycle()
+	}
+	hart := restored.Cpu
+	if !restored.Halted() || hart.Register(19) != 'x' || restored.Uart.Read(UART_RBR, 1) != 'y' ||
+		hart.Register(9) != 1<<31|cpu.IRQ_M_TIMER || console.String() != "OK\n" {
+		t.Errorf("\"TestVirtSnapshot()\" FAILED, halted %v rbr -> %x mcause -> %x console -> %q",
+			restored.Halted(), hart.Register(19), hart.Register(9), console.String())
+	}
+
+}
+
+func TestVirtInputReplay(t *testing.T) {
+
+	boot := func(input *cpu.InputLog, push string) *Virt {
+		virt := newTestVirt(t, &cpu.Cpu{}, &bytes.Buffer{})
+		virt.LoadImage(virtBootImage(), VIRT_DRAM_BASE)
+		virt.AttachInput(input)
+		input.Push("uart", []byte(push))
+		for cycles := 0; cycles < 5000 && !virt.Halted() && input.Err() == nil; cycles++ {
+			virt.ClockCycle()
+		}
+		return virt
+	}
+
+	log := bytes.Buffer{}
+	recorded := boot(cpu.NewInputRecorder(&log), "x")
+	//byte arrives in first cycle,mtime is read by boot code and time csr by handler
+	events := regexp.MustCompile(`(?m)^1 uart "x"\n\d+ mtime =0x\w+\n\d+ time =0x\w+\n`)
+	if !recorded.Halted() || recorded.Cpu.Register(19) != 'x' || !events.MatchString(log.String()) {
+		t.Fatalf("\"TestVirtInputReplay()\" FAILED, halted %v\n%s", recorded.Halted(), log.String())
+	}
+
+	//host input is dropped while replaying
+	replay, err := cpu.NewInputReplay(bytes.NewReader(log.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := boot(replay, "q")
+	for _, r := range []uint32{9, 19, 22} {
+		if replayed.Cpu.Register(r) != recorded.Cpu.Register(r) || replay.Err() != nil || !replayed.Halted() {
+			t.Errorf("\"TestVirtInputReplay()\" FAILED, x%d -> %#x recorded %#x %v",
+				r, replayed.Cpu.Register(r), recorded.Cpu.Register(r), replay.Err())
+		}
+	}
+
+	//guest sees what log says
+	edited := regexp.MustCompile(`\btime =0x\w+`).ReplaceAll(bytes.Replace(log.Bytes(), []byte(`"x"`), []byte(`"z"`), 1), []byte("time =0x1234"))
+	replay, _ = cpu.NewInputReplay(bytes.NewReader(edited))
+	replayed = boot(replay, "")
+	if replayed.Cpu.Register(19) != 'z' || replayed.Cpu.Register(22) != 0x1234 || replay.Err() != nil {
+		t.Errorf("\"TestVirtInputReplay()\" FAILED, rbr -> %x time -> %#x %v",
+			replayed.Cpu.Register(19), replayed.Cpu.Register(22), replay.Err())
+	}
+
+}
+
+func TestVirtInputSameCycle(t *testing.T) {
+
+	//lw is in memory slot while csrrs is in execute slot,
+	//both read their source in same cycle from own goroutine
+	var program = []uint32{
+		0x0200ce37, //lui t3, 0x200c
+		0xff8e0e13, //addi t3, t3, -8
+		0x000e2383, //lw t2, 0(t3)
+		0xc0102b73, //csrrs s6, time, x0
+		0x0000006f, //jal x0, 0
+	}
+	image := make([]byte, len(program)*4)
+	for i, v := range program {
+		binary.LittleEndian.PutUint32(image[i*4:], v)
+	}
+	boot := func(input *cpu.InputLog) *Virt {
+		virt := newTestVirt(t, &cpu.Cpu{}, &bytes.Buffer{})
+		virt.LoadImage(image, VIRT_DRAM_BASE)
+		virt.AttachInput(input)
+		for cycles := 0; cycles < 50; cycles++ {
+			virt.ClockCycle()
+		}
+		return virt
+	}
+
+	//reads of cycle are logged ordered by source
+	log := bytes.Buffer{}
+	boot(cpu.NewInputRecorder(&log))
+	same := regexp.MustCompile(`(?m)^(\d+) mtime =0x\w+\n(\d+) time =0x\w+\n`).FindSubmatch(log.Bytes())
+	if same == nil || !bytes.Equal(same[1], same[2]) {
+		t.Fatalf("\"TestVirtInputSameCycle()\" FAILED\n%s", log.String())
+	}
+
+	//replay matches reads of cycle in any order
+	cycle := string(same[1])
+	edited := strings.Replace(log.String(), string(same[0]), cycle+" time =0x2222\n"+cycle+" mtime =0x1111\n", 1)
+	replay, _ := cpu.NewInputReplay(strings.NewReader(edited))
+	replayed := boot(replay)
+	if replayed.Cpu.Register(7) != 0x1111 || replayed.Cpu.Register(22) != 0x2222 || replay.Err() != nil {
+		t.Errorf("\"TestVirtInputSameCycle()\" FAILED, mtime -> %#x time -> %#x %v",
+			replayed.Cpu.Register(7), replayed.Cpu.Register(22), replay.Err())
+	}
+
+}
+
+func TestVirtLoadElf(t *testing.T) {
+
+	//elf32 header and one load segment,bss spills over file data
+	image := virtBootImage()
+	bss := uint32(0x40)
+	header := make([]byte, 52+32)
+	copy(header, []byte{0x7f, 'E', 'L', 'F', 1, 1, 1})
+	binary.LittleEndian.PutUint16(header[16:], uint16(elf.ET_EXEC))
+	binary.LittleEndian.PutUint16(header[18:], uint16(elf.EM_RISCV))
+	binary.LittleEndian.PutUint32(header[20:], 1)
+	binary.LittleEndian.PutUint32(header[24:], VIRT_DRAM_BASE)
+	binary.LittleEndian.PutUint32(header[28:], 52)
+	binary.LittleEndian.PutUint16(header[40:], 52)
+	binary.LittleEndian.PutUint16(header[42:], 32)
+	binary.LittleEndian.PutUint16(header[44:], 1)
+	phdr := []uint32{uint32(elf.PT_LOAD), uint32(len(header)), VIRT_DRAM_BASE, VIRT_DRAM_BASE,
+		uint32(len(image)), uint32(len(image)) + bss, uint32(elf.PF_R | elf.PF_W | elf.PF_X), 4}
+	for i, v := range phdr {
+		binary.LittleEndian.PutUint32(header[52+i*4:], v)
+	}
+
+	console := &bytes.Buffer{}
+	virt := newTestVirt(t, &cpu.Cpu{}, console)
+	virt.LoadImage(bytes.Repeat([]byte{0xff}, int(bss)), VIRT_DRAM_BASE+uint64(len(image)))
+	virt.Cpu.SetPc(VIRT_DRAM_BASE + 0x1000)
+	if err := virt.LoadElf(bytes.NewReader(append(header, image...))); err != nil {
+		t.Fatal(err)
+	}
+	if b := virt.Cpu.Ram.Read(uint32(len(image))+bss-4, 4); b != 0 {
+		t.Errorf("\"TestVirtLoadElf()\" FAILED, bss -> %#x", b)
+	}
+	virt.Uart.Input([]byte("x"))
+	for cycles := 0; cycles < 5000 && !virt.Halted(); cycles++ {
+		virt.ClockCycle()
+	}
+	if !virt.Halted() || console.String() != "OK\n" {
+		t.Errorf("\"TestVirtLoadElf()\" FAILED, halted %v console -> %q", virt.Halted(), console.String())
+	}
+
+	if err := virt.LoadElf(bytes.NewReader(image)); err == nil {
+		t.Errorf("\"TestVirtLoadElf()\" FAILED, raw image accepted")
+	}
+
+}
+
+func TestVirtRamTooSmall(t *testing.T) {
+
+	if _, err := NewVirt(&cpu.Cpu{}, 0x100, nil); err == nil {
+		t.Errorf("\"TestVirtRamTooSmall()\" FAILED, device tree does not fit")
+	}
+
+}
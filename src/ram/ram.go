@@ -1,12 +1,56 @@
 package ram
 
+// size of zero value ram in bytes
+const DEFAULT_SIZE = 40000 * 4
+
 type Ram struct {
-	data [40000]uint32
+	data []uint32
+}
+
+// ram of size bytes rounded up to whole lines
+func NewRam(size uint32) Ram {
+	return Ram{data: make([]uint32, (uint64(size)+3)/4)}
+}
+
+func (ram *Ram) lines() []uint32 {
+	if ram.data == nil {
+		ram.data = make([]uint32, DEFAULT_SIZE/4)
+	}
+	return ram.data
+}
+
+// size in bytes,addresses from 0 up to size are backed
+func (ram *Ram) Size() uint64 {
+	return uint64(len(ram.lines())) * 4
+}
+
+// independent copy of contents
+func (ram *Ram) Clone() Ram {
+	return Ram{data: append([]uint32(nil), ram.lines()...)}
 }
 
 func (ram *Ram) GetLine(address uint32) uint32 {
-	return ram.data[address>>2]
+	return ram.lines()[address>>2]
 }
 func (ram *Ram) SetLine(address uint32, data uint32) {
-	ram.data[address>>2] = data
+	ram.lines()[address>>2] = data
+}
+
+// little endian access of size bytes at any byte address
+func (ram *Ram) Read(address uint32, size uint32) uint64 {
+	var val uint64
+	for i := uint32(0); i < size; i++ {
+		shift := ((address + i) & 3) * 8
+		val |= uint64(ram.GetLine(address+i)>>shift&0xFF) << (8 * i)
+	}
+	return val
+}
+
+func (ram *Ram) Write(address uint32, size uint32, val uint64) {
+	for i := uint32(0); i < size; i++ {
+		shift := ((address + i) & 3) * 8
+		line := ram.GetLine(address + i)
+		line = line&^(0xFF<<shift) | uint32(val>>(8*i)&0xFF)<<shift
+		ram.SetLine(address+i, line)
+	}
 }
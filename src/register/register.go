@@ -1,5 +1,7 @@
 package register
 
+import "sync/atomic"
+
 type Alias uint32
 
 const (
@@ -37,19 +39,42 @@ const (
 	t6
 )
 
+// registers are 64 bit wide,rv32 cpu keeps upper half zero.
+// decode reads while writeback writes in same cycle from
+// other goroutine,forwarding supplies value being written
 type RegisterFile struct {
-	registers [32]uint32
+	registers [32]uint64
+	embedded  bool //rv32e,only x0-x15 exist
+}
+
+func (reg *RegisterFile) SetEmbedded(embedded bool) {
+	reg.embedded = embedded
 }
 
-func (reg *RegisterFile) GetRegVal(register uint32) uint32 {
+// number of architectural registers
+func (reg *RegisterFile) Size() uint32 {
+	if reg.embedded {
+		return 16
+	}
+	return 32
+}
 
-	return reg.registers[register]
+func (reg *RegisterFile) Exists(register uint32) bool {
+	return register < reg.Size()
+}
+
+func (reg *RegisterFile) GetRegVal(register uint32) uint64 {
+
+	if !reg.Exists(register) {
+		return 0
+	}
+	return atomic.LoadUint64(&reg.registers[register])
 
 }
 
-func (reg *RegisterFile) SetRegVal(register uint32, val uint32) {
+func (reg *RegisterFile) SetRegVal(register uint32, val uint64) {
 
-	if register > 0 {
-		reg.registers[register] = val
+	if register > 0 && reg.Exists(register) {
+		atomic.StoreUint64(&reg.registers[register], val)
 	}
 }